@@ -8,12 +8,32 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Report formats which can be chosen with the format flag.
+const (
+	FormatHTML    = "html"
+	FormatGraphML = "graphml"
+	FormatJSON    = "json"
+	// FormatAssets lists every asset and whether it was reachable, probing each one.
+	FormatAssets = "assets"
+)
+
 // Config holds all configuation needed to start a spider.
 type Config struct {
 	Root         string        `mapstructure:"root"`
 	IgnoreRobots bool          `mapstructure:"ignore-robots"`
 	Concurrency  int           `mapstructure:"concurrency"`
 	Timeout      time.Duration `mapstructure:"timeout"`
+	Lenient      bool          `mapstructure:"lenient-parsing"`
+	Sitemap      bool          `mapstructure:"sitemap"`
+	Format       string        `mapstructure:"format"`
+	SeedFile     string        `mapstructure:"seed-file"`
+	MaxDepth     int           `mapstructure:"max-depth"`
+	SinglePage   bool          `mapstructure:"single-page"`
+	ForceHTTP1   bool          `mapstructure:"force-http1"`
+	Progress     bool          `mapstructure:"progress"`
+	Diff         string        `mapstructure:"diff"`
+	StatusAddr   string        `mapstructure:"status-addr"`
+	GroupByDir   bool          `mapstructure:"group-by-directory"`
 	RootURL      *url.URL
 }
 
@@ -43,5 +63,13 @@ func NewConfig(args map[string]interface{}) (*Config, error) {
 	}
 	conf.RootURL = rootURL
 
+	switch conf.Format {
+	case "":
+		conf.Format = FormatHTML
+	case FormatHTML, FormatGraphML, FormatJSON, FormatAssets:
+	default:
+		return nil, errors.Errorf("unknown report format %q", conf.Format)
+	}
+
 	return &conf, nil
 }
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Willyham/gospider/spider"
+	"go.uber.org/zap"
+)
+
+const (
+	// progressBarWidth is the number of characters in the progress bar.
+	progressBarWidth = 30
+	// ttyProgressInterval is how often the bar is redrawn on a terminal, and lineProgressInterval
+	// how often a line is written otherwise, so that log files don't fill up.
+	ttyProgressInterval  = time.Millisecond * 200
+	lineProgressInterval = time.Second * 10
+)
+
+// progress renders the progress of a crawl for the --progress flag. On a terminal it redraws a
+// progress bar in place, otherwise it writes a line every so often.
+type progress struct {
+	w   io.Writer
+	tty bool
+	// logger only logs warnings and errors, so that it doesn't drown out the progress.
+	logger *zap.Logger
+}
+
+func newProgress(w io.Writer, tty bool) (*progress, error) {
+	config := zap.NewProductionConfig()
+	config.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+	logger, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	return &progress{w: w, tty: tty, logger: logger}, nil
+}
+
+// isTerminal returns true if the file is a terminal rather than, say, a pipe or a file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// options returns the spider options which report progress and quiet the logs.
+func (p *progress) options() []spider.Option {
+	interval := lineProgressInterval
+	if p.tty {
+		interval = ttyProgressInterval
+	}
+	return []spider.Option{
+		spider.WithLogger(p.logger),
+		spider.WithProgress(interval, p.print),
+	}
+}
+
+// print writes the stats. The total is what has been found so far, so it grows as the crawl
+// goes on.
+func (p *progress) print(stats spider.RunStats) {
+	total := stats.Pages + stats.Errors + stats.InFlight + stats.Queued
+	done := stats.Pages + stats.Errors
+	line := fmt.Sprintf("%d/%d pages, %d errors, %d queued, %s",
+		done, total, stats.Errors, stats.Queued, stats.Duration.Truncate(time.Second))
+	if !p.tty {
+		fmt.Fprintln(p.w, line)
+		return
+	}
+
+	filled := progressBarWidth
+	if total > 0 {
+		filled = progressBarWidth * done / total
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+	// Clear the rest of the line in case the last one was longer.
+	fmt.Fprintf(p.w, "\r[%s] %s\x1b[K", bar, line)
+}
+
+// finish moves past the progress bar so that nothing else is written over it.
+func (p *progress) finish() {
+	if p.tty {
+		fmt.Fprintln(p.w)
+	}
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Willyham/gospider/spider"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestProgressOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			w.Write([]byte(`<a href="/about"></a>`))
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	bar, err := newProgress(buf, false)
+	require.NoError(t, err)
+	assert.False(t, bar.logger.Core().Enabled(zap.InfoLevel))
+	assert.True(t, bar.logger.Core().Enabled(zap.WarnLevel))
+
+	options := append([]spider.Option{spider.WithRoot(root), spider.WithIgnoreRobots(true)}, bar.options()...)
+	err = spider.New(options...).Run()
+	require.NoError(t, err)
+	bar.finish()
+
+	// The crawl is quicker than the interval, so there's only the final line.
+	assert.Equal(t, "2/2 pages, 0 errors, 0 queued, 0s\n", buf.String())
+}
+
+func TestProgressPrint(t *testing.T) {
+	stats := spider.RunStats{Pages: 4, Errors: 1, InFlight: 2, Queued: 3, Duration: time.Millisecond * 2500}
+
+	buf := bytes.NewBuffer(nil)
+	bar := &progress{w: buf}
+	bar.print(stats)
+	assert.Equal(t, "5/10 pages, 1 errors, 3 queued, 2s\n", buf.String())
+
+	buf.Reset()
+	bar = &progress{w: buf, tty: true}
+	bar.print(stats)
+	bar.finish()
+	assert.Equal(t, "\r[===============               ] 5/10 pages, 1 errors, 3 queued, 2s\x1b[K\n", buf.String())
+}
@@ -26,6 +26,8 @@ import (
 	"time"
 
 	"github.com/Willyham/gospider/spider"
+	"github.com/Willyham/gospider/spider/reporter"
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -40,14 +42,58 @@ var startCmd = &cobra.Command{
 			return err
 		}
 
-		spider := spider.New(
+		options := []spider.Option{
 			spider.WithRoot(conf.RootURL),
 			spider.WithIgnoreRobots(conf.IgnoreRobots),
 			spider.WithConcurrency(conf.Concurrency),
 			spider.WithTimeout(conf.Timeout),
-		)
+			spider.WithLenientParsing(conf.Lenient),
+			spider.WithSitemapSeeding(conf.Sitemap),
+			spider.WithMaxDepth(conf.MaxDepth),
+			spider.WithSinglePage(conf.SinglePage),
+			spider.WithForceHTTP1(conf.ForceHTTP1),
+		}
+		if conf.SeedFile != "" {
+			options = append(options, spider.WithSeedFile(conf.SeedFile))
+		}
+		if conf.StatusAddr != "" {
+			options = append(options, spider.WithStatusServer(conf.StatusAddr))
+		}
+		var bar *progress
+		if conf.Progress {
+			bar, err = newProgress(os.Stderr, isTerminal(os.Stderr))
+			if err != nil {
+				return err
+			}
+			options = append(options, bar.options()...)
+		}
+		switch {
+		case conf.Diff != "":
+			prev, err := readResultSet(conf.Diff)
+			if err != nil {
+				return err
+			}
+			options = append(options, spider.WithReporter(reporter.NewDiffReporter(prev)))
+		case conf.Format == FormatGraphML:
+			options = append(options, spider.WithReporter(reporter.NewGraphML()))
+		case conf.Format == FormatJSON:
+			options = append(options, spider.WithReporter(reporter.NewJSON()))
+		case conf.Format == FormatAssets:
+			options = append(options,
+				spider.WithReporter(reporter.NewAssetInventory()),
+				spider.WithAssetProbing(true),
+			)
+		case conf.GroupByDir:
+			html := reporter.NewHTML()
+			html.SetGroupByDirectory(true)
+			options = append(options, spider.WithReporter(html))
+		}
+		spider := spider.New(options...)
 
 		err = spider.Run()
+		if bar != nil {
+			bar.finish()
+		}
 		if err != nil {
 			log.Fatal("error running spider: ", err)
 		}
@@ -55,6 +101,20 @@ var startCmd = &cobra.Command{
 	},
 }
 
+// readResultSet reads a previous crawl saved with the json format.
+func readResultSet(path string) (reporter.ResultSet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return reporter.ResultSet{}, errors.Wrap(err, "failed to open previous result set")
+	}
+	defer f.Close()
+	set, err := reporter.ReadResultSet(f)
+	if err != nil {
+		return reporter.ResultSet{}, errors.Wrap(err, "failed to read previous result set")
+	}
+	return set, nil
+}
+
 func init() {
 	RootCmd.AddCommand(startCmd)
 
@@ -62,6 +122,17 @@ func init() {
 	startCmd.Flags().BoolP("ignore-robots", "i", false, "Ignore robots.txt")
 	startCmd.Flags().IntP("concurrency", "c", 1, "number of workers to fetch with")
 	startCmd.Flags().DurationP("timeout", "t", time.Second*5, "request timeout")
+	startCmd.Flags().BoolP("lenient-parsing", "l", false, "fall back to regex parsing for broken pages")
+	startCmd.Flags().BoolP("sitemap", "s", false, "also crawl pages listed in sitemap.xml")
+	startCmd.Flags().StringP("format", "f", FormatHTML, "report format, html, graphml, json or assets")
+	startCmd.Flags().String("seed-file", "", "file of URLs to crawl along with the root, one per line")
+	startCmd.Flags().String("diff", "", "report changes since a previous crawl saved with --format json, instead of a normal report")
+	startCmd.Flags().String("status-addr", "", "address to serve /healthz and /status on while crawling, e.g. :8080")
+	startCmd.Flags().Bool("group-by-directory", false, "group pages in the html report by their top level directory")
+	startCmd.Flags().Int("max-depth", -1, "how many links away from the root or a seed to crawl, -1 for no limit")
+	startCmd.Flags().Bool("single-page", false, "only fetch the root and any seeds, reporting their links without following them")
+	startCmd.Flags().Bool("force-http1", false, "only use HTTP/1.1, for servers which misbehave over HTTP/2")
+	startCmd.Flags().Bool("progress", false, "show progress on stderr instead of logging every URL")
 
 	bind := func(flag string) {
 		viper.BindPFlag(flag, startCmd.Flags().Lookup(flag))
@@ -71,4 +142,15 @@ func init() {
 	bind("ignore-robots")
 	bind("concurrency")
 	bind("timeout")
+	bind("lenient-parsing")
+	bind("sitemap")
+	bind("format")
+	bind("seed-file")
+	bind("max-depth")
+	bind("single-page")
+	bind("force-http1")
+	bind("progress")
+	bind("diff")
+	bind("status-addr")
+	bind("group-by-directory")
 }
@@ -0,0 +1,117 @@
+package spider
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// adaptiveWindowSize is how many request outcomes are considered before adjusting the limit.
+	adaptiveWindowSize = 10
+	// adaptiveErrorRate is the proportion of failed requests in a window above which we back off.
+	adaptiveErrorRate = 0.2
+	// adaptiveLatencyFactor is how many times slower than the baseline a window can be before
+	// we back off.
+	adaptiveLatencyFactor = 2
+	// adaptiveBaselineDecay is how many windows it takes the baseline to catch up with slower
+	// latencies, so that one unusually fast window doesn't set it for the rest of the crawl.
+	adaptiveBaselineDecay = 10
+)
+
+// adaptiveLimiter limits how many workers may make requests at once, using AIMD to adjust
+// the limit between min and max. After each window of requests, the limit is halved if the
+// error rate or latency spiked, and increased by one otherwise. Latency is compared with a
+// baseline which follows the fastest windows, but decays towards slower ones. A nil
+// adaptiveLimiter never limits.
+type adaptiveLimiter struct {
+	min    int
+	max    int
+	limit  int
+	active int
+
+	failures     int
+	total        int
+	totalLatency time.Duration
+	baseline     time.Duration
+	sync.Mutex
+}
+
+func newAdaptiveLimiter(min, max int) *adaptiveLimiter {
+	if min < 1 {
+		min = 1
+	}
+	if max < min {
+		max = min
+	}
+	return &adaptiveLimiter{
+		min:   min,
+		max:   max,
+		limit: max,
+	}
+}
+
+// acquire claims a slot to make a request, returning false if the limit has been reached.
+func (l *adaptiveLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.active >= l.limit {
+		return false
+	}
+	l.active++
+	return true
+}
+
+// release gives up a slot.
+func (l *adaptiveLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	l.active--
+	l.Unlock()
+}
+
+// record records the outcome of a request. Every attempt at a page is recorded, so failures
+// which are retried still count.
+func (l *adaptiveLimiter) record(err error, latency time.Duration) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.total++
+	l.totalLatency += latency
+	if err != nil {
+		l.failures++
+	}
+	if l.total < adaptiveWindowSize {
+		return
+	}
+
+	avgLatency := l.totalLatency / time.Duration(l.total)
+	if l.baseline == 0 || avgLatency < l.baseline {
+		l.baseline = avgLatency
+	}
+	errorRate := float64(l.failures) / float64(l.total)
+	if errorRate > adaptiveErrorRate || avgLatency > l.baseline*adaptiveLatencyFactor {
+		l.limit /= 2
+		if l.limit < l.min {
+			l.limit = l.min
+		}
+	} else if l.limit < l.max {
+		l.limit++
+	}
+	l.baseline += (avgLatency - l.baseline) / adaptiveBaselineDecay
+
+	l.failures, l.total, l.totalLatency = 0, 0, 0
+}
+
+// current returns the current limit.
+func (l *adaptiveLimiter) current() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.limit
+}
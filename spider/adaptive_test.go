@@ -0,0 +1,149 @@
+package spider
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Willyham/gospider/spider/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdaptiveLimiterAcquire(t *testing.T) {
+	l := newAdaptiveLimiter(1, 2)
+	assert.True(t, l.acquire())
+	assert.True(t, l.acquire())
+	assert.False(t, l.acquire())
+
+	l.release()
+	assert.True(t, l.acquire())
+}
+
+func TestAdaptiveLimiterBounds(t *testing.T) {
+	l := newAdaptiveLimiter(0, -1)
+	assert.Equal(t, 1, l.min)
+	assert.Equal(t, 1, l.max)
+}
+
+func TestAdaptiveLimiterErrors(t *testing.T) {
+	l := newAdaptiveLimiter(2, 16)
+	assert.Equal(t, 16, l.current())
+
+	release := func(err error, latency time.Duration) {
+		for i := 0; i < adaptiveWindowSize; i++ {
+			l.acquire()
+			l.record(err, latency)
+			l.release()
+		}
+	}
+
+	// Multiplicative decrease, bounded by min.
+	release(assert.AnError, time.Millisecond)
+	assert.Equal(t, 8, l.current())
+	release(assert.AnError, time.Millisecond)
+	release(assert.AnError, time.Millisecond)
+	release(assert.AnError, time.Millisecond)
+	assert.Equal(t, 2, l.current())
+
+	// Additive increase.
+	release(nil, time.Millisecond)
+	assert.Equal(t, 3, l.current())
+	release(nil, time.Millisecond)
+	assert.Equal(t, 4, l.current())
+}
+
+func TestAdaptiveLimiterLatency(t *testing.T) {
+	l := newAdaptiveLimiter(1, 4)
+	for i := 0; i < adaptiveWindowSize; i++ {
+		l.record(nil, time.Millisecond)
+	}
+	assert.Equal(t, 4, l.current())
+
+	for i := 0; i < adaptiveWindowSize; i++ {
+		l.record(nil, time.Millisecond*10)
+	}
+	assert.Equal(t, 2, l.current())
+}
+
+func TestAdaptiveLimiterBaselineDecays(t *testing.T) {
+	l := newAdaptiveLimiter(1, 4)
+	window := func(latency time.Duration) {
+		for i := 0; i < adaptiveWindowSize; i++ {
+			l.record(nil, latency)
+		}
+	}
+
+	// One unusually fast window sets the baseline, so normal windows look slow at first.
+	window(time.Millisecond)
+	window(time.Millisecond * 10)
+	assert.Equal(t, 2, l.current())
+
+	// But the baseline catches up with them, and concurrency ramps back up.
+	for i := 0; i < adaptiveBaselineDecay*3; i++ {
+		window(time.Millisecond * 10)
+	}
+	assert.Equal(t, 4, l.current())
+}
+
+func TestNilAdaptiveLimiter(t *testing.T) {
+	var l *adaptiveLimiter
+	assert.True(t, l.acquire())
+	assert.NotPanics(t, func() {
+		l.release()
+		l.record(nil, time.Second)
+	})
+}
+
+func TestWorkerAdaptiveConcurrency(t *testing.T) {
+	requester := &mocks.Requester{}
+	requester.On("Do", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil, assert.AnError)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithAdaptiveConcurrency(1, 8),
+	)
+
+	for i := 0; i < adaptiveWindowSize; i++ {
+		s.queue.Append(willydURL)
+		s.pending.Add(1)
+		err := s.work()
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 4, s.limiter.current())
+}
+
+func TestRunAdaptiveConcurrency(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		for i := 0; i < adaptiveWindowSize*2; i++ {
+			fmt.Fprintf(w, `<a href="/%d"></a>`, i)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// The retry duration is too short to retry, but failures are given up on rather than
+	// stopping the crawl.
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithAdaptiveConcurrency(1, 8),
+		WithMaxRetryDuration(time.Millisecond),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	// The root and nine failures halve the limit, then ten more failures halve it again.
+	assert.Equal(t, 2, s.limiter.current())
+}
@@ -0,0 +1,213 @@
+package spider
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// crawlState is what WithAutoSave writes to disk: every request seen so far, and those which
+// still need to be crawled.
+type crawlState struct {
+	Seen    []string    `json:"seen"`
+	Pending []savedItem `json:"pending"`
+}
+
+// savedItem is a queueItem as it's saved.
+type savedItem struct {
+	URL      string `json:"url"`
+	Method   string `json:"method,omitempty"`
+	Body     string `json:"body,omitempty"`
+	Depth    int    `json:"depth,omitempty"`
+	Referrer string `json:"referrer,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+	Root     bool   `json:"root,omitempty"`
+}
+
+func newSavedItem(item *queueItem) savedItem {
+	saved := savedItem{
+		URL:      item.url.String(),
+		Method:   item.method,
+		Body:     item.body.Encode(),
+		Depth:    item.depth,
+		Priority: item.priority,
+		Root:     item.root,
+	}
+	if item.referrer != nil {
+		saved.Referrer = item.referrer.String()
+	}
+	return saved
+}
+
+func (i savedItem) queueItem() (*queueItem, error) {
+	uri, err := url.Parse(i.URL)
+	if err != nil {
+		return nil, err
+	}
+	item := &queueItem{url: uri, method: i.Method, depth: i.Depth, priority: i.Priority, root: i.Root}
+	if i.Body != "" {
+		item.body, err = url.ParseQuery(i.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if i.Referrer != "" {
+		item.referrer, err = url.Parse(i.Referrer)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+// snapshot returns the queue's state. Items which are in flight are pending, since they
+// haven't been crawled yet, as are any spilled to disk.
+func (q *urlQueue) snapshot() (crawlState, error) {
+	q.RLock()
+	defer q.RUnlock()
+	state := crawlState{
+		Seen:    make([]string, 0, len(q.seen)),
+		Pending: make([]savedItem, 0, len(q.items)+len(q.prioritized)+len(q.inFlight)),
+	}
+	for key := range q.seen {
+		state.Seen = append(state.Seen, key)
+	}
+	for _, item := range q.items {
+		state.Pending = append(state.Pending, newSavedItem(item))
+	}
+	for _, item := range q.prioritized {
+		state.Pending = append(state.Pending, newSavedItem(item))
+	}
+	for item := range q.inFlight {
+		state.Pending = append(state.Pending, newSavedItem(item))
+	}
+	if q.spilled() > 0 {
+		spilled, err := q.spill.pending()
+		if err != nil {
+			return crawlState{}, err
+		}
+		state.Pending = append(state.Pending, spilled...)
+	}
+	return state, nil
+}
+
+// restore adds the saved pending items to the queue and marks the saved requests as seen. It
+// returns the number of items added.
+func (q *urlQueue) restore(state crawlState) (int, error) {
+	items := make([]*queueItem, len(state.Pending))
+	for i, saved := range state.Pending {
+		item, err := saved.queueItem()
+		if err != nil {
+			return 0, errors.Wrap(err, "invalid saved request")
+		}
+		items[i] = item
+	}
+
+	q.Lock()
+	defer q.Unlock()
+	for _, key := range state.Seen {
+		q.seen[key] = true
+	}
+	for _, item := range items {
+		q.seen[q.requestKey(item)] = true
+		q.spillOrPush(item)
+	}
+	return len(items), nil
+}
+
+// saveState writes the crawl state to the WithAutoSave path. It's written to a temporary file
+// which replaces the old one, so a crash part way through never leaves a broken file.
+func (s *Spider) saveState() error {
+	state, err := s.queue.snapshot()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(s.autoSavePath), filepath.Base(s.autoSavePath)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create state file")
+	}
+	defer os.Remove(f.Name())
+
+	err = json.NewEncoder(f).Encode(state)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write state file")
+	}
+	return errors.Wrap(os.Rename(f.Name(), s.autoSavePath), "failed to replace state file")
+}
+
+// resume restores the crawl state saved at the WithAutoSave path, if there is one. It returns
+// false if there is nothing to resume, in which case the crawl starts from the root.
+func (s *Spider) resume() (bool, error) {
+	data, err := ioutil.ReadFile(s.autoSavePath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read state file")
+	}
+	var state crawlState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return false, errors.Wrap(err, "failed to parse state file")
+	}
+	if len(state.Pending) == 0 {
+		return false, nil
+	}
+
+	s.pending.Add(len(state.Pending))
+	n, err := s.queue.restore(state)
+	if err != nil {
+		s.pending.Add(-len(state.Pending))
+		return false, err
+	}
+	s.logger.Info("Resuming saved crawl", zap.String("path", s.autoSavePath), zap.Int("pending", n))
+	return true, nil
+}
+
+// startAutoSave saves the crawl state every interval until the returned function is called
+// with the crawl's outcome. A crawl which finished has nothing to resume, so its state is
+// removed, otherwise the state is saved one last time.
+func (s *Spider) startAutoSave() func(err error) {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		if s.autoSaveInterval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(s.autoSaveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.saveState(); err != nil {
+					s.logger.Warn("Failed to save crawl state", zap.Error(err))
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func(err error) {
+		close(stop)
+		<-stopped
+		if err == nil {
+			if err := os.Remove(s.autoSavePath); err != nil && !os.IsNotExist(err) {
+				s.logger.Warn("Failed to remove crawl state", zap.Error(err))
+			}
+			return
+		}
+		if err := s.saveState(); err != nil {
+			s.logger.Warn("Failed to save crawl state", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package spider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Willyham/gospider/spider/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readState reads a saved crawl state, returning false if there isn't a complete one.
+func readState(t *testing.T, path string) (crawlState, bool) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return crawlState{}, false
+	}
+	require.NoError(t, err)
+	var state crawlState
+	require.NoError(t, json.Unmarshal(data, &state))
+	return state, true
+}
+
+func pendingURLs(state crawlState) []string {
+	var urls []string
+	for _, item := range state.Pending {
+		urls = append(urls, item.URL)
+	}
+	return urls
+}
+
+func TestRunAutoSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "autosave")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	var lock sync.Mutex
+	var fetched []string
+	blocked := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched = append(fetched, r.URL.Path)
+		block := blocked && r.URL.Path == "/slow"
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/a"></a><a href="/slow"></a>`)
+		case "/slow":
+			if block {
+				<-r.Context().Done()
+				return
+			}
+			fmt.Fprint(w, `<a href="/b"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	slow := server.URL + "/slow"
+
+	options := []Option{
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithAutoSave(path, time.Millisecond*10),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- New(options...).RunContext(ctx)
+	}()
+
+	// Wait for a snapshot taken while the slow page is in flight. The other link is still
+	// queued, and both are pending.
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		state, ok := readState(t, path)
+		if ok && len(state.Pending) == 2 {
+			assert.ElementsMatch(t, []string{server.URL + "/a", slow}, pendingURLs(state))
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "no snapshot written")
+		time.Sleep(time.Millisecond * 10)
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-errs)
+
+	state, ok := readState(t, path)
+	require.True(t, ok)
+	assert.ElementsMatch(t, []string{server.URL, server.URL + "/a", slow}, state.Seen)
+	assert.ElementsMatch(t, []string{server.URL + "/a", slow}, pendingURLs(state))
+
+	// Resuming only crawls what was left, and removes the state once done.
+	lock.Lock()
+	fetched = nil
+	blocked = false
+	lock.Unlock()
+	require.NoError(t, New(options...).Run())
+	assert.ElementsMatch(t, []string{"/a", "/slow", "/b"}, fetched)
+	_, ok = readState(t, path)
+	assert.False(t, ok)
+}
+
+func TestRunFailedPageNotPending(t *testing.T) {
+	missing, err := url.Parse("http://nope.willdemaine.co.uk/foo")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="http://nope.willdemaine.co.uk/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+	onGet(requester, missing).Return(nil, dnsErr)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+	)
+	require.NoError(t, s.Run())
+
+	// The page which failed is finished with, so resuming wouldn't fetch it again.
+	state, err := s.queue.snapshot()
+	require.NoError(t, err)
+	assert.Empty(t, state.Pending)
+	assert.Contains(t, state.Seen, missing.String())
+}
@@ -0,0 +1,62 @@
+package spider
+
+import (
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// browserProfiles are the headers sent by common browsers when navigating to a page. Each is
+// coherent, so the user agent matches the other headers. Accept-Encoding is left out so that
+// responses are still decompressed for us.
+var browserProfiles = []http.Header{
+	{
+		"User-Agent":                {"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.0.0 Safari/537.36"},
+		"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"},
+		"Accept-Language":           {"en-US,en;q=0.9"},
+		"Sec-Ch-Ua":                 {`"Chromium";v="124", "Google Chrome";v="124", "Not-A.Brand";v="99"`},
+		"Sec-Ch-Ua-Mobile":          {"?0"},
+		"Sec-Ch-Ua-Platform":        {`"Windows"`},
+		"Upgrade-Insecure-Requests": {"1"},
+	},
+	{
+		"User-Agent":                {"Mozilla/5.0 (X11; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0"},
+		"Accept":                    {"text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"},
+		"Accept-Language":           {"en-US,en;q=0.5"},
+		"Upgrade-Insecure-Requests": {"1"},
+	},
+	{
+		"User-Agent":      {"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15"},
+		"Accept":          {"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		"Accept-Language": {"en-GB,en;q=0.9"},
+	},
+}
+
+// requestHeader returns the headers to send with a request for the page: the next browser
+// profile if WithBrowserHeaders is set, overridden by any from WithHeaderFunc.
+func (s *Spider) requestHeader(uri *url.URL) http.Header {
+	var header http.Header
+	if s.browserHeaders {
+		n := atomic.AddUint32(&s.browserProfile, 1)
+		header = cloneHeader(browserProfiles[int(n-1)%len(browserProfiles)])
+	}
+	if s.headerFunc == nil {
+		return header
+	}
+	extra := s.headerFunc(uri)
+	if header == nil {
+		return extra
+	}
+	for key, values := range extra {
+		header[key] = values
+	}
+	return header
+}
+
+func cloneHeader(header http.Header) http.Header {
+	clone := make(http.Header, len(header))
+	for key, values := range header {
+		clone[key] = append([]string(nil), values...)
+	}
+	return clone
+}
@@ -1,12 +1,20 @@
 package spider
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -19,49 +27,252 @@ func (e httpResponseError) Error() string {
 	return "http response error: " + strconv.Itoa(e.statusCode)
 }
 
+// maxRedirects is how many redirects we follow for one request, the same as the http package.
+const maxRedirects = 10
+
+// redirectLoopError is returned when following redirects leads back to a URL we've already visited.
+type redirectLoopError struct {
+	// chain is every URL visited, ending with the repeated one.
+	chain []*url.URL
+}
+
+func (e redirectLoopError) Error() string {
+	urls := make([]string, len(e.chain))
+	for i, uri := range e.chain {
+		urls[i] = uri.String()
+	}
+	return "redirect loop: " + strings.Join(urls, " -> ")
+}
+
+// redirectHop is a URL visited on the way to a page, and the status it responded with.
+type redirectHop struct {
+	url        *url.URL
+	statusCode int
+}
+
+// redirectChain returns every hop taken to get the response, ending with the final URL, or nil if
+// there were no redirects. The client keeps the redirect response which led to each request, so
+// the chain is followed back from the last one.
+func redirectChain(res *http.Response) []redirectHop {
+	if res.Request == nil || res.Request.Response == nil {
+		return nil
+	}
+	chain := []redirectHop{{url: res.Request.URL, statusCode: res.StatusCode}}
+	for req := res.Request; req.Response != nil && req.Response.Request != nil; req = req.Response.Request {
+		chain = append(chain, redirectHop{url: req.Response.Request.URL, statusCode: req.Response.StatusCode})
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// checkRedirect is used as the http client's CheckRedirect. It stops following redirects as soon
+// as they loop, rather than going round until the redirect limit.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	for _, prev := range via {
+		if prev.URL.String() != req.URL.String() {
+			continue
+		}
+		chain := make([]*url.URL, 0, len(via)+1)
+		for _, r := range via {
+			chain = append(chain, r.URL)
+		}
+		return redirectLoopError{chain: append(chain, req.URL)}
+	}
+	if len(via) >= maxRedirects {
+		return errors.New("stopped after " + strconv.Itoa(maxRedirects) + " redirects")
+	}
+	return nil
+}
+
 // Requester is something that can make a request.
+//
+// Do makes a request with the given method, body and headers. Responses with a non-2XX status
+// are returned as errors. Otherwise the caller is responsible for closing the response body.
 type Requester interface {
-	Request(ctx context.Context, uri *url.URL) ([]byte, error)
+	Do(ctx context.Context, method string, uri *url.URL, body io.Reader, headers http.Header) (*http.Response, error)
 	SetUserAgent(agent string)
 }
 
 //go:generate mockery -name Requester -case underscore
 
+// Get is a convenience which makes a GET request for the uri and reads the whole body.
+func Get(ctx context.Context, r Requester, uri *url.URL) ([]byte, error) {
+	res, err := r.Do(ctx, http.MethodGet, uri, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+// maxPooledBodySize is the largest buffer we put back in the pool, so that one huge page
+// doesn't pin a lot of memory for the rest of the crawl.
+const maxPooledBodySize = 1 << 20
+
+// bodyPool holds buffers for reading response bodies, which saves allocating a new one for
+// every page on large crawls.
+var bodyPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// doPooled makes the request and reads the whole body into a buffer from the pool. The
+// response is returned for its status and headers, but its body has been closed. The caller
+// must return the buffer with putBody once nothing refers to its bytes.
+func doPooled(ctx context.Context, r Requester, req Request) (*bytes.Buffer, *http.Response, error) {
+	res, err := req.do(ctx, r)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+
+	buf := bodyPool.Get().(*bytes.Buffer)
+	_, err = buf.ReadFrom(res.Body)
+	if err != nil {
+		putBody(buf)
+		return nil, nil, err
+	}
+	return buf, res, nil
+}
+
+// putBody returns a buffer from doPooled to the pool.
+func putBody(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBodySize {
+		return
+	}
+	buf.Reset()
+	bodyPool.Put(buf)
+}
+
+// Defaults for the transport, which are the same as http.DefaultTransport's.
+const (
+	defaultConnectTimeout = time.Second * 30
+	keepAlive             = time.Second * 30
+	tlsHandshakeTimeout   = time.Second * 10
+	idleConnTimeout       = time.Second * 90
+	maxIdleConns          = 100
+)
+
+// DialFunc makes a network connection, like net.Dialer's DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// transportConfig holds the options which need a custom transport. The zero value behaves
+// like http.DefaultTransport.
+type transportConfig struct {
+	// connectTimeout is the default connect timeout if zero.
+	connectTimeout time.Duration
+	// responseHeaderTimeout is no timeout if zero.
+	responseHeaderTimeout time.Duration
+	// resolver is the default resolver if nil.
+	resolver *net.Resolver
+	// dial replaces the dialer, along with its connect timeout and resolver, if set.
+	dial DialFunc
+	// forceHTTP1 disables HTTP/2, which is otherwise used if the server supports it.
+	forceHTTP1 bool
+}
+
+func (c transportConfig) isZero() bool {
+	return c.connectTimeout == 0 && c.responseHeaderTimeout == 0 && c.resolver == nil && c.dial == nil &&
+		!c.forceHTTP1
+}
+
+// newDialer creates the dialer used unless the config replaces it.
+func newDialer(config transportConfig) *net.Dialer {
+	connectTimeout := config.connectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	return &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: keepAlive,
+		Resolver:  config.resolver,
+	}
+}
+
+// newTransport creates a transport like http.DefaultTransport, with the given config.
+func newTransport(config transportConfig) *http.Transport {
+	dial := config.dial
+	if dial == nil {
+		dial = newDialer(config).DialContext
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dial,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   tlsHandshakeTimeout,
+		ResponseHeaderTimeout: config.responseHeaderTimeout,
+	}
+	if config.forceHTTP1 {
+		// A non-nil map stops the transport from setting up HTTP/2.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
 type client struct {
 	client    *http.Client
 	logger    *zap.Logger
 	userAgent string
+	// propagate, if set, is called with every request, e.g. to inject tracing headers.
+	propagate func(ctx context.Context, req *http.Request)
+	// trace, if set, is called with the connection timings of every request.
+	trace func(uri *url.URL, timings TraceTimings)
 }
 
 func (c client) SetUserAgent(agent string) {
 	c.userAgent = agent
 }
 
-func (c client) Request(ctx context.Context, uri *url.URL) ([]byte, error) {
+func (c client) Do(ctx context.Context, method string, uri *url.URL, body io.Reader, headers http.Header) (*http.Response, error) {
 	if uri == nil {
 		return nil, errors.New("must provide uri to request")
 	}
 
-	c.logger.Info("Fetching URL", zap.String("url", uri.String()))
-	// Ignore this error as it's not possible to trigger with a valid URL and a constant method.
-	req, _ := http.NewRequest(http.MethodGet, uri.String(), nil)
+	c.logger.Info("Fetching URL", zap.String("method", method), zap.String("url", uri.String()))
+	req, err := http.NewRequest(method, uri.String(), body)
+	if err != nil {
+		return nil, err
+	}
 	req = req.WithContext(ctx)
-	req.Header.Set("User-Agent", c.userAgent)
+	for key, values := range headers {
+		req.Header[key] = values
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.propagate != nil {
+		c.propagate(ctx, req)
+	}
+	if c.trace != nil {
+		trace := newRequestTrace()
+		req = req.WithContext(httptrace.WithClientTrace(ctx, trace.clientTrace()))
+		defer func() {
+			c.trace(uri, trace.result())
+		}()
+	}
 
 	res, err := c.client.Do(req)
 	if err != nil {
+		// Errors from checkRedirect come back wrapped, but loops are worth telling apart.
+		if urlErr, ok := err.(*url.Error); ok {
+			if loopErr, ok := urlErr.Err.(redirectLoopError); ok {
+				return nil, loopErr
+			}
+		}
 		return nil, err
 	}
 
-	if res.StatusCode != 200 {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
 		return nil, httpResponseError{
 			statusCode: res.StatusCode,
 		}
 	}
-
-	data, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	return data, nil
+	return res, nil
 }
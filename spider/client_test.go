@@ -1,12 +1,19 @@
 package spider
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -29,17 +36,161 @@ func TestRequest(t *testing.T) {
 		logger:    zap.NewNop(),
 		userAgent: "foo",
 	}
-	res, err := c.Request(context.Background(), uri)
+	res, err := Get(context.Background(), c, uri)
 	assert.NoError(t, err)
 	assert.Equal(t, []byte("Foo"), res)
 }
 
+func TestRequestPropagation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Header.Get("Traceparent"))
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	type traceKey struct{}
+	calls := 0
+	c := client{
+		client: http.DefaultClient,
+		logger: zap.NewNop(),
+		propagate: func(ctx context.Context, req *http.Request) {
+			calls++
+			req.Header.Set("Traceparent", ctx.Value(traceKey{}).(string))
+		},
+	}
+	for _, trace := range []string{"trace-1", "trace-2"} {
+		ctx := context.WithValue(context.Background(), traceKey{}, trace)
+		res, err := Get(ctx, c, uri)
+		assert.NoError(t, err)
+		assert.Equal(t, trace, string(res))
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestRequestTrace(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 10)
+	}))
+	defer server.Close()
+
+	// Use a host name rather than the server's IP so that there's a DNS lookup.
+	uri, err := url.Parse(strings.Replace(server.URL, "127.0.0.1", "localhost", 1))
+	require.NoError(t, err)
+
+	transport := newTransport(transportConfig{})
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	defer transport.CloseIdleConnections()
+
+	var traced []TraceTimings
+	c := client{
+		client: &http.Client{Transport: transport},
+		logger: zap.NewNop(),
+		trace: func(traceURI *url.URL, timings TraceTimings) {
+			assert.Equal(t, uri, traceURI)
+			traced = append(traced, timings)
+		},
+	}
+	for i := 0; i < 2; i++ {
+		_, err = Get(context.Background(), c, uri)
+		require.NoError(t, err)
+	}
+
+	require.Len(t, traced, 2)
+	first := traced[0]
+	assert.False(t, first.ReusedConn)
+	assert.True(t, first.DNS > 0, "DNS %s", first.DNS)
+	assert.True(t, first.Connect > 0, "connect %s", first.Connect)
+	assert.True(t, first.TLS > 0, "TLS %s", first.TLS)
+	assert.True(t, first.TTFB >= time.Millisecond*10, "TTFB %s", first.TTFB)
+	assert.True(t, first.TTFB > first.TLS)
+
+	// The second request reuses the connection, so only waits for the response.
+	second := traced[1]
+	assert.True(t, second.ReusedConn)
+	assert.Zero(t, second.DNS)
+	assert.Zero(t, second.Connect)
+	assert.Zero(t, second.TLS)
+	assert.True(t, second.TTFB >= time.Millisecond*10, "TTFB %s", second.TTFB)
+}
+
+func TestRunHTTPTrace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/foo"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	traced := make(map[string]TraceTimings)
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithHTTPTrace(func(uri *url.URL, timings TraceTimings) {
+			lock.Lock()
+			defer lock.Unlock()
+			traced[uri.Path] = timings
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	// Every page fetched by the spider's own client is traced.
+	require.Len(t, traced, 2)
+	assert.True(t, traced[""].TTFB > 0)
+	assert.True(t, traced["/foo"].TTFB > 0)
+}
+
+func TestConnectTimeout(t *testing.T) {
+	assert.Equal(t, defaultConnectTimeout, newDialer(transportConfig{}).Timeout)
+
+	s := New(WithRoot(willydURL), WithConnectTimeout(time.Second*2))
+	assert.Equal(t, time.Second*2, newDialer(s.transport).Timeout)
+	// The spider's client gets a transport which uses it.
+	c, ok := s.requester.(client)
+	require.True(t, ok)
+	assert.NotNil(t, c.client.Transport)
+}
+
+func TestTransportForceHTTP1(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		forceHTTP1 bool
+		proto      int
+	}{
+		{"default", false, 2},
+		{"forced", true, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			transport := newTransport(transportConfig{forceHTTP1: test.forceHTTP1})
+			transport.TLSClientConfig = server.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+			defer transport.CloseIdleConnections()
+
+			res, err := (&http.Client{Transport: transport}).Get(server.URL)
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, test.proto, res.ProtoMajor)
+		})
+	}
+}
+
 func TestRequestNoURI(t *testing.T) {
 	c := client{
 		client: http.DefaultClient,
 		logger: zap.NewNop(),
 	}
-	_, err := c.Request(context.Background(), nil)
+	_, err := Get(context.Background(), c, nil)
 	assert.Error(t, err)
 }
 
@@ -56,10 +207,146 @@ func TestRequestError(t *testing.T) {
 		client: http.DefaultClient,
 		logger: zap.NewNop(),
 	}
-	_, err = c.Request(context.Background(), uri)
+	_, err = Get(context.Background(), c, uri)
 	assert.Error(t, err)
 	httpErr, ok := err.(httpResponseError)
 	assert.True(t, ok)
 	assert.Equal(t, 500, httpErr.statusCode)
 	assert.Equal(t, "http response error: 500", httpErr.Error())
 }
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Custom", r.Header.Get("X-Custom"))
+		fmt.Fprint(w, string(body))
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	c := client{
+		client: http.DefaultClient,
+		logger: zap.NewNop(),
+	}
+
+	cases := []struct {
+		name     string
+		method   string
+		body     string
+		expected string
+	}{
+		{"get", http.MethodGet, "", ""},
+		{"head", http.MethodHead, "", ""},
+		{"post", http.MethodPost, "user=foo", "user=foo"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			headers := http.Header{"X-Custom": {test.name}}
+			res, err := c.Do(context.Background(), test.method, uri, strings.NewReader(test.body), headers)
+			require.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, test.method, res.Header.Get("X-Method"))
+			assert.Equal(t, test.name, res.Header.Get("X-Custom"))
+
+			body, err := ioutil.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(body))
+		})
+	}
+}
+
+func TestDoInvalidMethod(t *testing.T) {
+	c := client{
+		client: http.DefaultClient,
+		logger: zap.NewNop(),
+	}
+	_, err := c.Do(context.Background(), "BAD METHOD", willydURL, nil, nil)
+	assert.Error(t, err)
+}
+
+// staticRequester responds to every request with the same body.
+type staticRequester struct {
+	body []byte
+}
+
+func (r staticRequester) Do(context.Context, string, *url.URL, io.Reader, http.Header) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(r.body)),
+	}, nil
+}
+
+func (r staticRequester) SetUserAgent(string) {}
+
+func TestDoPooled(t *testing.T) {
+	r := staticRequester{body: []byte("Foo")}
+	buf, _, err := doPooled(context.Background(), r, Request{URL: willydURL})
+	require.NoError(t, err)
+	assert.Equal(t, "Foo", buf.String())
+	putBody(buf)
+
+	// A reused buffer must not contain the previous body.
+	r.body = []byte("Ba")
+	buf, _, err = doPooled(context.Background(), r, Request{URL: willydURL})
+	require.NoError(t, err)
+	assert.Equal(t, "Ba", buf.String())
+	putBody(buf)
+}
+
+var benchmarkBody = bytes.Repeat([]byte(`<a href="/foo/bar"></a>`), 4096)
+
+func BenchmarkGet(b *testing.B) {
+	r := staticRequester{body: benchmarkBody}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := Get(context.Background(), r, willydURL)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDoPooled(b *testing.B) {
+	r := staticRequester{body: benchmarkBody}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _, err := doPooled(context.Background(), r, Request{URL: willydURL})
+		if err != nil {
+			b.Fatal(err)
+		}
+		putBody(buf)
+	}
+}
+
+func TestRequestRedirectLoop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/a":
+			http.Redirect(w, r, "/b", http.StatusFound)
+		case "/b":
+			http.Redirect(w, r, "/a", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	uri, err := url.Parse(server.URL + "/a")
+	require.NoError(t, err)
+
+	c := client{
+		client: &http.Client{CheckRedirect: checkRedirect},
+		logger: zap.NewNop(),
+	}
+	_, err = Get(context.Background(), c, uri)
+	require.Error(t, err)
+	loopErr, ok := err.(redirectLoopError)
+	require.True(t, ok)
+	assert.Equal(t, fmt.Sprintf("redirect loop: %[1]s/a -> %[1]s/b -> %[1]s/a", server.URL), loopErr.Error())
+}
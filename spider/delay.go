@@ -0,0 +1,135 @@
+package spider
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// hostLatencies records how long the last request to each host took. It is safe for
+// concurrent use.
+type hostLatencies struct {
+	latencies map[string]time.Duration
+	sync.Mutex
+}
+
+func newHostLatencies() *hostLatencies {
+	return &hostLatencies{
+		latencies: make(map[string]time.Duration),
+	}
+}
+
+// get returns the latency of the last request to the host, or zero if there hasn't been one.
+func (h *hostLatencies) get(host string) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	return h.latencies[host]
+}
+
+func (h *hostLatencies) set(host string, latency time.Duration) {
+	h.Lock()
+	defer h.Unlock()
+	h.latencies[host] = latency
+}
+
+// hostSchedule spaces out requests to each host by a fixed interval, however many workers
+// are making them. It is safe for concurrent use.
+type hostSchedule struct {
+	next map[string]time.Time
+	sync.Mutex
+}
+
+func newHostSchedule() *hostSchedule {
+	return &hostSchedule{
+		next: make(map[string]time.Time),
+	}
+}
+
+// reserve claims the next slot for a request to the host, and returns how long to wait until it.
+func (h *hostSchedule) reserve(host string, interval time.Duration, now time.Time) time.Duration {
+	h.Lock()
+	defer h.Unlock()
+	slot := now
+	if next := h.next[host]; next.After(slot) {
+		slot = next
+	}
+	h.next[host] = slot.Add(interval)
+	return slot.Sub(now)
+}
+
+// tokenBucket limits the rate of requests across every host. It holds at most one token, so
+// requests can't burst above the rate after a quiet spell. A nil tokenBucket never waits. It is
+// safe for concurrent use.
+type tokenBucket struct {
+	rate   float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+func newTokenBucket(perSecond float64) *tokenBucket {
+	return &tokenBucket{rate: perSecond, tokens: 1}
+}
+
+// reserve takes a token, and returns how long to wait until it's available.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.Lock()
+	defer b.Unlock()
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > 1 {
+			b.tokens = 1
+		}
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// delay waits for as long as the delay func or the robots.txt crawl delay asks before a request
+// to the URL, then for the global rate limit, or until the run context is done.
+func (s *Spider) delay(uri *url.URL) {
+	s.hostDelay(uri)
+	s.sleep(s.globalRate.reserve(time.Now()))
+}
+
+// hostDelay waits for as long as the delay func or the robots.txt crawl delay asks before a
+// request to the URL.
+func (s *Spider) hostDelay(uri *url.URL) {
+	host := uri.Hostname()
+	var wait time.Duration
+	if s.delayFunc != nil {
+		wait = s.delayFunc(host, s.latencies.get(host))
+	}
+	if s.crawlDelay > 0 {
+		if scheduled := s.crawlSchedule.reserve(host, s.crawlDelay, time.Now()); scheduled > wait {
+			wait = scheduled
+		}
+	}
+	if wait <= 0 {
+		return
+	}
+	s.logger.Debug("Delaying request", zap.String("url", uri.String()), zap.Duration("delay", wait))
+	s.sleep(wait)
+}
+
+// sleep waits for the duration, or until the run context is done.
+func (s *Spider) sleep(wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	select {
+	case <-time.After(wait):
+	case <-s.runCtx.Done():
+	}
+}
@@ -0,0 +1,43 @@
+package spider
+
+import (
+	"net/url"
+	"sync"
+
+	"github.com/Willyham/gospider/spider/internal/parser"
+)
+
+// nearDuplicates clusters pages whose text is almost the same, by comparing each page's SimHash
+// against the first page of every cluster so far. It is safe for concurrent use.
+type nearDuplicates struct {
+	threshold float64
+	clusters  []simHashedPage
+	sync.Mutex
+}
+
+// simHashedPage is the first page of a cluster of near duplicates.
+type simHashedPage struct {
+	url  *url.URL
+	hash uint64
+}
+
+func newNearDuplicates(threshold float64) *nearDuplicates {
+	return &nearDuplicates{threshold: threshold}
+}
+
+// match returns the first page of the cluster the page belongs to, or nil if it starts a new
+// one. Pages without any text never match.
+func (d *nearDuplicates) match(uri *url.URL, hash uint64) *url.URL {
+	if d == nil || hash == 0 {
+		return nil
+	}
+	d.Lock()
+	defer d.Unlock()
+	for _, cluster := range d.clusters {
+		if parser.Similarity(cluster.hash, hash) >= d.threshold {
+			return cluster.url
+		}
+	}
+	d.clusters = append(d.clusters, simHashedPage{url: uri, hash: hash})
+	return nil
+}
@@ -0,0 +1,39 @@
+package spider
+
+import (
+	"net/url"
+
+	"github.com/Willyham/gospider/spider/internal/concurrency"
+	"github.com/pkg/errors"
+)
+
+// ErrDisallowedByRobots is the cause of a RootUnreachableError when robots.txt doesn't allow
+// the root to be crawled.
+var ErrDisallowedByRobots = errors.New("disallowed by robots.txt")
+
+// RootUnreachableError is returned by Run when the root itself can't be crawled, e.g. because
+// it responds with an error status or is disallowed by robots.txt. Nothing else is crawled,
+// since there's nowhere to find links from. Failures of pages found during the crawl are
+// returned as they are.
+type RootUnreachableError struct {
+	URL *url.URL
+	Err error
+}
+
+// newRootUnreachableError creates a RootUnreachableError from the error crawling the root.
+// Errors which wouldn't have stopped the crawl for other pages are unwrapped.
+func newRootUnreachableError(root *url.URL, err error) RootUnreachableError {
+	if retryable, ok := err.(concurrency.RetryableError); ok {
+		err = retryable.Err
+	}
+	return RootUnreachableError{URL: root, Err: err}
+}
+
+func (e RootUnreachableError) Error() string {
+	return "root URL " + e.URL.String() + " is unreachable: " + e.Err.Error()
+}
+
+// Cause returns why the root couldn't be crawled, for errors.Cause.
+func (e RootUnreachableError) Cause() error {
+	return e.Err
+}
@@ -0,0 +1,83 @@
+package spider
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Types of event written by the event writer.
+const (
+	EventPageFetched = "page_fetched"
+	EventLinkFound   = "link_found"
+	EventError       = "error"
+	EventDone        = "done"
+)
+
+// Event is a single thing which happened during a crawl.
+type Event struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	URL  string    `json:"url,omitempty"`
+	// Referrer is the page a link was found on.
+	Referrer string `json:"referrer,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// Pages and Errors are totals, set on the done event.
+	Pages  int `json:"pages,omitempty"`
+	Errors int `json:"errors,omitempty"`
+}
+
+// eventWriter writes events as newline delimited JSON. It is safe for concurrent use,
+// and a nil eventWriter discards all events.
+type eventWriter struct {
+	encoder *json.Encoder
+	logger  *zap.Logger
+	sync.Mutex
+}
+
+// newEventWriter creates an event writer. Its logger is set by New once all options have
+// been applied.
+func newEventWriter(w io.Writer) *eventWriter {
+	return &eventWriter{
+		encoder: json.NewEncoder(w),
+		logger:  zap.NewNop(),
+	}
+}
+
+func (w *eventWriter) emit(event Event) {
+	if w == nil {
+		return
+	}
+	event.Time = time.Now()
+
+	w.Lock()
+	defer w.Unlock()
+	err := w.encoder.Encode(event)
+	if err != nil {
+		w.logger.Warn("Failed to write event", zap.String("type", event.Type), zap.Error(err))
+	}
+}
+
+func (w *eventWriter) pageFetched(uri *url.URL) {
+	w.emit(Event{Type: EventPageFetched, URL: uri.String()})
+}
+
+func (w *eventWriter) linkFound(referrer *url.URL, link *url.URL) {
+	w.emit(Event{Type: EventLinkFound, URL: link.String(), Referrer: referrer.String()})
+}
+
+func (w *eventWriter) error(uri *url.URL, err error) {
+	w.emit(Event{Type: EventError, URL: uri.String(), Error: err.Error()})
+}
+
+func (w *eventWriter) done(stats RunStats) {
+	event := Event{Type: EventDone, Pages: stats.Pages, Errors: stats.Errors}
+	if stats.Err != nil {
+		event.Error = stats.Err.Error()
+	}
+	w.emit(event)
+}
@@ -0,0 +1,95 @@
+package spider
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/Willyham/gospider/spider/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// readEvents decodes all events in the buffer and counts them by type.
+func readEvents(t *testing.T, buf *bytes.Buffer) ([]Event, map[string]int) {
+	var events []Event
+	counts := make(map[string]int)
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		var event Event
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+		counts[event.Type]++
+	}
+	return events, counts
+}
+
+func TestEventWriter(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/foo"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+
+	buf := bytes.NewBuffer(nil)
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithEventWriter(buf),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	events, counts := readEvents(t, buf)
+	assert.Equal(t, map[string]int{
+		EventPageFetched: 2,
+		EventLinkFound:   1,
+		EventDone:        1,
+	}, counts)
+
+	last := events[len(events)-1]
+	assert.Equal(t, EventDone, last.Type)
+	assert.Equal(t, 2, last.Pages)
+}
+
+func TestEventWriterError(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(nil, assert.AnError)
+
+	buf := bytes.NewBuffer(nil)
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithEventWriter(buf),
+	)
+	err := s.Run()
+	require.Error(t, err)
+
+	events, counts := readEvents(t, buf)
+	assert.Equal(t, map[string]int{
+		EventError: 1,
+		EventDone:  1,
+	}, counts)
+	assert.Equal(t, willydURL.String(), events[0].URL)
+	assert.Equal(t, assert.AnError.Error(), events[0].Error)
+}
+
+func TestEventWriterLogger(t *testing.T) {
+	logger := zap.NewExample()
+	s := New(
+		WithRoot(willydURL),
+		WithEventWriter(bytes.NewBuffer(nil)),
+		WithLogger(logger),
+	)
+	assert.Equal(t, logger, s.events.logger)
+}
+
+func TestNilEventWriter(t *testing.T) {
+	var w *eventWriter
+	assert.NotPanics(t, func() {
+		w.pageFetched(willydURL)
+		w.done(RunStats{})
+	})
+}
@@ -0,0 +1,54 @@
+package spider
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+)
+
+// CrawlContext describes a link which is about to be enqueued.
+type CrawlContext struct {
+	URL *url.URL
+	// Method is GET for links, and POST for forms which are submitted.
+	Method string
+	// Depth is how many links away from the root the URL is. The root has depth 0.
+	Depth int
+	// Referrer is the page the link was found on.
+	Referrer *url.URL
+	// ContentType is guessed from the URL's extension, and is empty if it isn't known.
+	ContentType string
+}
+
+// CrawlFilter decides whether a link should be crawled.
+type CrawlFilter func(CrawlContext) bool
+
+// newCrawlContext creates the context for a link found on the referrer.
+func newCrawlContext(link *url.URL, depth int, referrer *url.URL) CrawlContext {
+	return CrawlContext{
+		URL:         link,
+		Method:      http.MethodGet,
+		Depth:       depth,
+		Referrer:    referrer,
+		ContentType: mime.TypeByExtension(path.Ext(link.Path)),
+	}
+}
+
+// fromURLPredicate adapts a urlPredicate to a CrawlFilter.
+func fromURLPredicate(predicate urlPredicate) CrawlFilter {
+	return func(ctx CrawlContext) bool {
+		return predicate(ctx.URL)
+	}
+}
+
+// allFilters combines the filters into one which passes only if every filter does.
+func allFilters(filters ...CrawlFilter) CrawlFilter {
+	return func(ctx CrawlContext) bool {
+		for _, f := range filters {
+			if !f(ctx) {
+				return false
+			}
+		}
+		return true
+	}
+}
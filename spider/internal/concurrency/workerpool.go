@@ -9,7 +9,8 @@ import (
 type poolState int
 
 const (
-	stateRunning poolState = iota
+	stateIdle poolState = iota
+	stateRunning
 	stateStopping
 	stateStopped
 )
@@ -26,6 +27,7 @@ const (
 // - jobs is a buffered channel that signals that a worker should process a job
 // - results signals that a result was computed by work()
 // - errors collects any errors from work(). An error on the channel will stop the ingester
+// - stop is closed to ask the ingester to stop
 // - done is used to signal when the ingester has totally stopped (i.e. all workers drained)
 type WorkerPool struct {
 	logger     *zap.Logger
@@ -35,15 +37,21 @@ type WorkerPool struct {
 	jobs      chan struct{}
 	results   chan struct{}
 	errors    chan error
+	stop      chan struct{}
+	stopOnce  sync.Once
 	done      chan bool
 	waitGroup sync.WaitGroup
 	state     poolState
 	stateLock sync.Mutex
+	// paused stops workers from starting new work while the pool is running. Workers wait
+	// on unpaused, which is signalled whenever paused or state changes.
+	paused   bool
+	unpaused *sync.Cond
 }
 
 // NewWorkerPool creates a new worker-pool
 func NewWorkerPool(logger *zap.Logger, numWorkers int, w Worker) *WorkerPool {
-	return &WorkerPool{
+	pool := &WorkerPool{
 		logger:     logger,
 		worker:     w,
 		numWorkers: numWorkers,
@@ -52,10 +60,13 @@ func NewWorkerPool(logger *zap.Logger, numWorkers int, w Worker) *WorkerPool {
 		// results is a buffered channel so we can drain results after signalling to stop
 		results:   make(chan struct{}, numWorkers),
 		errors:    make(chan error),
+		stop:      make(chan struct{}),
 		done:      make(chan bool),
 		waitGroup: sync.WaitGroup{},
-		state:     stateStopped,
+		state:     stateIdle,
 	}
+	pool.unpaused = sync.NewCond(&pool.stateLock)
+	return pool
 }
 
 // Start makes the ingester-pool start to process messages.
@@ -64,8 +75,15 @@ func NewWorkerPool(logger *zap.Logger, numWorkers int, w Worker) *WorkerPool {
 // adds another job to the pool to be processed, or an error, in which
 // case it stops the ingester-pool, waits for the workers to drain, then signals
 // that it is done.
+//
+// A pool can only be started once. If it was stopped before it started, Start
+// returns Stopped immediately.
 func (s *WorkerPool) Start() error {
 	s.stateLock.Lock()
+	if s.state != stateIdle {
+		s.stateLock.Unlock()
+		return Stopped
+	}
 	s.state = stateRunning
 	s.stateLock.Unlock()
 
@@ -79,31 +97,14 @@ func (s *WorkerPool) Start() error {
 
 	for {
 		select {
+		case <-s.stop:
+			return s.drain(Stopped)
 		case err := <-s.errors:
-			s.state = stateStopping
-
 			// If the error is something we don't know about or is not retryable, log it and stop
 			if err != Stopped {
 				s.logger.Error("got error from workers", zap.Error(err))
 			}
-
-			// Close jobs to shut down the workers, then wait for them to finish
-			close(s.jobs)
-
-			// Drain off any errors from other workers
-			go func() {
-				for e := range s.errors {
-					s.logger.Error(e.Error())
-				}
-			}()
-
-			s.waitGroup.Wait()
-			close(s.results)
-			close(s.errors)
-			close(s.done)
-
-			s.state = stateStopped
-			return err
+			return s.drain(err)
 		case <-s.results:
 			// If we get a result, add another job to the queue
 			s.logger.Debug("Got result, adding job")
@@ -112,6 +113,48 @@ func (s *WorkerPool) Start() error {
 	}
 }
 
+// drain shuts down the workers and waits for them to finish, then signals that the
+// pool is done. It returns the error which caused the pool to stop.
+func (s *WorkerPool) drain(err error) error {
+	s.setState(stateStopping)
+
+	// Close jobs to shut down the workers, then wait for them to finish
+	close(s.jobs)
+
+	// Drain off any errors from other workers
+	go func() {
+		for e := range s.errors {
+			s.logger.Error(e.Error())
+		}
+	}()
+
+	s.waitGroup.Wait()
+	close(s.results)
+	close(s.errors)
+	close(s.done)
+
+	s.setState(stateStopped)
+	return err
+}
+
+func (s *WorkerPool) setState(state poolState) {
+	s.stateLock.Lock()
+	s.state = state
+	s.unpaused.Broadcast()
+	s.stateLock.Unlock()
+}
+
+// waitWhilePaused blocks while the pool is paused. It returns false if the pool is no
+// longer running, in which case no more work should be started.
+func (s *WorkerPool) waitWhilePaused() bool {
+	s.stateLock.Lock()
+	defer s.stateLock.Unlock()
+	for s.paused && s.state == stateRunning {
+		s.unpaused.Wait()
+	}
+	return s.state == stateRunning
+}
+
 // runWorker defers to the Worker to process jobs.
 //
 // If there is no error from the worker, it continues.
@@ -122,6 +165,9 @@ func (s *WorkerPool) runWorker() {
 	defer s.waitGroup.Done()
 
 	for range s.jobs {
+		if !s.waitWhilePaused() {
+			continue
+		}
 		s.logger.Debug("Processing job")
 		err := s.worker.Work()
 
@@ -145,19 +191,41 @@ func (s *WorkerPool) runWorker() {
 }
 
 // Stop signals the ingester-pool to stop processing new messages. Use StopWait
-// to wait until all messages are processed
+// to wait until all messages are processed. It is safe to call Stop more than once.
 func (s *WorkerPool) Stop() {
 	s.logger.Info("Stopping worker-pool")
-	s.errors <- Stopped
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// Pause stops workers from starting new work. Work which is already in progress is allowed
+// to finish. A pool can be paused before it is started.
+func (s *WorkerPool) Pause() {
+	s.stateLock.Lock()
+	s.paused = true
+	s.stateLock.Unlock()
+}
+
+// Resume lets workers start new work again after Pause.
+func (s *WorkerPool) Resume() {
+	s.stateLock.Lock()
+	s.paused = false
+	s.unpaused.Broadcast()
+	s.stateLock.Unlock()
 }
 
 // StopWait starts the process of stopping, and waits for all workers to
-// stop before returning.
+// stop before returning. If the pool was never started, it is marked as stopped
+// so that a later call to Start does nothing.
 func (s *WorkerPool) StopWait() {
 	s.stateLock.Lock()
-	defer s.stateLock.Unlock()
-	if s.state == stateRunning {
-		s.Stop()
+	if s.state == stateIdle {
+		s.state = stateStopped
+		close(s.done)
 	}
+	s.stateLock.Unlock()
+
+	s.Stop()
 	<-s.done
 }
@@ -1,6 +1,7 @@
 package concurrency
 
 import (
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,6 +13,16 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
+// signal creates a mock Run function which notifies the channel without blocking.
+func signal(ch chan struct{}) func(mock.Arguments) {
+	return func(mock.Arguments) {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
 func TestNewIngesterPool(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	pool := NewWorkerPool(logger, 1, &mocks.Worker{})
@@ -21,16 +32,15 @@ func TestNewIngesterPool(t *testing.T) {
 func TestStart(t *testing.T) {
 	// Create a worker which doesn't error
 	worker := &mocks.Worker{}
-	worker.On("Work").Return(nil)
+	worked := make(chan struct{}, 1)
+	worker.On("Work").Return(nil).Run(signal(worked))
 
 	logger, _ := zap.NewDevelopment()
 	pool := NewWorkerPool(logger, 1, worker)
 	go pool.Start()
-	// Advance some time to allow the workers to process a job before stopping
-	select {
-	case <-time.After(1 * time.Millisecond):
-		pool.StopWait()
-	}
+	// Allow the workers to process a job before stopping
+	<-worked
+	pool.StopWait()
 	mock.AssertExpectationsForObjects(t, worker)
 }
 
@@ -64,17 +74,71 @@ func TestStartMultipleErrors(t *testing.T) {
 func TestStartRetryableError(t *testing.T) {
 	// Create a worker which returns a retryable error
 	worker := &mocks.Worker{}
-	worker.On("Work").Return(NewRetryableError(assert.AnError))
+	worked := make(chan struct{}, 1)
+	worker.On("Work").Return(NewRetryableError(assert.AnError)).Run(signal(worked))
 
 	logger, _ := zap.NewDevelopment()
 	pool := NewWorkerPool(logger, 1, worker)
 
 	go pool.Start()
 
-	// Advance some time to allow the workers to process a job before stopping
-	select {
-	case <-time.After(1 * time.Microsecond):
-		pool.StopWait()
-	}
+	// Allow the workers to process a job before stopping
+	<-worked
+	pool.StopWait()
 	mock.AssertExpectationsForObjects(t, worker)
 }
+
+func TestStopWaitBeforeStart(t *testing.T) {
+	worker := &mocks.Worker{}
+
+	logger, _ := zap.NewDevelopment()
+	pool := NewWorkerPool(logger, 1, worker)
+	pool.StopWait()
+
+	err := pool.Start()
+	assert.Equal(t, Stopped, err)
+	worker.AssertNotCalled(t, "Work")
+}
+
+func TestPauseResume(t *testing.T) {
+	var calls int64
+	worked := make(chan struct{}, 1)
+	worker := WorkFunc(func() error {
+		atomic.AddInt64(&calls, 1)
+		signal(worked)(nil)
+		return nil
+	})
+
+	pool := NewWorkerPool(zap.NewNop(), 1, worker)
+	go pool.Start()
+	<-worked
+
+	// The worker may already be past the pause check, so allow it one more call.
+	pool.Pause()
+	paused := atomic.LoadInt64(&calls)
+	time.Sleep(time.Millisecond * 50)
+	assert.InDelta(t, paused, atomic.LoadInt64(&calls), 1)
+	settled := atomic.LoadInt64(&calls)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, settled, atomic.LoadInt64(&calls))
+
+	pool.Resume()
+	<-worked
+	<-worked
+	assert.True(t, atomic.LoadInt64(&calls) > settled)
+	pool.StopWait()
+}
+
+func TestStopWaitWhilePaused(t *testing.T) {
+	worker := &mocks.Worker{}
+	pool := NewWorkerPool(zap.NewNop(), 2, worker)
+	pool.Pause()
+
+	errs := make(chan error)
+	go func() {
+		errs <- pool.Start()
+	}()
+	pool.StopWait()
+	assert.Equal(t, Stopped, <-errs)
+	worker.AssertNotCalled(t, "Work")
+}
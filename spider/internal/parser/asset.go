@@ -0,0 +1,89 @@
+package parser
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Kinds of asset.
+const (
+	AssetImage      = "image"
+	AssetScript     = "script"
+	AssetStylesheet = "stylesheet"
+	AssetFont       = "font"
+	AssetMedia      = "media"
+	AssetOther      = "other"
+)
+
+// Link relations which say what kind of asset a link tag loads.
+const (
+	RelStylesheet     = "stylesheet"
+	RelIcon           = "icon"
+	RelAppleTouchIcon = "apple-touch-icon"
+)
+
+// Asset is a file loaded by a page, such as an image or a script.
+type Asset struct {
+	URL string
+	// Kind is one of the Asset kinds, such as AssetImage.
+	Kind string
+}
+
+// assetKindsByExtension is used when the tag an asset came from doesn't say what it is.
+var assetKindsByExtension = map[string]string{
+	".css":   AssetStylesheet,
+	".js":    AssetScript,
+	".mjs":   AssetScript,
+	".png":   AssetImage,
+	".jpg":   AssetImage,
+	".jpeg":  AssetImage,
+	".gif":   AssetImage,
+	".svg":   AssetImage,
+	".webp":  AssetImage,
+	".avif":  AssetImage,
+	".ico":   AssetImage,
+	".woff":  AssetFont,
+	".woff2": AssetFont,
+	".ttf":   AssetFont,
+	".otf":   AssetFont,
+	".eot":   AssetFont,
+	".mp4":   AssetMedia,
+	".webm":  AssetMedia,
+	".ogg":   AssetMedia,
+	".ogv":   AssetMedia,
+	".mp3":   AssetMedia,
+	".wav":   AssetMedia,
+	".m4a":   AssetMedia,
+	".mov":   AssetMedia,
+}
+
+// newAsset creates an asset of the given kind. If the kind is empty, it is guessed from the
+// URL's extension.
+func newAsset(src string, kind string) Asset {
+	if kind == "" {
+		kind = assetKindByExtension(src)
+	}
+	return Asset{URL: src, Kind: kind}
+}
+
+// assetKindByExtension guesses the kind of asset from the URL's extension, ignoring any query.
+func assetKindByExtension(src string) string {
+	uri, err := url.Parse(src)
+	if err != nil {
+		return AssetOther
+	}
+	if kind, ok := assetKindsByExtension[strings.ToLower(path.Ext(uri.Path))]; ok {
+		return kind
+	}
+	return AssetOther
+}
+
+// AssetURLs returns the URLs of the assets, in order.
+func (r Results) AssetURLs() []string {
+	urls := make([]string, len(r.Assets))
+	for i, asset := range r.Assets {
+		urls[i] = asset.URL
+	}
+	return urls
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Form tags and attributes.
+const (
+	TagForm  = "form"
+	TagInput = "input"
+
+	AttrAction  = "action"
+	AttrMethod  = "method"
+	AttrType    = "type"
+	AttrValue   = "value"
+	AttrChecked = "checked"
+)
+
+// Form is a form found on the page, along with the values it would be submitted with if the
+// user didn't change anything.
+type Form struct {
+	// Action is where the form is submitted. It is empty if the form submits to the page it
+	// is on.
+	Action *url.URL
+	// Method is the upper case HTTP method, GET unless the form says otherwise.
+	Method string
+	// Values are the names and values of the form's inputs.
+	Values url.Values
+}
+
+// unsubmittedInputTypes are input types which don't contribute a value without user action.
+var unsubmittedInputTypes = map[string]bool{
+	"submit": true,
+	"button": true,
+	"reset":  true,
+	"image":  true,
+	"file":   true,
+}
+
+// newForm creates a form from its start tag. It returns nil if the action isn't a valid URL.
+func newForm(token html.Token) *Form {
+	form := &Form{
+		Action: &url.URL{},
+		Method: http.MethodGet,
+		Values: url.Values{},
+	}
+	if action := filterAttrByName(token, AttrAction); action != nil {
+		uri, err := url.Parse(strings.TrimSpace(*action))
+		if err != nil {
+			return nil
+		}
+		form.Action = uri
+	}
+	if method := filterAttrByName(token, AttrMethod); method != nil && strings.EqualFold(*method, http.MethodPost) {
+		form.Method = http.MethodPost
+	}
+	return form
+}
+
+// addInput adds the value of an input tag to the form. Checkboxes and radio buttons are only
+// added if they're checked, and buttons aren't added at all.
+func (f *Form) addInput(token html.Token) {
+	name := filterAttrByName(token, AttrName)
+	if name == nil || *name == "" {
+		return
+	}
+	inputType := ""
+	if t := filterAttrByName(token, AttrType); t != nil {
+		inputType = strings.ToLower(*t)
+	}
+	if unsubmittedInputTypes[inputType] {
+		return
+	}
+	if (inputType == "checkbox" || inputType == "radio") && filterAttrByName(token, AttrChecked) == nil {
+		return
+	}
+	value := ""
+	if v := filterAttrByName(token, AttrValue); v != nil {
+		value = *v
+	}
+	f.Values.Add(*name, value)
+}
@@ -4,28 +4,92 @@ import (
 	"bytes"
 	"io"
 	"net/url"
+	"regexp"
+	"strings"
 
 	"golang.org/x/net/html"
 )
 
 // HTML tags we care about
 const (
-	TagA      = "a"
-	TagLink   = "link"
-	TagImg    = "img"
-	TagScript = "script"
+	TagA        = "a"
+	TagLink     = "link"
+	TagImg      = "img"
+	TagScript   = "script"
+	TagNoscript = "noscript"
+	TagArea     = "area"
+	TagVideo    = "video"
+	TagAudio    = "audio"
+	TagSource   = "source"
+	TagTitle    = "title"
+	TagMeta     = "meta"
+	TagStyle    = "style"
 )
 
 // Attribute types we look for,
 const (
 	AttrHref = "href"
 	AttrSrc  = "src"
+	AttrRel  = "rel"
+
+	AttrSrcset = "srcset"
+	AttrPoster = "poster"
+
+	AttrName    = "name"
+	AttrContent = "content"
+)
+
+// Names of the meta tags we look for.
+const (
+	metaDescription = "description"
+	metaRobots      = "robots"
+)
+
+// Link relations which point at other pages rather than assets.
+const (
+	RelNext = "next"
+	RelPrev = "prev"
+)
+
+// RelCanonical is the link relation for the page's preferred URL.
+const RelCanonical = "canonical"
+
+// Resource hint link relations, which warm up a connection to a host rather than loading
+// anything from it.
+const (
+	RelPreconnect  = "preconnect"
+	RelDNSPrefetch = "dns-prefetch"
 )
 
 // Results encapsulates data we want out of the parser.
 type Results struct {
-	Assets []string
+	Assets []Asset
 	Links  []*url.URL
+	// Title is the text of the page's first title tag, and Description is the content of its
+	// description meta tag. They are empty if the page doesn't have them.
+	Title       string
+	Description string
+	// NoIndex and NoFollow are set by the page's robots meta tag.
+	NoIndex  bool
+	NoFollow bool
+	// Canonical is the href of the page's first canonical link tag, as written. It is nil if the
+	// page doesn't declare one.
+	Canonical *url.URL
+	// PreconnectHosts are the hosts of the page's preconnect and dns-prefetch link tags, in
+	// order.
+	PreconnectHosts []string
+	// Forms are the page's forms, in order.
+	Forms []Form
+	// AnchorText maps each link, as a string, to the text of the anchors which link to it. It
+	// is only set if TokenParser.CollectAnchorText is.
+	AnchorText map[string]string
+	// SimHash fingerprints the page's text, leaving out scripts and styles, so near duplicate
+	// pages can be found with Similarity. It is only set if TokenParser.ComputeSimHash is, and
+	// is zero if the page has no text.
+	SimHash uint64
+	// TextLength is the number of bytes of the page's text, leaving out tags, the title, scripts
+	// and styles, with each run of whitespace counted as a single space.
+	TextLength int
 }
 
 // Parser allows for different parser implementations.
@@ -43,25 +107,206 @@ func (f Func) Parse(body []byte) (Results, error) {
 }
 
 // ByToken iterates over tokens in the response, pulling out links and assets.
-var ByToken = Func(func(body []byte) (Results, error) {
-	tokenizer := html.NewTokenizer(bytes.NewReader(body))
+var ByToken = Func(byToken)
+
+func byToken(body []byte) (Results, error) {
+	return TokenParser{}.Parse(body)
+}
+
+// ByTokenReader is like ByToken, but tokenizes straight from the reader so the whole
+// body never needs to be held in memory.
+func ByTokenReader(r io.Reader) (Results, error) {
+	return TokenParser{}.ParseReader(r)
+}
+
+// TokenParser is the parser behind ByToken, with extra configuration. The zero value
+// behaves exactly like ByToken.
+type TokenParser struct {
+	// ExtraLinkAttributes are attributes, such as data-href, whose values are collected as
+	// links on whichever tag they appear.
+	ExtraLinkAttributes []string
+	// ExtraAssetAttributes are attributes, such as data-src, whose values are collected as
+	// assets on whichever tag they appear. They are useful for lazy loaded images.
+	ExtraAssetAttributes []string
+	// ScriptLinkPattern, if set, is matched against the contents of inline scripts, and each
+	// match is collected as a link. If the pattern has a group, the first group is used
+	// rather than the whole match.
+	ScriptLinkPattern *regexp.Regexp
+	// CollectAnchorText sets Results.AnchorText.
+	CollectAnchorText bool
+	// ParseComments also collects the links and assets in commented out markup, which some
+	// templates leave behind.
+	ParseComments bool
+	// ComputeSimHash sets Results.SimHash.
+	ComputeSimHash bool
+}
+
+var _ Parser = TokenParser{}
+
+// Parse pulls links and assets out of the body.
+func (p TokenParser) Parse(body []byte) (Results, error) {
+	return p.ParseReader(bytes.NewReader(body))
+}
+
+// ParseReader is like Parse, but tokenizes straight from the reader.
+func (p TokenParser) ParseReader(r io.Reader) (Results, error) {
+	tokenizer := html.NewTokenizer(r)
 	results := Results{}
+	inNoscript := false
+	inScript := false
+	inTitle := false
+	// inStyle is true inside a style tag, whose text isn't part of the page's content.
+	inStyle := false
+	var simHash simHasher
+	var textLength textMeter
+	// form is the form whose inputs we're collecting, if we're inside one.
+	var form *Form
+	// anchor is the link whose text we're collecting, if we're inside an anchor.
+	var anchor string
+	var anchorText []string
+	if p.CollectAnchorText {
+		results.AnchorText = make(map[string]string)
+	}
 	for {
 		tokenType := tokenizer.Next()
 		switch tokenType {
 
+		case html.TextToken:
+			// The text can only be taken once.
+			text := tokenizer.Text()
+			if p.ComputeSimHash && !inNoscript && !inScript && !inStyle {
+				simHash.add(text)
+			}
+			if !inNoscript && !inScript && !inStyle && !inTitle {
+				textLength.add(text)
+			}
+			if inTitle {
+				if results.Title == "" {
+					results.Title = strings.Join(strings.Fields(string(text)), " ")
+				}
+				continue
+			}
+			if inScript {
+				if p.ScriptLinkPattern != nil {
+					results.Links = append(results.Links, p.scriptLinks(text)...)
+				}
+				continue
+			}
+			if anchor != "" {
+				anchorText = append(anchorText, strings.Fields(string(text))...)
+			}
+			if !inNoscript {
+				continue
+			}
+			// The tokenizer treats noscript contents as raw text, so tokenize it separately to
+			// pick up any fallback links.
+			inner, err := p.Parse(text)
+			if err != nil {
+				continue
+			}
+			results.Links = append(results.Links, inner.Links...)
+			results.Assets = append(results.Assets, inner.Assets...)
+
+		case html.CommentToken:
+			if !p.ParseComments {
+				continue
+			}
+			inner, err := p.Parse(tokenizer.Text())
+			if err != nil {
+				continue
+			}
+			results.Links = append(results.Links, inner.Links...)
+			results.Assets = append(results.Assets, inner.Assets...)
+
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if isTag(token, TagNoscript) {
+				inNoscript = false
+			}
+			if isTag(token, TagScript) {
+				inScript = false
+			}
+			if isTag(token, TagStyle) {
+				inStyle = false
+			}
+			if isTag(token, TagTitle) {
+				inTitle = false
+			}
+			if isTag(token, TagForm) && form != nil {
+				results.Forms = append(results.Forms, *form)
+				form = nil
+			}
+			if isTag(token, TagA) && anchor != "" {
+				addAnchorText(results.AnchorText, anchor, anchorText)
+				anchor, anchorText = "", nil
+			}
+
 		case html.ErrorToken:
+			// A form which is never closed runs to the end of the page.
+			if form != nil {
+				results.Forms = append(results.Forms, *form)
+			}
+			results.SimHash = simHash.sum()
+			results.TextLength = textLength.length
 			err := tokenizer.Err()
 			if err == io.EOF {
 				return results, nil
 			}
 			return results, err
 
-		case html.StartTagToken:
+		// Void elements such as area, img and link are often written self closing.
+		case html.StartTagToken, html.SelfClosingTagToken:
 			token := tokenizer.Token()
 
-			// Capture links by looking for "a" tags
-			if isTag(token, TagA) {
+			if isTag(token, TagNoscript) {
+				inNoscript = tokenType == html.StartTagToken
+				continue
+			}
+
+			p.collectExtraAttrs(token, &results)
+
+			if isTag(token, TagScript) {
+				inScript = tokenType == html.StartTagToken
+			}
+			if isTag(token, TagStyle) {
+				inStyle = tokenType == html.StartTagToken
+			}
+
+			if isTag(token, TagTitle) {
+				inTitle = tokenType == html.StartTagToken
+				continue
+			}
+			// Forms can't be nested, so a new form closes any unclosed one.
+			if isTag(token, TagForm) {
+				if form != nil {
+					results.Forms = append(results.Forms, *form)
+				}
+				form = newForm(token)
+				continue
+			}
+			if isTag(token, TagInput) {
+				if form != nil {
+					form.addInput(token)
+				}
+				continue
+			}
+			if isTag(token, TagMeta) {
+				name := filterAttrByName(token, AttrName)
+				content := filterAttrByName(token, AttrContent)
+				if name == nil || content == nil {
+					continue
+				}
+				if strings.EqualFold(*name, metaDescription) && results.Description == "" {
+					results.Description = strings.TrimSpace(*content)
+				}
+				if strings.EqualFold(*name, metaRobots) {
+					parseMetaRobots(*content, &results)
+				}
+				continue
+			}
+
+			// Capture links by looking for "a" tags, and "area" tags from image maps
+			if isTag(token, TagA) || isTag(token, TagArea) {
 				href := filterAttrByName(token, AttrHref)
 				if href == nil {
 					continue
@@ -71,16 +316,40 @@ var ByToken = Func(func(body []byte) (Results, error) {
 					continue
 				}
 				results.Links = append(results.Links, uri)
+				// Anchors can't be nested, so a new one closes any unclosed one.
+				if p.CollectAnchorText && isTag(token, TagA) && tokenType == html.StartTagToken {
+					if anchor != "" {
+						addAnchorText(results.AnchorText, anchor, anchorText)
+					}
+					anchor, anchorText = uri.String(), nil
+				}
 				continue
 			}
 
-			// Image and script assets both share the 'src' attribute.
-			if isTag(token, TagImg) || isTag(token, TagScript) {
+			// Image, script and media assets all share the 'src' attribute.
+			if kind, ok := srcAssetKinds[token.Data]; ok {
 				src := filterAttrByName(token, AttrSrc)
-				if src == nil {
-					continue
+				if src != nil {
+					results.Assets = append(results.Assets, newAsset(*src, kind))
+				}
+			}
+
+			// Sources can list several candidate images, and videos can have a poster image.
+			if isTag(token, TagSource) {
+				srcset := filterAttrByName(token, AttrSrcset)
+				if srcset != nil {
+					for _, src := range parseSrcset(*srcset) {
+						results.Assets = append(results.Assets, newAsset(src, AssetImage))
+					}
+				}
+				continue
+			}
+			if isTag(token, TagVideo) {
+				poster := filterAttrByName(token, AttrPoster)
+				if poster != nil {
+					results.Assets = append(results.Assets, newAsset(*poster, AssetImage))
 				}
-				results.Assets = append(results.Assets, *src)
+				continue
 			}
 
 			if isTag(token, TagLink) {
@@ -88,19 +357,173 @@ var ByToken = Func(func(body []byte) (Results, error) {
 				if href == nil {
 					continue
 				}
-				results.Assets = append(results.Assets, *href)
+				// Resource hints have nothing to fetch, so only their host is of interest.
+				if hasRel(token, RelPreconnect, RelDNSPrefetch) {
+					if host := hintHost(*href); host != "" {
+						results.PreconnectHosts = append(results.PreconnectHosts, host)
+					}
+					continue
+				}
+				// The canonical URL is neither an asset nor a link to crawl.
+				if hasRel(token, RelCanonical) {
+					uri, err := url.Parse(strings.TrimSpace(*href))
+					if err == nil && results.Canonical == nil {
+						results.Canonical = uri
+					}
+					continue
+				}
+				// Pagination links are pages in their own right, so treat them like anchors.
+				if hasRel(token, RelNext, RelPrev) {
+					uri, err := url.Parse(*href)
+					if err != nil {
+						continue
+					}
+					results.Links = append(results.Links, uri)
+					continue
+				}
+				results.Assets = append(results.Assets, newAsset(*href, linkAssetKind(token)))
 				continue
 			}
 
 		}
 	}
-})
+}
+
+// collectExtraAttrs adds the values of any extra link and asset attributes on the token.
+func (p TokenParser) collectExtraAttrs(token html.Token, results *Results) {
+	for _, name := range p.ExtraLinkAttributes {
+		href := filterAttrByName(token, name)
+		if href == nil {
+			continue
+		}
+		uri, err := url.Parse(*href)
+		if err != nil {
+			continue
+		}
+		results.Links = append(results.Links, uri)
+	}
+	for _, name := range p.ExtraAssetAttributes {
+		src := filterAttrByName(token, name)
+		if src != nil {
+			results.Assets = append(results.Assets, newAsset(*src, ""))
+		}
+	}
+}
+
+// scriptLinks returns the links in a script which match ScriptLinkPattern.
+func (p TokenParser) scriptLinks(script []byte) []*url.URL {
+	var links []*url.URL
+	for _, match := range p.ScriptLinkPattern.FindAllSubmatch(script, -1) {
+		link := match[0]
+		if len(match) > 1 {
+			link = match[1]
+		}
+		uri, err := url.Parse(string(link))
+		if err != nil {
+			continue
+		}
+		links = append(links, uri)
+	}
+	return links
+}
 
 // isTag returns true if the token is a [tag], false otherwise.
 func isTag(token html.Token, tag string) bool {
 	return token.Data == tag
 }
 
+// addAnchorText adds the words of an anchor's text to any already found for the link.
+func addAnchorText(anchorText map[string]string, link string, words []string) {
+	if len(words) == 0 {
+		return
+	}
+	text := strings.Join(words, " ")
+	if existing := anchorText[link]; existing != "" {
+		text = existing + " " + text
+	}
+	anchorText[link] = text
+}
+
+// srcAssetKinds are the kinds of asset loaded by each tag's src attribute.
+var srcAssetKinds = map[string]string{
+	TagImg:    AssetImage,
+	TagScript: AssetScript,
+	TagVideo:  AssetMedia,
+	TagAudio:  AssetMedia,
+	TagSource: AssetMedia,
+}
+
+// linkAssetKind returns the kind of asset a link tag loads from its rel attribute, or an empty
+// string if the rel doesn't say, e.g. for preloads.
+func linkAssetKind(token html.Token) string {
+	if hasRel(token, RelStylesheet) {
+		return AssetStylesheet
+	}
+	if hasRel(token, RelIcon, RelAppleTouchIcon) {
+		return AssetImage
+	}
+	return ""
+}
+
+// hasRel returns true if the token's rel attribute contains any of the given link types.
+func hasRel(token html.Token, rels ...string) bool {
+	rel := filterAttrByName(token, AttrRel)
+	if rel == nil {
+		return false
+	}
+	for _, field := range strings.Fields(*rel) {
+		for _, r := range rels {
+			if strings.EqualFold(field, r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hintHost returns the host of a resource hint's href, which may be a full URL, a protocol
+// relative one such as //fonts.gstatic.com, or a bare host. It returns an empty string if
+// there isn't one.
+func hintHost(href string) string {
+	href = strings.TrimSpace(href)
+	if !strings.Contains(href, "//") {
+		href = "//" + href
+	}
+	uri, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(uri.Host)
+}
+
+// parseMetaRobots sets the directives from a robots meta tag's content, e.g. "noindex, nofollow".
+func parseMetaRobots(content string, results *Results) {
+	for _, directive := range strings.Split(content, ",") {
+		switch strings.ToLower(strings.TrimSpace(directive)) {
+		case "noindex":
+			results.NoIndex = true
+		case "nofollow":
+			results.NoFollow = true
+		case "none":
+			results.NoIndex = true
+			results.NoFollow = true
+		}
+	}
+}
+
+// parseSrcset returns the URLs from a srcset attribute, e.g. "small.jpg 1x, large.jpg 2x".
+func parseSrcset(srcset string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		urls = append(urls, fields[0])
+	}
+	return urls
+}
+
 // filterAttrByName gets the attr value which matches name, nil otherwise.
 func filterAttrByName(token html.Token, name string) *string {
 	for _, attrs := range token.Attr {
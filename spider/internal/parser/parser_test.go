@@ -2,6 +2,10 @@ package parser
 
 import (
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -43,3 +47,353 @@ func TestBadURLs(t *testing.T) {
 	assert.Len(t, results.Assets, 0)
 	assert.Len(t, results.Links, 0)
 }
+
+func TestPaginationLinks(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/pagination.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/css/main.css"}, results.AssetURLs())
+
+	links := make([]string, len(results.Links))
+	for i, link := range results.Links {
+		links[i] = link.String()
+	}
+	assert.Equal(t, []string{"/posts/page/1/", "/posts/page/3/", "/posts/page/1/"}, links)
+}
+
+func TestNoscriptLinks(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/noscript.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/js/app.js", "/css/noscript.css", "/images/fallback.png"}, results.AssetURLs())
+
+	links := make([]string, len(results.Links))
+	for i, link := range results.Links {
+		links[i] = link.String()
+	}
+	assert.Equal(t, []string{"/about", "/posts", "/contact"}, links)
+}
+
+func TestCommentLinks(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/comments.html")
+	require.NoError(t, err)
+
+	linkStrings := func(links []*url.URL) []string {
+		strs := make([]string, len(links))
+		for i, link := range links {
+			strs[i] = link.String()
+		}
+		return strs
+	}
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/about", "/contact"}, linkStrings(results.Links))
+	assert.Empty(t, results.Assets)
+
+	results, err = TokenParser{ParseComments: true}.Parse(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/about", "/beta", "/contact"}, linkStrings(results.Links))
+	assert.Equal(t, []string{"/css/old.css", "/images/beta.png"}, results.AssetURLs())
+}
+
+func TestByTokenReader(t *testing.T) {
+	files := []string{
+		"./testdata/willdemaine.ghost.io.html",
+		"./testdata/pagination.html",
+		"./testdata/noscript.html",
+	}
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			f, err := os.Open(file)
+			require.NoError(t, err)
+			defer f.Close()
+
+			body, err := ioutil.ReadFile(file)
+			require.NoError(t, err)
+
+			expected, err := ByToken(body)
+			require.NoError(t, err)
+
+			results, err := ByTokenReader(f)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, results)
+		})
+	}
+}
+
+func TestImageMapLinks(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/imagemap.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/images/regions.png"}, results.AssetURLs())
+
+	links := make([]string, len(results.Links))
+	for i, link := range results.Links {
+		links[i] = link.String()
+	}
+	assert.Equal(t, []string{"/regions/north", "/regions/south", "/regions/east", "/about"}, links)
+}
+
+func TestMediaAssets(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/media.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Empty(t, results.Links)
+	assert.Equal(t, []string{
+		"/images/poster.jpg",
+		"/media/clip.webm",
+		"/media/clip.mp4",
+		"/media/intro.mp4",
+		"/media/song.ogg",
+		"/media/song.mp3",
+		"/media/jingle.mp3",
+		"/images/hero-small.jpg",
+		"/images/hero-large.jpg",
+		"/images/hero.jpg",
+	}, results.AssetURLs())
+}
+
+func TestAssetKinds(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/assets.html")
+	require.NoError(t, err)
+
+	results, err := TokenParser{ExtraAssetAttributes: []string{"data-src"}}.Parse(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []Asset{
+		{URL: "/css/main.css?v=2", Kind: AssetStylesheet},
+		{URL: "/favicon.ico", Kind: AssetImage},
+		{URL: "/touch-icon", Kind: AssetImage},
+		{URL: "/fonts/body.woff2", Kind: AssetFont},
+		{URL: "/site.webmanifest", Kind: AssetOther},
+		{URL: "/js/app.js", Kind: AssetScript},
+		{URL: "/js/loader", Kind: AssetScript},
+		{URL: "/images/logo", Kind: AssetImage},
+		{URL: "/images/banner-large.png", Kind: AssetImage},
+		{URL: "/images/banner.png", Kind: AssetImage},
+		{URL: "/media/intro", Kind: AssetMedia},
+		{URL: "/images/poster.jpg", Kind: AssetImage},
+		{URL: "/media/jingle.mp3", Kind: AssetMedia},
+	}, results.Assets)
+}
+
+func TestForms(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/forms.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	require.Len(t, results.Forms, 3)
+
+	search := results.Forms[0]
+	assert.Equal(t, "/search", search.Action.String())
+	assert.Equal(t, http.MethodPost, search.Method)
+	assert.Equal(t, url.Values{
+		"token": {"abc"},
+		"q":     {"gophers"},
+		"exact": {"1"},
+		"sort":  {"top"},
+	}, search.Values)
+
+	filter := results.Forms[1]
+	assert.Equal(t, "/filter", filter.Action.String())
+	assert.Equal(t, http.MethodGet, filter.Method)
+	assert.Equal(t, url.Values{"tag": {"go", "web"}}, filter.Values)
+
+	comment := results.Forms[2]
+	assert.Equal(t, "", comment.Action.String())
+	assert.Equal(t, http.MethodPost, comment.Method)
+	assert.Equal(t, url.Values{"comment": {""}}, comment.Values)
+}
+
+func TestFormsUnclosed(t *testing.T) {
+	body := []byte(`<form method="post" action="/one"><input name="a" value="1"><form action="/two"><input name="b" value="2">`)
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	require.Len(t, results.Forms, 2)
+	assert.Equal(t, url.Values{"a": {"1"}}, results.Forms[0].Values)
+	assert.Equal(t, url.Values{"b": {"2"}}, results.Forms[1].Values)
+}
+
+func TestTokenParserAnchorText(t *testing.T) {
+	body := []byte(`<a href="/go">Learn <b>Go</b></a>
+		<a href="/go">today</a>
+		<a href="/empty"><img src="/logo.png"></a>
+		<a href="/unclosed">Unclosed <a href="/next">Next</a>
+		<link rel="next" href="/page/2">`)
+
+	results, err := TokenParser{CollectAnchorText: true}.Parse(body)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/go":       "Learn Go today",
+		"/unclosed": "Unclosed",
+		"/next":     "Next",
+	}, results.AnchorText)
+
+	results, err = ByToken(body)
+	assert.NoError(t, err)
+	assert.Nil(t, results.AnchorText)
+}
+
+func TestTokenParserExtraAttributes(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/lazyload.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/images/placeholder.gif"}, results.AssetURLs())
+	assert.Len(t, results.Links, 1)
+
+	p := TokenParser{
+		ExtraLinkAttributes:  []string{"data-href"},
+		ExtraAssetAttributes: []string{"data-src"},
+	}
+	results, err = p.Parse(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"/images/hero.jpg",
+		"/images/placeholder.gif",
+		"/images/gallery/1.jpg",
+		"/images/gallery/2.jpg",
+	}, results.AssetURLs())
+
+	links := make([]string, len(results.Links))
+	for i, link := range results.Links {
+		links[i] = link.String()
+	}
+	assert.Equal(t, []string{"/posts/lazy-loading", "/about"}, links)
+}
+
+func TestTokenParserScriptLinks(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/spa.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Len(t, results.Links, 1)
+
+	p := TokenParser{ScriptLinkPattern: regexp.MustCompile(`["'](/[a-z0-9/-]+)["']`)}
+	results, err = p.Parse(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/js/app.js", "/js/vendor.js"}, results.AssetURLs())
+
+	links := make([]string, len(results.Links))
+	for i, link := range results.Links {
+		links[i] = link.String()
+	}
+	assert.Equal(t, []string{"/dashboard", "/settings/profile", "/about"}, links)
+}
+
+func TestTitleAndDescription(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/seo.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, "Will Demaine & friends", results.Title)
+	assert.Equal(t, "Posts about Go and distributed systems.", results.Description)
+	assert.Equal(t, []string{"/css/main.css"}, results.AssetURLs())
+	assert.Len(t, results.Links, 1)
+
+	results, err = ByToken([]byte(`<html><body><a href="/foo"></a></body></html>`))
+	assert.NoError(t, err)
+	assert.Empty(t, results.Title)
+	assert.Empty(t, results.Description)
+}
+
+func TestCanonical(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/canonical.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	// Only the first canonical counts, and it isn't an asset or a link.
+	require.NotNil(t, results.Canonical)
+	assert.Equal(t, "http://willdemaine.co.uk/posts/", results.Canonical.String())
+	assert.Equal(t, []string{"/css/main.css"}, results.AssetURLs())
+	require.Len(t, results.Links, 1)
+	assert.Equal(t, "/posts/page/3/", results.Links[0].String())
+
+	results, err = ByToken([]byte(`<html><body><a href="/foo"></a></body></html>`))
+	assert.NoError(t, err)
+	assert.Nil(t, results.Canonical)
+}
+
+func TestTextLength(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/thin.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	// Only "Coming soon." counts, not the title, style or script.
+	assert.Equal(t, 12, results.TextLength)
+
+	cases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{"no text", `<img src="/a.png">`, ""},
+		{"inline markup", `<p>Go<em>pher</em>s, <a href="/">home</a>!</p>`, "Gophers, home!"},
+		{"whitespace between tags", "<p>one</p>\n\t<p> two </p>", "one two"},
+		{"multibyte", "<p>caf\u00e9  au lait</p>", "caf\u00e9 au lait"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := ByToken([]byte("<html><body>" + test.body + "</body></html>"))
+			assert.NoError(t, err)
+			assert.Equal(t, len(test.expected), results.TextLength)
+		})
+	}
+}
+
+func TestPreconnectHosts(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/preconnect.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fonts.gstatic.com", "cdn.example.com", "analytics.example.net"}, results.PreconnectHosts)
+	// Only the stylesheet is an asset.
+	assert.Equal(t, []string{"https://fonts.googleapis.com/css?family=Lato"}, results.AssetURLs())
+	assert.Len(t, results.Links, 1)
+}
+
+func TestMetaRobots(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/metarobots.html")
+	require.NoError(t, err)
+
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.True(t, results.NoIndex)
+	assert.True(t, results.NoFollow)
+	assert.Len(t, results.Links, 1)
+
+	cases := []struct {
+		content  string
+		noIndex  bool
+		noFollow bool
+	}{
+		{"index, follow", false, false},
+		{"NOINDEX", true, false},
+		{" nofollow ", false, true},
+		{"none", true, true},
+	}
+	for _, test := range cases {
+		t.Run(test.content, func(t *testing.T) {
+			results, err := ByToken([]byte(`<meta name="robots" content="` + test.content + `">`))
+			assert.NoError(t, err)
+			assert.Equal(t, test.noIndex, results.NoIndex)
+			assert.Equal(t, test.noFollow, results.NoFollow)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Patterns used by the regex parser. These are deliberately loose: they match an attribute
+// anywhere inside an opening tag and accept double, single or unquoted values.
+var (
+	anchorHrefPattern = regexp.MustCompile(`(?is)<a\s[^>]*?\bhref\s*=\s*["']?([^"'\s>]+)`)
+	linkHrefPattern   = regexp.MustCompile(`(?is)<link\s[^>]*?\bhref\s*=\s*["']?([^"'\s>]+)`)
+	srcPattern        = regexp.MustCompile(`(?is)<(img|script)\s[^>]*?\bsrc\s*=\s*["']?([^"'\s>]+)`)
+)
+
+// ByRegex pulls links and assets out of the response using regular expressions.
+// It is less accurate than ByToken, but doesn't care about document structure, so
+// it can recover links from markup that is too broken to tokenize sensibly.
+var ByRegex = Func(func(body []byte) (Results, error) {
+	results := Results{}
+	for _, match := range anchorHrefPattern.FindAllSubmatch(body, -1) {
+		uri, err := url.Parse(string(match[1]))
+		if err != nil {
+			continue
+		}
+		results.Links = append(results.Links, uri)
+	}
+	for _, match := range srcPattern.FindAllSubmatch(body, -1) {
+		kind := srcAssetKinds[strings.ToLower(string(match[1]))]
+		results.Assets = append(results.Assets, newAsset(string(match[2]), kind))
+	}
+	// The rel attribute may come before or after the href, so just go by the extension.
+	for _, match := range linkHrefPattern.FindAllSubmatch(body, -1) {
+		results.Assets = append(results.Assets, newAsset(string(match[1]), ""))
+	}
+	return results, nil
+})
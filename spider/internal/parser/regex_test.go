@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByRegexBrokenMarkup(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/broken.html")
+	require.NoError(t, err)
+
+	// The unterminated comment swallows everything for the tokenizer.
+	results, err := ByToken(body)
+	assert.NoError(t, err)
+	assert.Len(t, results.Links, 0)
+
+	results, err = ByRegex(body)
+	assert.NoError(t, err)
+
+	links := make([]string, len(results.Links))
+	for i, link := range results.Links {
+		links[i] = link.String()
+	}
+	assert.Equal(t, []string{"/about", "/posts/1", "/posts/2", "http://example.com/"}, links)
+	assert.Equal(t, []string{"/images/header.png", "/js/app.js", "/css/main.css"}, results.AssetURLs())
+}
+
+func TestByRegexMissingAttrs(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/missingAttrs.html")
+	require.NoError(t, err)
+
+	results, err := ByRegex(body)
+	assert.NoError(t, err)
+	assert.Len(t, results.Assets, 0)
+	assert.Len(t, results.Links, 0)
+}
+
+func TestByRegexBadURLs(t *testing.T) {
+	results, err := ByRegex([]byte(`<a href=":"></a>`))
+	assert.NoError(t, err)
+	assert.Len(t, results.Links, 0)
+}
+
+func TestAssetKindsByRegex(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/assets.html")
+	require.NoError(t, err)
+
+	results, err := ByRegex(body)
+	assert.NoError(t, err)
+	assert.Equal(t, []Asset{
+		{URL: "/js/app.js", Kind: AssetScript},
+		{URL: "/js/loader", Kind: AssetScript},
+		{URL: "/images/logo", Kind: AssetImage},
+		{URL: "/images/banner.png", Kind: AssetImage},
+		{URL: "/css/main.css?v=2", Kind: AssetStylesheet},
+		{URL: "/favicon.ico", Kind: AssetImage},
+		{URL: "/touch-icon", Kind: AssetOther},
+		{URL: "/fonts/body.woff2", Kind: AssetFont},
+		{URL: "/site.webmanifest", Kind: AssetOther},
+	}, results.Assets)
+}
@@ -0,0 +1,53 @@
+package parser
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math/bits"
+	"unicode"
+)
+
+// simHasher builds a SimHash of a page's text, a fingerprint which only differs in a few bits
+// between pages whose text is almost the same. Each word votes on every bit of the hash.
+type simHasher struct {
+	weights [64]int
+	words   int
+}
+
+// add adds the words of the text.
+func (h *simHasher) add(text []byte) {
+	for _, word := range bytes.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		hash := fnv.New64a()
+		hash.Write(bytes.ToLower(word))
+		sum := hash.Sum64()
+		for i := range h.weights {
+			if sum&(1<<uint(i)) != 0 {
+				h.weights[i]++
+			} else {
+				h.weights[i]--
+			}
+		}
+		h.words++
+	}
+}
+
+// sum returns the hash, or zero if there was no text.
+func (h *simHasher) sum() uint64 {
+	if h.words == 0 {
+		return 0
+	}
+	var sum uint64
+	for i, weight := range h.weights {
+		if weight > 0 {
+			sum |= 1 << uint(i)
+		}
+	}
+	return sum
+}
+
+// Similarity returns how alike two SimHashes are, from 0 for opposites to 1 for the same.
+func Similarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const article = `
+	Go is an open source programming language which makes it simple to build secure, scalable
+	systems. It was designed at Google to improve programming productivity in an era of multicore,
+	networked machines and large codebases. The designers wanted to address criticism of other
+	languages in use at Google while keeping their useful characteristics: static typing and run
+	time efficiency, readability and usability, and high performance networking and multiprocessing.
+`
+
+func TestSimHash(t *testing.T) {
+	p := TokenParser{ComputeSimHash: true}
+	parse := func(body string) uint64 {
+		results, err := p.Parse([]byte(body))
+		require.NoError(t, err)
+		return results.SimHash
+	}
+
+	original := parse(`<html><body><p>` + article + `</p></body></html>`)
+	require.NotZero(t, original)
+
+	// Markup, case, scripts and styles don't change the hash.
+	restyled := parse(`<html><head><style>p { color: red; }</style><script>var x = 1;</script></head>` +
+		`<body><div><P>` + article + `</P></div></body></html>`)
+	assert.Equal(t, original, restyled)
+
+	edited := parse(`<html><body><p>` + article + ` It was announced in 2009.</p></body></html>`)
+	assert.True(t, Similarity(original, edited) > 0.9, "similarity %f", Similarity(original, edited))
+
+	other := parse(`<html><body><p>Rust is a multi paradigm language focused on memory safety ` +
+		`without a garbage collector, using a borrow checker to validate references.</p></body></html>`)
+	assert.True(t, Similarity(original, other) < 0.8, "similarity %f", Similarity(original, other))
+
+	assert.Zero(t, parse(`<html><body><img src="/a.png"></body></html>`))
+	results, err := ByToken([]byte(`<p>` + article + `</p>`))
+	require.NoError(t, err)
+	assert.Zero(t, results.SimHash)
+}
+
+func TestSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, Similarity(0xff, 0xff))
+	assert.Equal(t, 0.0, Similarity(0, ^uint64(0)))
+	assert.Equal(t, 1-2.0/64, Similarity(0x3, 0))
+}
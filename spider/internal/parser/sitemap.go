@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"encoding/xml"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// urlset is the root element of a sitemap.
+type urlset struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+}
+
+// lastModLayouts are the W3C datetime formats allowed for lastmod, most precise first.
+var lastModLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+}
+
+// SitemapEntry is a page listed in a sitemap.
+type SitemapEntry struct {
+	URL *url.URL
+	// LastMod is when the page was last modified, or zero if the sitemap doesn't say.
+	LastMod time.Time
+}
+
+// SitemapEntries parses the pages out of an XML sitemap. Invalid URLs are skipped, and
+// invalid lastmod dates are ignored.
+func SitemapEntries(body []byte) ([]SitemapEntry, error) {
+	var set urlset
+	err := xml.Unmarshal(body, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]SitemapEntry, 0, len(set.URLs))
+	for _, entry := range set.URLs {
+		uri, err := url.Parse(strings.TrimSpace(entry.Loc))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, SitemapEntry{
+			URL:     uri,
+			LastMod: parseLastMod(strings.TrimSpace(entry.LastMod)),
+		})
+	}
+	return entries, nil
+}
+
+// parseLastMod parses a lastmod date, returning zero if it isn't valid.
+func parseLastMod(value string) time.Time {
+	for _, layout := range lastModLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
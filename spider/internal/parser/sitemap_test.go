@@ -0,0 +1,47 @@
+package parser
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSitemap(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/sitemap.xml")
+	require.NoError(t, err)
+
+	entries, err := SitemapEntries(body)
+	require.NoError(t, err)
+
+	locs := make([]string, len(entries))
+	for i, entry := range entries {
+		locs[i] = entry.URL.String()
+	}
+	assert.Equal(t, []string{
+		"http://willdemaine.co.uk/",
+		"http://willdemaine.co.uk/foo",
+		"http://willdemaine.co.uk/orphan",
+	}, locs)
+}
+
+func TestSitemapInvalid(t *testing.T) {
+	_, err := SitemapEntries([]byte("<urlset><url>"))
+	assert.Error(t, err)
+}
+
+func TestSitemapEntries(t *testing.T) {
+	body, err := ioutil.ReadFile("./testdata/sitemap.xml")
+	require.NoError(t, err)
+
+	entries, err := SitemapEntries(body)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, "http://willdemaine.co.uk/", entries[0].URL.String())
+	assert.True(t, time.Date(2017, 6, 1, 11, 30, 0, 0, time.UTC).Equal(entries[0].LastMod))
+	assert.True(t, time.Date(2017, 5, 20, 0, 0, 0, 0, time.UTC).Equal(entries[1].LastMod))
+	assert.True(t, entries[2].LastMod.IsZero())
+}
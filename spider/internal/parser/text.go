@@ -0,0 +1,32 @@
+package parser
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// textMeter measures a page's text as it is split across tokens, counting each run of
+// whitespace as a single space. Whitespace at the start and end isn't counted, and text nodes
+// which touch, such as in "Coming <b>soon</b>.", are joined without a space.
+type textMeter struct {
+	length int
+	// space is true if whitespace has been seen since the last text.
+	space bool
+}
+
+// add adds the text of a token.
+func (m *textMeter) add(text []byte) {
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+		if unicode.IsSpace(r) {
+			m.space = true
+			continue
+		}
+		if m.space && m.length > 0 {
+			m.length++
+		}
+		m.space = false
+		m.length += size
+	}
+}
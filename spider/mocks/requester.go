@@ -1,6 +1,8 @@
 package mocks
 
 import context "context"
+import http "net/http"
+import io "io"
 import mock "github.com/stretchr/testify/mock"
 
 import url "net/url"
@@ -10,22 +12,22 @@ type Requester struct {
 	mock.Mock
 }
 
-// Request provides a mock function with given fields: ctx, uri
-func (_m *Requester) Request(ctx context.Context, uri *url.URL) ([]byte, error) {
-	ret := _m.Called(ctx, uri)
+// Do provides a mock function with given fields: ctx, method, uri, body, headers
+func (_m *Requester) Do(ctx context.Context, method string, uri *url.URL, body io.Reader, headers http.Header) (*http.Response, error) {
+	ret := _m.Called(ctx, method, uri, body, headers)
 
-	var r0 []byte
-	if rf, ok := ret.Get(0).(func(context.Context, *url.URL) []byte); ok {
-		r0 = rf(ctx, uri)
+	var r0 *http.Response
+	if rf, ok := ret.Get(0).(func(context.Context, string, *url.URL, io.Reader, http.Header) *http.Response); ok {
+		r0 = rf(ctx, method, uri, body, headers)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]byte)
+			r0 = ret.Get(0).(*http.Response)
 		}
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(context.Context, *url.URL) error); ok {
-		r1 = rf(ctx, uri)
+	if rf, ok := ret.Get(1).(func(context.Context, string, *url.URL, io.Reader, http.Header) error); ok {
+		r1 = rf(ctx, method, uri, body, headers)
 	} else {
 		r1 = ret.Error(1)
 	}
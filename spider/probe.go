@@ -0,0 +1,133 @@
+package spider
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Willyham/gospider/spider/reporter"
+	"go.uber.org/zap"
+)
+
+// assetSet collects the unique assets found during the crawl, for WithAssetProbing. It is safe
+// for concurrent use.
+type assetSet struct {
+	seen   map[string]bool
+	assets []*url.URL
+	sync.Mutex
+}
+
+func newAssetSet() *assetSet {
+	return &assetSet{
+		seen: make(map[string]bool),
+	}
+}
+
+// add resolves the page's assets and records any which are new. Assets which can't be fetched
+// over HTTP, such as data URIs, are ignored.
+func (a *assetSet) add(page *url.URL, assets []string) {
+	a.Lock()
+	defer a.Unlock()
+	for _, asset := range assets {
+		uri, err := url.Parse(asset)
+		if err != nil {
+			continue
+		}
+		uri = page.ResolveReference(uri)
+		if uri.Scheme != "http" && uri.Scheme != "https" {
+			continue
+		}
+		uri.Fragment = ""
+		key := uri.String()
+		if a.seen[key] {
+			continue
+		}
+		a.seen[key] = true
+		a.assets = append(a.assets, uri)
+	}
+}
+
+// list returns the assets in the order they were found.
+func (a *assetSet) list() []*url.URL {
+	a.Lock()
+	defer a.Unlock()
+	return append([]*url.URL(nil), a.assets...)
+}
+
+// probeAssets requests each asset found during the crawl once and tells the reporter whether
+// it was reachable, if it's interested. Probes are made by as many workers as the crawl used,
+// but no more often than the probe interval.
+func (s *Spider) probeAssets(ctx context.Context) error {
+	r, ok := s.reporter.(reporter.AssetReporter)
+	if !ok || s.probedAssets == nil {
+		return nil
+	}
+
+	var tick <-chan time.Time
+	if s.assetProbeInterval > 0 {
+		ticker := time.NewTicker(s.assetProbeInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	assets := make(chan *url.URL)
+	var wg sync.WaitGroup
+	for i := 0; i < s.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for uri := range assets {
+				r.AddAssetStatus(s.probeAsset(ctx, uri))
+			}
+		}()
+	}
+	defer func() {
+		close(assets)
+		wg.Wait()
+	}()
+
+	for i, uri := range s.probedAssets.list() {
+		if i > 0 && tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case assets <- uri:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// probeAsset makes a HEAD request for the asset. Servers which don't allow HEAD are asked
+// with GET instead.
+func (s *Spider) probeAsset(ctx context.Context, uri *url.URL) reporter.AssetStatus {
+	ctx, cancel := context.WithTimeout(ctx, s.timeoutFor(uri))
+	defer cancel()
+
+	requester := s.requesterFor(uri)
+	res, err := requester.Do(ctx, http.MethodHead, uri, nil, nil)
+	if httpErr, ok := err.(httpResponseError); ok &&
+		(httpErr.statusCode == http.StatusMethodNotAllowed || httpErr.statusCode == http.StatusNotImplemented) {
+		res, err = requester.Do(ctx, http.MethodGet, uri, nil, nil)
+	}
+
+	status := reporter.AssetStatus{URL: uri}
+	if err != nil {
+		s.logger.Warn("Asset is unreachable", zap.String("url", uri.String()), zap.Error(err))
+		status.Error = err.Error()
+		if httpErr, ok := err.(httpResponseError); ok {
+			status.StatusCode = httpErr.statusCode
+		}
+		return status
+	}
+	res.Body.Close()
+	status.StatusCode = res.StatusCode
+	return status
+}
@@ -2,14 +2,54 @@ package spider
 
 import (
 	"net/url"
+	"sort"
 	"sync"
+
+	"go.uber.org/zap"
 )
 
+// queueItem is a URL waiting to be crawled, along with how it was found.
+type queueItem struct {
+	url *url.URL
+	// depth is how many links away from the root the URL is. Seeds have depth 0.
+	depth int
+	// referrer is the page the URL was found on, or nil for seeds.
+	referrer *url.URL
+	// method and body are set for requests other than GET, such as submitted forms.
+	method string
+	body   url.Values
+	// priority is above zero for items which should be crawled before others.
+	priority int
+	// root is true for the root, which the crawl can't go on without.
+	root bool
+}
+
+// request returns how the item should be requested.
+func (i *queueItem) request() Request {
+	return Request{URL: i.url, Method: i.method, Body: i.body}
+}
+
 // urlQueue is a structure which maintains a queue of URLs.
 // it also records a list of all URLs seen and implements the Seener interface.
 type urlQueue struct {
-	urls []*url.URL
-	seen map[string]bool
+	items []*queueItem
+	// prioritized are items with a priority, which are taken before any in items. They're
+	// sorted by priority, highest last.
+	prioritized []*queueItem
+	seen        map[string]bool
+	// keyTransform, if set, rewrites URLs before they're used as seen keys, so that different
+	// forms of the same page are only crawled once. The URL is still crawled as it was found.
+	keyTransform urlTransform
+	// inFlight are items which have been taken but not yet finished with, so that they aren't
+	// lost from a saved state.
+	inFlight map[*queueItem]bool
+	// maxBytes bounds the estimated memory used by waiting items. Items which don't fit are
+	// spilled to disk, and bytes is the estimated memory used by those which did.
+	maxBytes int64
+	bytes    int64
+	spill    *queueSpill
+	spillErr error
+	logger   *zap.Logger
 	sync.RWMutex
 }
 
@@ -17,30 +57,155 @@ var _ Seener = new(urlQueue)
 
 func newURLQueue() *urlQueue {
 	return &urlQueue{
-		seen: make(map[string]bool),
+		seen:     make(map[string]bool),
+		inFlight: make(map[*queueItem]bool),
+		logger:   zap.NewNop(),
+	}
+}
+
+// urlKey returns the key the URL is recorded as seen by.
+func (q *urlQueue) urlKey(uri *url.URL) string {
+	if q.keyTransform != nil {
+		uri = q.keyTransform(uri)
+	}
+	return uri.String()
+}
+
+// requestKey returns the key the item's request is recorded as seen by.
+func (q *urlQueue) requestKey(item *queueItem) string {
+	req := item.request()
+	if q.keyTransform != nil {
+		req.URL = q.keyTransform(req.URL)
 	}
+	return req.key()
 }
+
 func (q *urlQueue) Seen(item *url.URL) bool {
 	q.RLock()
-	_, seen := q.seen[item.String()]
+	_, seen := q.seen[q.urlKey(item)]
 	q.RUnlock()
 	return seen
 }
 
-func (q *urlQueue) Next() *url.URL {
+func (q *urlQueue) Next() *queueItem {
 	q.Lock()
 	defer q.Unlock()
-	if len(q.urls) == 0 {
-		return nil
+	var next *queueItem
+	if len(q.prioritized) > 0 {
+		next, q.prioritized = q.prioritized[len(q.prioritized)-1], q.prioritized[:len(q.prioritized)-1]
+	} else {
+		q.unspill()
+		if len(q.items) == 0 {
+			return nil
+		}
+		next, q.items = q.items[len(q.items)-1], q.items[:len(q.items)-1]
+	}
+	if q.maxBytes > 0 {
+		q.bytes -= estimateSize(next)
 	}
-	var next *url.URL
-	next, q.urls = q.urls[len(q.urls)-1], q.urls[:len(q.urls)-1]
+	q.inFlight[next] = true
 	return next
 }
 
+// Done records that an item taken with Next has been finished with, whether or not it was
+// crawled successfully.
+func (q *urlQueue) Done(item *queueItem) {
+	q.Lock()
+	delete(q.inFlight, item)
+	q.Unlock()
+}
+
+// Append adds the URL to the queue as a seed.
 func (q *urlQueue) Append(item *url.URL) {
 	q.Lock()
-	q.urls = append(q.urls, item)
-	q.seen[item.String()] = true
+	q.spillOrPush(&queueItem{url: item})
+	q.seen[q.urlKey(item)] = true
 	q.Unlock()
 }
+
+// MarkSeen records the URL as seen without adding it to the queue.
+func (q *urlQueue) MarkSeen(item *url.URL) {
+	q.Lock()
+	q.seen[q.urlKey(item)] = true
+	q.Unlock()
+}
+
+// AppendUnseen adds the item to the queue only if its request hasn't been seen before. Checking
+// and appending are atomic, so concurrent callers can't both add the same request.
+func (q *urlQueue) AppendUnseen(item *queueItem) bool {
+	q.Lock()
+	defer q.Unlock()
+	key := q.requestKey(item)
+	if q.seen[key] {
+		return false
+	}
+	q.seen[key] = true
+	q.spillOrPush(item)
+	return true
+}
+
+// push adds the item to the queue. The lock must be held.
+func (q *urlQueue) push(item *queueItem) {
+	if item.priority <= 0 {
+		q.items = append(q.items, item)
+		return
+	}
+	// Insert after items with the same priority, so that they're taken last in first out like
+	// the rest of the queue.
+	i := sort.Search(len(q.prioritized), func(i int) bool {
+		return q.prioritized[i].priority > item.priority
+	})
+	q.prioritized = append(q.prioritized, nil)
+	copy(q.prioritized[i+1:], q.prioritized[i:])
+	q.prioritized[i] = item
+}
+
+// Len returns the number of URLs waiting in the queue.
+func (q *urlQueue) Len() int {
+	q.RLock()
+	defer q.RUnlock()
+	return len(q.items) + len(q.prioritized) + q.spilled()
+}
+
+// pendingCount counts the pages which have been queued but not yet crawled. Unlike a
+// sync.WaitGroup, waiting on it can be abandoned, so nothing is left blocked if the crawl
+// stops before every page is done.
+type pendingCount struct {
+	n    int
+	zero chan struct{}
+	sync.Mutex
+}
+
+// Add adds delta, which may be negative, to the count.
+func (p *pendingCount) Add(delta int) {
+	p.Lock()
+	defer p.Unlock()
+	p.n += delta
+	if p.n < 0 {
+		panic("spider: negative pending count")
+	}
+	if p.n == 0 && p.zero != nil {
+		close(p.zero)
+		p.zero = nil
+	}
+}
+
+// Done decrements the count by one.
+func (p *pendingCount) Done() {
+	p.Add(-1)
+}
+
+// Zero returns a channel which is closed once the count reaches zero.
+func (p *pendingCount) Zero() <-chan struct{} {
+	p.Lock()
+	defer p.Unlock()
+	if p.n == 0 {
+		zero := make(chan struct{})
+		close(zero)
+		return zero
+	}
+	if p.zero == nil {
+		p.zero = make(chan struct{})
+	}
+	return p.zero
+}
@@ -0,0 +1,113 @@
+package spider
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueuePriority(t *testing.T) {
+	q := newURLQueue()
+	items := []struct {
+		path     string
+		priority int
+	}{
+		{"/a", 0},
+		{"/b", 1},
+		{"/c", 0},
+		{"/d", 2},
+		{"/e", 1},
+	}
+	for _, item := range items {
+		uri, err := url.Parse("http://willdemaine.co.uk" + item.path)
+		require.NoError(t, err)
+		assert.True(t, q.AppendUnseen(&queueItem{url: uri, priority: item.priority}))
+	}
+	assert.Equal(t, len(items), q.Len())
+
+	// Higher priorities come first, and otherwise the queue is last in first out.
+	var order []string
+	for next := q.Next(); next != nil; next = q.Next() {
+		order = append(order, next.url.Path)
+	}
+	assert.Equal(t, []string{"/d", "/e", "/b", "/c", "/a"}, order)
+	assert.Equal(t, 0, q.Len())
+}
+
+func TestQueueMaxBytes(t *testing.T) {
+	fill := func(q *urlQueue, pathLength int) {
+		for i := 0; i < 10; i++ {
+			uri, err := url.Parse(fmt.Sprintf("http://willdemaine.co.uk/%d/%s", i, strings.Repeat("a", pathLength)))
+			require.NoError(t, err)
+			require.True(t, q.AppendUnseen(&queueItem{url: uri}))
+		}
+	}
+
+	// Ten short URLs are well within the bound, so nothing is spilled.
+	q := newURLQueue()
+	q.maxBytes = 4096
+	fill(q, 10)
+	assert.Nil(t, q.spill)
+	assert.Len(t, q.items, 10)
+
+	// The same number of long URLs is over it, so all but the first are spilled.
+	q = newURLQueue()
+	q.maxBytes = 4096
+	fill(q, 2000)
+	require.NotNil(t, q.spill)
+	assert.Len(t, q.items, 1)
+	assert.Equal(t, 9, q.spill.count)
+	assert.Equal(t, 10, q.Len())
+	assert.True(t, q.bytes <= q.maxBytes)
+
+	state, err := q.snapshot()
+	require.NoError(t, err)
+	assert.Len(t, state.Pending, 10)
+
+	// Every item comes back as the queue empties.
+	seen := make(map[string]bool)
+	for next := q.Next(); next != nil; next = q.Next() {
+		assert.True(t, q.bytes <= q.maxBytes)
+		seen[next.url.String()] = true
+	}
+	assert.Len(t, seen, 10)
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, int64(0), q.bytes)
+	assert.NoError(t, q.spillError())
+
+	name := q.spill.w.Name()
+	q.closeSpill()
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestPendingCount(t *testing.T) {
+	var p pendingCount
+	assert.True(t, isClosed(p.Zero()), "nothing pending")
+
+	p.Add(2)
+	zero := p.Zero()
+	p.Done()
+	assert.False(t, isClosed(zero))
+	p.Done()
+	assert.True(t, isClosed(zero))
+
+	// The count can go up again after reaching zero.
+	p.Add(1)
+	assert.False(t, isClosed(p.Zero()))
+	assert.Panics(t, func() { p.Add(-2) })
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
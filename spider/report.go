@@ -0,0 +1,56 @@
+package spider
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// ReportContext writes the report to the writer, giving up when the context is done. Reporters
+// which accumulate pages can take a long time to write on huge crawls, so anything written
+// before the context is done is kept as a partial report, and the context's error is returned.
+func (s *Spider) ReportContext(ctx context.Context, w io.Writer) error {
+	cw := &contextWriter{
+		ctx:    ctx,
+		writer: w,
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.reporter.Report(cw)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		cw.close()
+		return ctx.Err()
+	}
+}
+
+// contextWriter is a writer which stops writing once its context is done or it is closed,
+// so nothing is written after ReportContext returns.
+type contextWriter struct {
+	ctx    context.Context
+	writer io.Writer
+	closed bool
+	sync.Mutex
+}
+
+func (w *contextWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.writer.Write(p)
+}
+
+func (w *contextWriter) close() {
+	w.Lock()
+	w.closed = true
+	w.Unlock()
+}
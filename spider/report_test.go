@@ -0,0 +1,63 @@
+package spider
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Willyham/gospider/spider/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowReporter writes each chunk with a delay in between.
+type slowReporter struct {
+	chunks []string
+	delay  time.Duration
+}
+
+func (r *slowReporter) Add(reporter.PageInfo) {}
+
+func (r *slowReporter) Report(w io.Writer) error {
+	for _, chunk := range r.chunks {
+		_, err := io.WriteString(w, chunk)
+		if err != nil {
+			return err
+		}
+		time.Sleep(r.delay)
+	}
+	return nil
+}
+
+func TestReportContext(t *testing.T) {
+	s := New(WithRoot(willydURL))
+	s.reporter = &slowReporter{chunks: []string{"a", "b"}}
+
+	buf := bytes.NewBuffer(nil)
+	err := s.ReportContext(context.Background(), buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", buf.String())
+}
+
+func TestReportContextDeadline(t *testing.T) {
+	s := New(WithRoot(willydURL))
+	s.reporter = &slowReporter{
+		chunks: []string{"a", "b", "c"},
+		delay:  time.Millisecond * 50,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer cancel()
+
+	buf := bytes.NewBuffer(nil)
+	start := time.Now()
+	err := s.ReportContext(ctx, buf)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.True(t, time.Since(start) < time.Millisecond*50)
+	assert.Equal(t, "a", buf.String())
+
+	// Nothing else is written once we've given up.
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, "a", buf.String())
+}
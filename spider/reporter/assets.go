@@ -0,0 +1,116 @@
+package reporter
+
+import (
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// InventoryAsset is an asset in an AssetInventory.
+type InventoryAsset struct {
+	URL string
+	// Probed is false if the asset's status is unknown, e.g. because probing wasn't enabled.
+	Probed     bool
+	StatusCode int
+	Error      string
+	// Pages are the pages which load the asset, sorted.
+	Pages []string
+}
+
+// AssetInventory is a reporter that lists every unique asset, whether it was reachable and
+// the pages which load it. Asset statuses are only known if the spider probes assets.
+type AssetInventory struct {
+	pages    map[string]bool
+	assets   map[string]map[string]bool
+	statuses map[string]AssetStatus
+	sync.Mutex
+}
+
+// NewAssetInventory creates a new AssetInventory reporter.
+func NewAssetInventory() *AssetInventory {
+	return &AssetInventory{
+		pages:    make(map[string]bool),
+		assets:   make(map[string]map[string]bool),
+		statuses: make(map[string]AssetStatus),
+	}
+}
+
+// Add the page's assets to the inventory. Pages which have already been added are ignored.
+func (r *AssetInventory) Add(page PageInfo) {
+	r.Lock()
+	defer r.Unlock()
+	key := page.URL.String()
+	if r.pages[key] {
+		return
+	}
+	r.pages[key] = true
+	for _, asset := range page.Assets {
+		uri, err := url.Parse(asset)
+		if err != nil {
+			continue
+		}
+		uri = page.URL.ResolveReference(uri)
+		uri.Fragment = ""
+		if r.assets[uri.String()] == nil {
+			r.assets[uri.String()] = make(map[string]bool)
+		}
+		r.assets[uri.String()][key] = true
+	}
+}
+
+// AddAssetStatus records whether an asset was reachable.
+func (r *AssetInventory) AddAssetStatus(status AssetStatus) {
+	r.Lock()
+	defer r.Unlock()
+	r.statuses[status.URL.String()] = status
+}
+
+// Assets returns the inventory, sorted by URL.
+func (r *AssetInventory) Assets() []InventoryAsset {
+	r.Lock()
+	defer r.Unlock()
+	keys := make([]string, 0, len(r.assets))
+	for key := range r.assets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	assets := make([]InventoryAsset, 0, len(keys))
+	for _, key := range keys {
+		asset := InventoryAsset{URL: key}
+		if status, ok := r.statuses[key]; ok {
+			asset.Probed = true
+			asset.StatusCode = status.StatusCode
+			asset.Error = status.Error
+		}
+		for page := range r.assets[key] {
+			asset.Pages = append(asset.Pages, page)
+		}
+		sort.Strings(asset.Pages)
+		assets = append(assets, asset)
+	}
+	return assets
+}
+
+// Report writes the inventory to the given writer. Each asset is on a line starting with its
+// status code, "error" if no response was received or "-" if it wasn't probed, followed by the
+// pages which load it on indented lines.
+func (r *AssetInventory) Report(w io.Writer) error {
+	ew := &errWriter{w: w}
+	for _, asset := range r.Assets() {
+		status := "-"
+		switch {
+		case asset.StatusCode != 0:
+			status = strconv.Itoa(asset.StatusCode)
+		case asset.Probed:
+			status = "error"
+		}
+		ew.printf("%s %s\n", status, asset.URL)
+		for _, page := range asset.Pages {
+			ew.printf("  %s\n", page)
+		}
+	}
+	return ew.err
+}
@@ -0,0 +1,40 @@
+package reporter
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssetInventoryReport(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about/")
+	require.NoError(t, err)
+	logo, err := url.Parse("http://willdemaine.co.uk/logo.png")
+	require.NoError(t, err)
+	font, err := url.Parse("http://fonts.example.com/font.woff2")
+	require.NoError(t, err)
+
+	r := NewAssetInventory()
+	r.Add(PageInfo{URL: root, Assets: []string{"/logo.png", "/app.js"}})
+	r.Add(PageInfo{URL: about, Assets: []string{"../logo.png", font.String()}})
+	// Pages added twice are ignored.
+	r.Add(PageInfo{URL: about, Assets: []string{"/other.css"}})
+	r.AddAssetStatus(AssetStatus{URL: logo, StatusCode: 200})
+	r.AddAssetStatus(AssetStatus{URL: font, Error: "no such host"})
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Equal(t, `error http://fonts.example.com/font.woff2
+  http://willdemaine.co.uk/about/
+- http://willdemaine.co.uk/app.js
+  http://willdemaine.co.uk
+200 http://willdemaine.co.uk/logo.png
+  http://willdemaine.co.uk
+  http://willdemaine.co.uk/about/
+`, buf.String())
+}
@@ -0,0 +1,170 @@
+package reporter
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// DiffReport describes what changed between two crawls.
+type DiffReport struct {
+	AddedPages   []string
+	RemovedPages []string
+	// Changed holds pages which are in both crawls but whose links or assets differ.
+	Changed []PageDiff
+}
+
+// PageDiff describes what changed on a page between two crawls.
+type PageDiff struct {
+	URL           string
+	AddedLinks    []string
+	RemovedLinks  []string
+	AddedAssets   []string
+	RemovedAssets []string
+}
+
+// Diff compares two crawls. Everything in the report is sorted, so it is deterministic.
+func Diff(prev, cur ResultSet) DiffReport {
+	prevPages := pagesByURL(prev)
+	curPages := pagesByURL(cur)
+
+	var report DiffReport
+	for _, url := range sortedResultKeys(curPages) {
+		prevPage, ok := prevPages[url]
+		if !ok {
+			report.AddedPages = append(report.AddedPages, url)
+			continue
+		}
+		curPage := curPages[url]
+		diff := PageDiff{URL: url}
+		diff.AddedLinks, diff.RemovedLinks = diffStrings(prevPage.Links, curPage.Links)
+		diff.AddedAssets, diff.RemovedAssets = diffStrings(prevPage.Assets, curPage.Assets)
+		if len(diff.AddedLinks)+len(diff.RemovedLinks)+len(diff.AddedAssets)+len(diff.RemovedAssets) > 0 {
+			report.Changed = append(report.Changed, diff)
+		}
+	}
+	for _, url := range sortedResultKeys(prevPages) {
+		if _, ok := curPages[url]; !ok {
+			report.RemovedPages = append(report.RemovedPages, url)
+		}
+	}
+	return report
+}
+
+// pagesByURL indexes the pages by URL, prefixed with the method for pages crawled by
+// submitting a form.
+func pagesByURL(set ResultSet) map[string]PageResult {
+	pages := make(map[string]PageResult, len(set.Pages))
+	for _, page := range set.Pages {
+		key := page.URL
+		if page.Method != "" {
+			key = page.Method + " " + page.URL
+		}
+		pages[key] = page
+	}
+	return pages
+}
+
+func sortedResultKeys(pages map[string]PageResult) []string {
+	keys := make([]string, 0, len(pages))
+	for key := range pages {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffStrings returns the sorted strings which are only in cur, and only in prev.
+func diffStrings(prev, cur []string) (added []string, removed []string) {
+	inPrev := make(map[string]bool, len(prev))
+	for _, s := range prev {
+		inPrev[s] = true
+	}
+	inCur := make(map[string]bool, len(cur))
+	for _, s := range cur {
+		inCur[s] = true
+		if !inPrev[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range prev {
+		if !inCur[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// DiffReporter is a reporter which outputs what changed since a previous crawl as text.
+type DiffReporter struct {
+	prev  ResultSet
+	pages map[string]PageInfo
+	sync.Mutex
+}
+
+// NewDiffReporter creates a reporter which compares the crawl with prev.
+func NewDiffReporter(prev ResultSet) *DiffReporter {
+	return &DiffReporter{
+		prev:  prev,
+		pages: make(map[string]PageInfo),
+	}
+}
+
+// Add a page to the current crawl. Pages which have already been added are ignored.
+func (r *DiffReporter) Add(page PageInfo) {
+	r.Lock()
+	defer r.Unlock()
+	key := page.key()
+	if _, ok := r.pages[key]; ok {
+		return
+	}
+	r.pages[key] = page
+}
+
+// Report writes the changes since the previous crawl to the given writer, one per line.
+// Lines start with + for additions, - for removals and ~ for changed pages.
+func (r *DiffReporter) Report(w io.Writer) error {
+	r.Lock()
+	report := Diff(r.prev, newResultSet(r.pages))
+	r.Unlock()
+
+	ew := &errWriter{w: w}
+	for _, url := range report.AddedPages {
+		ew.printf("+ page %s\n", url)
+	}
+	for _, url := range report.RemovedPages {
+		ew.printf("- page %s\n", url)
+	}
+	for _, page := range report.Changed {
+		ew.printf("~ page %s\n", page.URL)
+		for _, link := range page.AddedLinks {
+			ew.printf("  + link %s\n", link)
+		}
+		for _, link := range page.RemovedLinks {
+			ew.printf("  - link %s\n", link)
+		}
+		for _, asset := range page.AddedAssets {
+			ew.printf("  + asset %s\n", asset)
+		}
+		for _, asset := range page.RemovedAssets {
+			ew.printf("  - asset %s\n", asset)
+		}
+	}
+	return ew.err
+}
+
+// errWriter writes formatted output until the first error, which it keeps.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = fmt.Fprintf(ew.w, format, args...)
+}
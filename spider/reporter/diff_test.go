@@ -0,0 +1,116 @@
+package reporter
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	prev := ResultSet{
+		Pages: []PageResult{
+			{URL: "http://willdemaine.co.uk", Links: []string{"http://willdemaine.co.uk/a", "http://willdemaine.co.uk/b"}},
+			{URL: "http://willdemaine.co.uk/a", Assets: []string{"/main.css", "/old.js"}},
+			{URL: "http://willdemaine.co.uk/b"},
+			{URL: "http://willdemaine.co.uk/unchanged", Links: []string{"http://willdemaine.co.uk"}},
+		},
+	}
+	cur := ResultSet{
+		Pages: []PageResult{
+			{URL: "http://willdemaine.co.uk", Links: []string{"http://willdemaine.co.uk/c", "http://willdemaine.co.uk/a"}},
+			{URL: "http://willdemaine.co.uk/a", Assets: []string{"/new.js", "/main.css"}},
+			{URL: "http://willdemaine.co.uk/c"},
+			{URL: "http://willdemaine.co.uk/unchanged", Links: []string{"http://willdemaine.co.uk"}},
+		},
+	}
+
+	report := Diff(prev, cur)
+	assert.Equal(t, []string{"http://willdemaine.co.uk/c"}, report.AddedPages)
+	assert.Equal(t, []string{"http://willdemaine.co.uk/b"}, report.RemovedPages)
+	assert.Equal(t, []PageDiff{
+		{
+			URL:          "http://willdemaine.co.uk",
+			AddedLinks:   []string{"http://willdemaine.co.uk/c"},
+			RemovedLinks: []string{"http://willdemaine.co.uk/b"},
+		},
+		{
+			URL:           "http://willdemaine.co.uk/a",
+			AddedAssets:   []string{"/new.js"},
+			RemovedAssets: []string{"/old.js"},
+		},
+	}, report.Changed)
+}
+
+func TestDiffFormPosts(t *testing.T) {
+	prev := ResultSet{
+		Pages: []PageResult{{URL: "http://willdemaine.co.uk/contact"}},
+	}
+	cur := ResultSet{
+		Pages: []PageResult{
+			{URL: "http://willdemaine.co.uk/contact"},
+			{URL: "http://willdemaine.co.uk/contact", Method: "POST"},
+		},
+	}
+
+	report := Diff(prev, cur)
+	assert.Equal(t, []string{"POST http://willdemaine.co.uk/contact"}, report.AddedPages)
+	assert.Empty(t, report.RemovedPages)
+	assert.Empty(t, report.Changed)
+}
+
+func TestDiffIdentical(t *testing.T) {
+	set := ResultSet{
+		Pages: []PageResult{{URL: "http://willdemaine.co.uk", Links: []string{"http://willdemaine.co.uk/a"}}},
+	}
+	assert.Equal(t, DiffReport{}, Diff(set, set))
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	page, err := url.Parse("http://willdemaine.co.uk/a")
+	require.NoError(t, err)
+
+	r := NewJSON()
+	r.SetMetadata(map[string]string{"title": "Nightly"})
+	r.Add(PageInfo{URL: page, Assets: []string{"/main.css"}})
+	r.Add(PageInfo{URL: root, Links: []*url.URL{page}})
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+
+	set, err := ReadResultSet(buf)
+	require.NoError(t, err)
+	assert.Equal(t, r.ResultSet(), set)
+	assert.Equal(t, "Nightly", set.Metadata["title"])
+	require.Len(t, set.Pages, 2)
+	assert.Equal(t, "http://willdemaine.co.uk", set.Pages[0].URL)
+	assert.Equal(t, []string{"http://willdemaine.co.uk/a"}, set.Pages[0].Links)
+}
+
+func TestReportDiff(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	page, err := url.Parse("http://willdemaine.co.uk/new")
+	require.NoError(t, err)
+
+	prev := ResultSet{
+		Pages: []PageResult{
+			{URL: "http://willdemaine.co.uk", Assets: []string{"/main.css"}},
+			{URL: "http://willdemaine.co.uk/old"},
+		},
+	}
+	r := NewDiffReporter(prev)
+	r.Add(PageInfo{URL: root, Links: []*url.URL{page}, Assets: []string{"/main.css"}})
+	r.Add(PageInfo{URL: page})
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Equal(t, "+ page http://willdemaine.co.uk/new\n"+
+		"- page http://willdemaine.co.uk/old\n"+
+		"~ page http://willdemaine.co.uk\n"+
+		"  + link http://willdemaine.co.uk/new\n", buf.String())
+}
@@ -0,0 +1,138 @@
+package reporter
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"sync"
+)
+
+const graphMLNamespace = "http://graphml.graphdrawing.org/xmlns"
+
+// Keys for the data attached to GraphML nodes.
+const (
+	graphMLKeyStatus = "status"
+	graphMLKeyDepth  = "depth"
+)
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+// GraphML is a reporter that outputs the link graph as GraphML, for tools such as Gephi or yEd.
+// Every crawled page is a node, identified by its URL, and every link is a directed edge.
+type GraphML struct {
+	pages map[string]PageInfo
+	sync.Mutex
+}
+
+// NewGraphML creates a new GraphML reporter.
+func NewGraphML() *GraphML {
+	return &GraphML{
+		pages: make(map[string]PageInfo),
+	}
+}
+
+// Add a page to the graph. Pages which have already been added are ignored.
+func (r *GraphML) Add(page PageInfo) {
+	r.Lock()
+	defer r.Unlock()
+	key := page.URL.String()
+	if _, ok := r.pages[key]; ok {
+		return
+	}
+	r.pages[key] = page
+}
+
+// Report writes GraphML to the given writer.
+func (r *GraphML) Report(w io.Writer) error {
+	r.Lock()
+	doc := r.build()
+	r.Unlock()
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
+
+// build creates the GraphML document. Nodes are sorted by URL so the output is deterministic.
+// Links to pages which weren't crawled are included as nodes without any data.
+func (r *GraphML) build() graphMLDocument {
+	graph := graphMLGraph{
+		ID:          "site",
+		EdgeDefault: "directed",
+	}
+
+	nodes := make(map[string]bool)
+	for _, key := range sortedKeys(r.pages) {
+		page := r.pages[key]
+		nodes[key] = true
+		graph.Nodes = append(graph.Nodes, graphMLNode{
+			ID: key,
+			Data: []graphMLData{
+				{Key: graphMLKeyStatus, Value: strconv.Itoa(page.StatusCode)},
+				{Key: graphMLKeyDepth, Value: strconv.Itoa(page.Depth)},
+			},
+		})
+	}
+	for _, key := range sortedKeys(r.pages) {
+		for _, link := range r.pages[key].Links {
+			target := link.String()
+			graph.Edges = append(graph.Edges, graphMLEdge{Source: key, Target: target})
+			if !nodes[target] {
+				nodes[target] = true
+				graph.Nodes = append(graph.Nodes, graphMLNode{ID: target})
+			}
+		}
+	}
+
+	return graphMLDocument{
+		XMLNS: graphMLNamespace,
+		Keys: []graphMLKey{
+			{ID: graphMLKeyStatus, For: "node", Name: graphMLKeyStatus, Type: "int"},
+			{ID: graphMLKeyDepth, For: "node", Name: graphMLKeyDepth, Type: "int"},
+		},
+		Graph: graph,
+	}
+}
@@ -0,0 +1,46 @@
+package reporter
+
+import (
+	"bytes"
+	"encoding/xml"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReportGraphML(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	page, err := url.Parse("http://willdemaine.co.uk/search?q=a&b=<c>")
+	require.NoError(t, err)
+
+	uncrawled, err := url.Parse("http://willdemaine.co.uk/uncrawled")
+	require.NoError(t, err)
+
+	r := NewGraphML()
+	r.Add(PageInfo{URL: root, Links: []*url.URL{page, uncrawled}, StatusCode: 200})
+	r.Add(PageInfo{URL: page, Links: []*url.URL{root}, StatusCode: 200, Depth: 1})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	require.NoError(t, err)
+
+	var doc graphMLDocument
+	err = xml.Unmarshal(buf.Bytes(), &doc)
+	require.NoError(t, err)
+	assert.Len(t, doc.Graph.Nodes, 3)
+	assert.Len(t, doc.Graph.Edges, 3)
+
+	assert.Equal(t, "http://willdemaine.co.uk/search?q=a&b=<c>", doc.Graph.Nodes[1].ID)
+	assert.Equal(t, []graphMLData{{Key: "status", Value: "200"}, {Key: "depth", Value: "1"}}, doc.Graph.Nodes[1].Data)
+	assert.Empty(t, doc.Graph.Nodes[2].Data)
+
+	// The same pages always produce the same output.
+	again := bytes.NewBuffer(nil)
+	err = r.Report(again)
+	require.NoError(t, err)
+	assert.Equal(t, buf.String(), again.String())
+}
@@ -4,67 +4,732 @@ import (
 	"html/template"
 	"io"
 	"net/url"
+	"sort"
+	"strings"
 	"sync"
 )
 
 var sitemapHTML = `
 <html>
-<head></head>
+<head>{{ with .Metadata.title }}<title>{{ . }}</title>{{ end }}</head>
 <body>
-	{{ range $key, $value := . }}
+	{{ with .Metadata }}
 		<div>
-		 <h2><div id="{{ $key.Path }}">Page {{ $key }}</div></h2>
+		 {{ with .title }}<h1>{{ . }}</h1>{{ end }}
+		 <dl>
+		 {{ range $key, $value := . }}
+				<dt>{{ $key }}</dt><dd>{{ $value }}</dd>
+		 {{ end }}
+		 </dl>
+		</div>
+	{{ end }}
+	{{ if .Truncated }}
+		<div>
+		 <p>The crawl reached its limit on stored links and assets, so some pages' links and assets aren't listed, and some pages may wrongly appear as orphans.</p>
+		</div>
+	{{ end }}
+	{{ with .Resources }}
+		<div>
+		 <h2>Consulted</h2>
+		 {{ range . }}
+				<li>{{ .URL }}: {{ if .StatusCode }}{{ .StatusCode }}{{ else }}{{ .Error }}{{ end }}</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .Soft404s }}
+		<div>
+		 <h2>Soft 404s</h2>
+		 {{ range . }}
+				<li><a href="#{{ .Path }}">{{ . }}</a></li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .Slow }}
+		<div>
+		 <h2>Slow pages</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a> ({{ .Latency }})</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .SlowestToParse }}
+		<div>
+		 <h2>Slowest to parse</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a> ({{ .ParseDuration }})</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .Thin }}
+		<div>
+		 <h2>Thin pages</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a> ({{ .TextLength }} bytes of text)</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .ManyLinks }}
+		<div>
+		 <h2>Pages with many links</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a> ({{ .LinkCount }} links)</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .MixedContent }}
+		<div>
+		 <h2>Mixed content</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a> loads {{ range $i, $asset := .MixedContent }}{{ if $i }}, {{ end }}{{ $asset }}{{ end }}</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .ThirdPartyHosts }}
+		<div>
+		 <h2>Third-party hosts</h2>
+		 {{ range . }}
+				<li>{{ .Host }}
+					<ul>
+					{{ range .URLs }}<li><a href="#{{ .Path }}">{{ . }}</a></li>{{ end }}
+					</ul>
+				</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .DuplicateTitles }}
+		<div>
+		 <h2>Duplicate titles</h2>
+		 {{ range . }}
+				<li>{{ .Title }}
+					<ul>
+					{{ range .URLs }}<li><a href="#{{ .Path }}">{{ . }}</a></li>{{ end }}
+					</ul>
+				</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .NearDuplicates }}
+		<div>
+		 <h2>Near duplicates</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a>
+					<ul>
+					{{ range .Duplicates }}<li><a href="#{{ .Path }}">{{ . }}</a></li>{{ end }}
+					</ul>
+				</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .MissingDescriptions }}
+		<div>
+		 <h2>Missing descriptions</h2>
+		 {{ range . }}
+				<li><a href="#{{ .Path }}">{{ . }}</a></li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .CanonicalMismatches }}
+		<div>
+		 <h2>Canonicalised elsewhere</h2>
+		 {{ range . }}
+				<li><a href="#{{ .URL.Path }}">{{ .URL }}</a> &rarr; {{ .Canonical }}</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .Orphans }}
+		<div>
+		 <h2>Orphan pages</h2>
+		 {{ range . }}
+				<li><a href="#{{ .Path }}">{{ . }}</a></li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .Redirected }}
+		<div>
+		 <h2>Redirect chains</h2>
+		 {{ range . }}
+				<li>{{ range $i, $hop := .RedirectChain }}{{ if $i }} &rarr; {{ end }}{{ $hop.URL }} ({{ $hop.StatusCode }}){{ end }}</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .RedirectLoops }}
+		<div>
+		 <h2>Redirect loops</h2>
+		 {{ range . }}
+				<li>{{ .URL }}: {{ range $i, $uri := .RedirectChain }}{{ if $i }} &rarr; {{ end }}{{ $uri }}{{ end }}</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .UnreachableHosts }}
+		<div>
+		 <h2>Unreachable hosts</h2>
+		 {{ range . }}
+				<li>{{ .Host }}
+					<ul>
+					{{ range .URLs }}<li>{{ . }}</li>{{ end }}
+					</ul>
+				</li>
+		 {{ end }}
+		</div>
+	{{ end }}
+	{{ with .ExternalAssetHosts }}
+		<div>
+		 <h2>Third-party asset hosts</h2>
+		 <table>
+			<tr><th>Host</th><th>Pages</th><th>Assets</th></tr>
+			{{ range . }}
+				<tr><td>{{ .Host }}</td><td>{{ .Pages }}</td><td>{{ .Assets }}</td></tr>
+			{{ end }}
+		 </table>
+		</div>
+	{{ end }}
+	{{ with .AssetKinds }}
+		<div>
+		 <h2>Assets by type</h2>
+		 <table>
+			{{ range . }}
+				<tr><td>{{ .Kind }}</td><td>{{ .Count }}</td></tr>
+			{{ end }}
+		 </table>
+		</div>
+	{{ end }}
+	{{ with .Sizes }}
+		<div>
+		 <h2>Page sizes</h2>
+		 <table>
+			{{ range . }}
+				<tr><td>{{ .Label }}</td><td>{{ .Count }}</td></tr>
+			{{ end }}
+		 </table>
+		</div>
+	{{ end }}
+	{{ with .Caching }}
+		<div>
+		 <h2>Caching</h2>
+		 <table>
+			<tr><th>Page</th><th>Cache-Control</th><th>ETag</th><th>Expires</th></tr>
+			{{ range . }}
+				<tr>
+					<td><a href="#{{ .URL.Path }}">{{ .URL }}</a></td>
+					<td>{{ or .Cache.CacheControl "missing" }}</td>
+					<td>{{ or .Cache.ETag "missing" }}</td>
+					<td>{{ or .Cache.Expires "missing" }}</td>
+				</tr>
+			{{ end }}
+		 </table>
+		</div>
+	{{ end }}
+	{{ with .Depths }}
+		<div>
+		 <h2>Depth</h2>
+		 <table>
+			<tr><th>Page</th><th>Depth</th></tr>
+			{{ range . }}
+				<tr><td><a href="#{{ .URL.Path }}">{{ .URL }}</a></td><td>{{ .Depth }}</td></tr>
+			{{ end }}
+		 </table>
+		</div>
+	{{ end }}
+	{{ if .Groups }}
+		{{ range .Groups }}
+			<details>
+			 <summary>{{ .Name }} ({{ len .Pages }})</summary>
+			 {{ range .Pages }}{{ template "page" . }}{{ end }}
+			</details>
+		{{ end }}
+	{{ else }}
+		{{ range .Pages }}{{ template "page" . }}{{ end }}
+	{{ end }}
+</body>
+</html>
+{{ define "page" }}
+		<div>
+		 <h2><div id="{{ .URL.Path }}">Page {{ with .Method }}{{ . }} {{ end }}{{ .URL }}</div></h2>
 		 <h4>Has assets:</h4>
-		 {{ range $value.Assets }}
+		 {{ range .Assets }}
 				<li>{{ . }}</li>
 		 {{ end }}
 		 <h4>Links to:</h4>
-		 {{ range $value.Links }}
+		 {{ range .Links }}
 		 		<li><a href="#{{ .Path }}">{{ . }}</a></li>
 		 {{ end }}
 	 </div>
-	{{ end }}
-</body>
-</html>
+{{ end }}
 `
 
-type pageContent struct {
-	Links  []*url.URL
-	Assets []string
+// slowestToParseLimit is how many of the slowest to parse pages are listed.
+const slowestToParseLimit = 10
+
+// sizeBucket counts the pages whose size is below max, and not in any smaller bucket.
+type sizeBucket struct {
+	Label string
+	Max   int64
+	Count int
+}
+
+// newSizeBuckets creates the buckets for the page size histogram. The last bucket has no max.
+func newSizeBuckets() []sizeBucket {
+	return []sizeBucket{
+		{Label: "< 10KB", Max: 10 << 10},
+		{Label: "10KB - 100KB", Max: 100 << 10},
+		{Label: "100KB - 1MB", Max: 1 << 20},
+		{Label: "> 1MB"},
+	}
+}
+
+// addToSizeBuckets counts the size in the first bucket it fits.
+func addToSizeBuckets(buckets []sizeBucket, size int64) {
+	for i := range buckets {
+		if buckets[i].Max == 0 || size < buckets[i].Max {
+			buckets[i].Count++
+			return
+		}
+	}
+}
+
+// assetKindCount is the number of distinct assets of one kind.
+type assetKindCount struct {
+	Kind  string
+	Count int
+}
+
+// countAssetKinds counts the distinct assets of each kind across the pages. An asset is counted
+// as the kind it was first classified as.
+func countAssetKinds(sitemap map[string]PageInfo) []assetKindCount {
+	seen := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, key := range sortedKeys(sitemap) {
+		for asset, kind := range sitemap[key].AssetKinds {
+			if seen[asset] {
+				continue
+			}
+			seen[asset] = true
+			counts[kind]++
+		}
+	}
+	var kinds []assetKindCount
+	for kind, count := range counts {
+		kinds = append(kinds, assetKindCount{Kind: kind, Count: count})
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i].Kind < kinds[j].Kind
+	})
+	return kinds
+}
+
+// pageGroup is the pages under one top level directory.
+type pageGroup struct {
+	Name  string
+	Pages []PageInfo
+}
+
+// directoryGroup returns the name of the group for a path, e.g. "/blog/*" for "/blog/post".
+// Pages which aren't in a directory are in the "/" group.
+func directoryGroup(path string) string {
+	trimmed := strings.TrimPrefix(path, "/")
+	i := strings.Index(trimmed, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return "/" + trimmed[:i] + "/*"
+}
+
+// unreachableHost is a host which couldn't be resolved, and the pages on it which were linked to.
+type unreachableHost struct {
+	Host string
+	URLs []*url.URL
+}
+
+// thirdPartyHost is a host which pages hint they'll connect to, and the pages which do.
+type thirdPartyHost struct {
+	Host string
+	URLs []*url.URL
+}
+
+// nearDuplicateCluster is a page and the pages found later whose text is almost the same.
+type nearDuplicateCluster struct {
+	URL        *url.URL
+	Duplicates []*url.URL
+}
+
+// assetHost is a host other than the root's which serves assets, with the number of pages which
+// load any of them and the number of distinct assets.
+type assetHost struct {
+	Host   string
+	Pages  int
+	Assets int
+}
+
+// duplicateTitle is a title shared by more than one page.
+type duplicateTitle struct {
+	Title string
+	URLs  []*url.URL
+}
+
+// htmlReport is the data passed to the sitemap template.
+type htmlReport struct {
+	Metadata      map[string]string
+	Resources     []Resource
+	Pages         map[string]PageInfo
+	Soft404s      []*url.URL
+	Slow          []PageInfo
+	MixedContent  []PageInfo
+	Orphans       []*url.URL
+	RedirectLoops []Failure
+	// UnreachableHosts are sorted by host.
+	UnreachableHosts []unreachableHost
+	// DuplicateTitles are sorted by title. Missing titles aren't counted as duplicates.
+	DuplicateTitles     []duplicateTitle
+	MissingDescriptions []*url.URL
+	// CanonicalMismatches are the pages whose canonical URL isn't their own.
+	CanonicalMismatches []PageInfo
+	// SlowestToParse are the parsed pages which took longest to parse, slowest first.
+	SlowestToParse []PageInfo
+	// AssetKinds count the distinct assets across the site of each kind, sorted by kind.
+	AssetKinds []assetKindCount
+	Sizes      []sizeBucket
+	// Caching lists every page in order, so missing cache headers stand out.
+	Caching []PageInfo
+	// Groups is set instead of listing pages flat when grouping by directory.
+	Groups []pageGroup
+	// Truncated is true if any page's links and assets were truncated.
+	Truncated bool
+	// Depths lists every page by depth, shallowest first, if depth is shown.
+	Depths []PageInfo
+	// ThirdPartyHosts are sorted by host.
+	ThirdPartyHosts []thirdPartyHost
+	// ManyLinks are the pages with more links than the threshold, most first.
+	ManyLinks []PageInfo
+	// NearDuplicates are sorted by the first page's URL.
+	NearDuplicates []nearDuplicateCluster
+	// ExternalAssetHosts are sorted by the number of pages which depend on them, most first.
+	ExternalAssetHosts []assetHost
+	// Redirected are the pages which were redirected, with their chains.
+	Redirected []PageInfo
+	// Thin are the pages with less text than the minimum content length, least first.
+	Thin []PageInfo
 }
 
 // HTML is a reporter that can output a html sitemap.
 type HTML struct {
-	sitemap  map[*url.URL]pageContent
-	template *template.Template
+	sitemap   map[string]PageInfo
+	resources []Resource
+	failures  []Failure
+	metadata  map[string]string
+	grouped   bool
+	showDepth bool
+	// assetHostRoot is set to show the other hosts which serve assets.
+	assetHostRoot *url.URL
+	template      *template.Template
 	sync.Mutex
 }
 
 // NewHTML creates a new HTML reporter.
 func NewHTML() *HTML {
 	return &HTML{
-		sitemap:  make(map[*url.URL]pageContent),
+		sitemap:  make(map[string]PageInfo),
 		template: template.Must(template.New("sitemap").Parse(sitemapHTML)),
 	}
 }
 
-// Add links and assets to a URI.
-func (r *HTML) Add(uri *url.URL, links []*url.URL, assets []string) {
+// Add a page to the sitemap. Pages which have already been added are ignored.
+func (r *HTML) Add(page PageInfo) {
 	r.Lock()
 	defer r.Unlock()
-	_, ok := r.sitemap[uri]
+	key := page.key()
+	_, ok := r.sitemap[key]
 	if ok {
 		return
 	}
-	r.sitemap[uri] = pageContent{
-		Links:  links,
-		Assets: assets,
-	}
+	r.sitemap[key] = page
+}
+
+// AddResource records a site wide file the spider consulted.
+func (r *HTML) AddResource(resource Resource) {
+	r.Lock()
+	defer r.Unlock()
+	r.resources = append(r.resources, resource)
+}
+
+// AddFailure records a page which couldn't be crawled.
+func (r *HTML) AddFailure(failure Failure) {
+	r.Lock()
+	defer r.Unlock()
+	r.failures = append(r.failures, failure)
+}
+
+// SetMetadata sets the metadata shown at the top of the report. A "title" entry is also used
+// as the page title.
+func (r *HTML) SetMetadata(metadata map[string]string) {
+	r.Lock()
+	defer r.Unlock()
+	r.metadata = metadata
+}
+
+// SetGroupByDirectory groups pages by their top level directory, such as /blog/*, in
+// collapsible sections. This makes reports for large sites easier to read.
+func (r *HTML) SetGroupByDirectory(group bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.grouped = group
+}
+
+// SetShowDepth lists every page with how many links away from the root it was found.
+func (r *HTML) SetShowDepth(show bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.showDepth = show
+}
+
+// SetExternalAssetHosts shows the hosts other than the root's which serve assets, and how many
+// pages depend on each.
+func (r *HTML) SetExternalAssetHosts(root *url.URL) {
+	r.Lock()
+	defer r.Unlock()
+	r.assetHostRoot = root
+}
+
+// ResultSet returns the pages added so far.
+func (r *HTML) ResultSet() ResultSet {
+	r.Lock()
+	defer r.Unlock()
+	set := newResultSet(r.sitemap)
+	set.Metadata = r.metadata
+	return set
 }
 
 // Report writes HTML to the given writer.
 func (r *HTML) Report(w io.Writer) error {
 	r.Lock()
 	defer r.Unlock()
-	return r.template.Execute(w, r.sitemap)
+	return r.template.Execute(w, r.build())
+}
+
+// build collects the sitemap into sections for the template.
+func (r *HTML) build() htmlReport {
+	report := htmlReport{
+		Metadata:  r.metadata,
+		Resources: r.resources,
+		Pages:     r.sitemap,
+	}
+	if len(r.sitemap) > 0 {
+		report.Sizes = newSizeBuckets()
+	}
+	unreachable := make(map[string][]*url.URL)
+	for _, failure := range r.failures {
+		switch failure.Category {
+		case FailureRedirectLoop:
+			report.RedirectLoops = append(report.RedirectLoops, failure)
+		case FailureUnreachableHost:
+			host := failure.URL.Hostname()
+			unreachable[host] = append(unreachable[host], failure.URL)
+		}
+	}
+	for host, urls := range unreachable {
+		report.UnreachableHosts = append(report.UnreachableHosts, unreachableHost{Host: host, URLs: urls})
+	}
+	sort.Slice(report.UnreachableHosts, func(i, j int) bool {
+		return report.UnreachableHosts[i].Host < report.UnreachableHosts[j].Host
+	})
+	linked := make(map[string]bool)
+	for _, page := range r.sitemap {
+		if page.IsSitemap {
+			continue
+		}
+		for _, link := range page.Links {
+			linked[link.String()] = true
+		}
+	}
+	if r.grouped {
+		report.Groups = groupByDirectory(r.sitemap)
+	}
+	report.AssetKinds = countAssetKinds(r.sitemap)
+	if r.assetHostRoot != nil {
+		report.ExternalAssetHosts = countExternalAssetHosts(r.sitemap, r.assetHostRoot)
+	}
+	titles := make(map[string][]*url.URL)
+	hosts := make(map[string][]*url.URL)
+	nearDuplicates := make(map[string]*nearDuplicateCluster)
+	for _, key := range sortedKeys(r.sitemap) {
+		page := r.sitemap[key]
+		if page.NearDuplicateOf != nil {
+			first := page.NearDuplicateOf.String()
+			if nearDuplicates[first] == nil {
+				nearDuplicates[first] = &nearDuplicateCluster{URL: page.NearDuplicateOf}
+			}
+			nearDuplicates[first].Duplicates = append(nearDuplicates[first].Duplicates, page.URL)
+		}
+		for _, host := range page.ThirdPartyHosts {
+			hosts[host] = append(hosts[host], page.URL)
+		}
+		if page.HTML && page.Title != "" {
+			titles[page.Title] = append(titles[page.Title], page.URL)
+		}
+		if page.HTML && page.Description == "" {
+			report.MissingDescriptions = append(report.MissingDescriptions, page.URL)
+		}
+		if page.Canonical != nil && !sameURL(page.URL, page.Canonical) {
+			report.CanonicalMismatches = append(report.CanonicalMismatches, page)
+		}
+		report.Caching = append(report.Caching, page)
+		if r.showDepth {
+			report.Depths = append(report.Depths, page)
+		}
+		if page.ResultsTruncated {
+			report.Truncated = true
+		}
+		addToSizeBuckets(report.Sizes, page.Size)
+		if page.Soft404 {
+			report.Soft404s = append(report.Soft404s, page.URL)
+		}
+		if page.Slow {
+			report.Slow = append(report.Slow, page)
+		}
+		if page.ManyLinks {
+			report.ManyLinks = append(report.ManyLinks, page)
+		}
+		if page.Thin {
+			report.Thin = append(report.Thin, page)
+		}
+		if len(page.RedirectChain) > 0 {
+			report.Redirected = append(report.Redirected, page)
+		}
+		if page.ParseDuration > 0 {
+			report.SlowestToParse = append(report.SlowestToParse, page)
+		}
+		if len(page.MixedContent) > 0 {
+			report.MixedContent = append(report.MixedContent, page)
+		}
+		// Pages from the sitemap which no crawled page links to are orphans. The root and seeds
+		// are where the crawl starts, so nothing needs to link to them.
+		if page.FromSitemap && page.Depth > 0 && !linked[page.URL.String()] {
+			report.Orphans = append(report.Orphans, page.URL)
+		}
+	}
+	for _, cluster := range nearDuplicates {
+		report.NearDuplicates = append(report.NearDuplicates, *cluster)
+	}
+	sort.Slice(report.NearDuplicates, func(i, j int) bool {
+		return report.NearDuplicates[i].URL.String() < report.NearDuplicates[j].URL.String()
+	})
+	for host, urls := range hosts {
+		report.ThirdPartyHosts = append(report.ThirdPartyHosts, thirdPartyHost{Host: host, URLs: urls})
+	}
+	sort.Slice(report.ThirdPartyHosts, func(i, j int) bool {
+		return report.ThirdPartyHosts[i].Host < report.ThirdPartyHosts[j].Host
+	})
+	for title, urls := range titles {
+		if len(urls) > 1 {
+			report.DuplicateTitles = append(report.DuplicateTitles, duplicateTitle{Title: title, URLs: urls})
+		}
+	}
+	sort.Slice(report.DuplicateTitles, func(i, j int) bool {
+		return report.DuplicateTitles[i].Title < report.DuplicateTitles[j].Title
+	})
+	// Pages are already sorted by URL, so keep that order for equal durations.
+	sort.SliceStable(report.SlowestToParse, func(i, j int) bool {
+		return report.SlowestToParse[i].ParseDuration > report.SlowestToParse[j].ParseDuration
+	})
+	if len(report.SlowestToParse) > slowestToParseLimit {
+		report.SlowestToParse = report.SlowestToParse[:slowestToParseLimit]
+	}
+	sort.SliceStable(report.ManyLinks, func(i, j int) bool {
+		return report.ManyLinks[i].LinkCount > report.ManyLinks[j].LinkCount
+	})
+	sort.SliceStable(report.Thin, func(i, j int) bool {
+		return report.Thin[i].TextLength < report.Thin[j].TextLength
+	})
+	sort.SliceStable(report.Depths, func(i, j int) bool {
+		return report.Depths[i].Depth < report.Depths[j].Depth
+	})
+	return report
+}
+
+// sameURL returns true if the URLs point at the same page, ignoring the case of the scheme and
+// host, fragments, and whether an empty path is written as "/".
+func sameURL(a *url.URL, b *url.URL) bool {
+	normalize := func(uri *url.URL) url.URL {
+		n := *uri
+		n.Scheme = strings.ToLower(n.Scheme)
+		n.Host = strings.ToLower(n.Host)
+		n.Fragment = ""
+		if n.Path == "" {
+			n.Path = "/"
+		}
+		return n
+	}
+	x, y := normalize(a), normalize(b)
+	return x.String() == y.String()
+}
+
+// countExternalAssetHosts counts the pages which load assets from each host other than the
+// root's, and the distinct assets each serves.
+func countExternalAssetHosts(sitemap map[string]PageInfo, root *url.URL) []assetHost {
+	pages := make(map[string]int)
+	assets := make(map[string]map[string]bool)
+	for _, page := range sitemap {
+		onPage := make(map[string]bool)
+		for _, asset := range page.Assets {
+			uri, err := url.Parse(asset)
+			if err != nil {
+				continue
+			}
+			uri = page.URL.ResolveReference(uri)
+			host := strings.ToLower(uri.Hostname())
+			if host == "" || host == strings.ToLower(root.Hostname()) {
+				continue
+			}
+			if !onPage[host] {
+				onPage[host] = true
+				pages[host]++
+			}
+			if assets[host] == nil {
+				assets[host] = make(map[string]bool)
+			}
+			assets[host][uri.String()] = true
+		}
+	}
+	hosts := make([]assetHost, 0, len(pages))
+	for host, count := range pages {
+		hosts = append(hosts, assetHost{Host: host, Pages: count, Assets: len(assets[host])})
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Pages != hosts[j].Pages {
+			return hosts[i].Pages > hosts[j].Pages
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+	return hosts
+}
+
+// groupByDirectory groups the pages by their top level directory. Groups and the pages in them
+// are sorted.
+func groupByDirectory(sitemap map[string]PageInfo) []pageGroup {
+	var groups []pageGroup
+	index := make(map[string]int)
+	for _, key := range sortedKeys(sitemap) {
+		page := sitemap[key]
+		name := directoryGroup(page.URL.Path)
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, pageGroup{Name: name})
+		}
+		groups[i].Pages = append(groups[i].Pages, page)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups
+}
+
+// sortedKeys returns the keys of the sitemap in order, so reports are deterministic.
+func sortedKeys(sitemap map[string]PageInfo) []string {
+	keys := make([]string, 0, len(sitemap))
+	for key := range sitemap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
 }
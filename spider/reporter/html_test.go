@@ -2,8 +2,10 @@ package reporter
 
 import (
 	"bytes"
+	"fmt"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -20,11 +22,600 @@ func TestReportHTML(t *testing.T) {
 	require.NoError(t, err)
 
 	r := NewHTML()
-	r.Add(root, []*url.URL{page1, page2}, []string{"foo.img"})
-	r.Add(page1, []*url.URL{page2}, []string{})
-	r.Add(page2, []*url.URL{}, []string{"bar.img"})
+	r.Add(PageInfo{URL: root, Links: []*url.URL{page1, page2}, Assets: []string{"foo.img"}})
+	r.Add(PageInfo{URL: page1, Links: []*url.URL{page2}, Assets: []string{}})
+	r.Add(PageInfo{URL: page2, Links: []*url.URL{}, Assets: []string{"bar.img"}})
 
 	buf := bytes.NewBuffer(nil)
 	err = r.Report(buf)
 	assert.NoError(t, err)
+	assert.NotContains(t, buf.String(), "Soft 404s")
+}
+
+func TestReportHTMLFormPostedToSamePage(t *testing.T) {
+	contact, err := url.Parse("http://willdemaine.co.uk/contact")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: contact})
+	// A form without an action posts back to the page it's on.
+	r.Add(PageInfo{URL: contact, Method: "POST", Body: url.Values{"name": {"foo"}}})
+	r.Add(PageInfo{URL: contact, Method: "POST", Body: url.Values{"name": {"bar"}}})
+	r.Add(PageInfo{URL: contact, Method: "POST", Body: url.Values{"name": {"foo"}}})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+	assert.Len(t, r.sitemap, 3)
+	assert.Contains(t, buf.String(), "Page http://willdemaine.co.uk/contact<")
+	assert.Contains(t, buf.String(), "Page POST http://willdemaine.co.uk/contact<")
+
+	set := r.ResultSet()
+	require.Len(t, set.Pages, 3)
+	assert.Equal(t, "", set.Pages[2].Method)
+	assert.Equal(t, "POST", set.Pages[0].Method)
+	assert.Equal(t, contact.String(), set.Pages[0].URL)
+}
+
+func TestReportHTMLSoft404(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	missing, err := url.Parse("http://willdemaine.co.uk/missing")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, Links: []*url.URL{missing}})
+	r.Add(PageInfo{URL: missing, Soft404: true})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	assert.Equal(t, []*url.URL{missing}, report.Soft404s)
+	assert.Contains(t, buf.String(), "Soft 404s")
+}
+
+func TestReportHTMLSlow(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	slow, err := url.Parse("http://willdemaine.co.uk/slow")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, Latency: time.Millisecond})
+	r.Add(PageInfo{URL: slow, Latency: time.Second * 3, Slow: true})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	require.Len(t, report.Slow, 1)
+	assert.Equal(t, slow, report.Slow[0].URL)
+	assert.Contains(t, buf.String(), "Slow pages")
+	assert.Contains(t, buf.String(), "3s")
+}
+
+func TestReportHTMLSlowestToParse(t *testing.T) {
+	r := NewHTML()
+	for i := 0; i < slowestToParseLimit+2; i++ {
+		page, err := url.Parse(fmt.Sprintf("http://willdemaine.co.uk/page%d", i))
+		require.NoError(t, err)
+		r.Add(PageInfo{URL: page, HTML: true, ParseDuration: time.Duration(i+1) * time.Millisecond})
+	}
+	unparsed, err := url.Parse("http://willdemaine.co.uk/logo.png")
+	require.NoError(t, err)
+	r.Add(PageInfo{URL: unparsed})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	require.Len(t, report.SlowestToParse, slowestToParseLimit)
+	assert.Equal(t, "/page11", report.SlowestToParse[0].URL.Path)
+	assert.Equal(t, "/page2", report.SlowestToParse[slowestToParseLimit-1].URL.Path)
+	assert.Contains(t, buf.String(), "Slowest to parse")
+	assert.Contains(t, buf.String(), "12ms")
+	assert.NotContains(t, buf.String(), "logo.png (")
+}
+
+func TestReportHTMLTruncated(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root})
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.NotContains(t, buf.String(), "limit on stored links")
+	assert.False(t, r.ResultSet().Truncated)
+
+	r.Add(PageInfo{URL: root.ResolveReference(&url.URL{Path: "/about"}), ResultsTruncated: true})
+	buf.Reset()
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "limit on stored links")
+	assert.True(t, r.ResultSet().Truncated)
+}
+
+func TestReportHTMLDepth(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+	team, err := url.Parse("http://willdemaine.co.uk/about/team")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: team, Depth: 2})
+	r.Add(PageInfo{URL: root})
+	r.Add(PageInfo{URL: about, Depth: 1})
+	assert.Empty(t, r.build().Depths)
+
+	r.SetShowDepth(true)
+	report := r.build()
+	require.Len(t, report.Depths, 3)
+	assert.Equal(t, []*url.URL{root, about, team}, []*url.URL{report.Depths[0].URL, report.Depths[1].URL, report.Depths[2].URL})
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), `<td><a href="#%2fabout%2fteam">http://willdemaine.co.uk/about/team</a></td><td>2</td>`)
+}
+
+func TestReportHTMLCanonicalMismatches(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	rootSlash, err := url.Parse("HTTP://WillDemaine.co.uk/#top")
+	require.NoError(t, err)
+	page2, err := url.Parse("http://willdemaine.co.uk/posts/page/2/")
+	require.NoError(t, err)
+	posts, err := url.Parse("http://willdemaine.co.uk/posts/")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, Canonical: rootSlash})
+	r.Add(PageInfo{URL: page2, Canonical: posts})
+	r.Add(PageInfo{URL: about})
+
+	report := r.build()
+	require.Len(t, report.CanonicalMismatches, 1)
+	assert.Equal(t, page2, report.CanonicalMismatches[0].URL)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "Canonicalised elsewhere")
+	assert.Contains(t, buf.String(), `http://willdemaine.co.uk/posts/page/2/</a> &rarr; http://willdemaine.co.uk/posts/</li>`)
+}
+
+func TestReportHTMLThirdPartyHosts(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, ThirdPartyHosts: []string{"cdn.example.com", "fonts.gstatic.com"}})
+	r.Add(PageInfo{URL: about, ThirdPartyHosts: []string{"fonts.gstatic.com"}})
+
+	report := r.build()
+	assert.Equal(t, []thirdPartyHost{
+		{Host: "cdn.example.com", URLs: []*url.URL{root}},
+		{Host: "fonts.gstatic.com", URLs: []*url.URL{root, about}},
+	}, report.ThirdPartyHosts)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "Third-party hosts")
+	assert.Contains(t, buf.String(), "<li>fonts.gstatic.com")
+}
+
+func TestReportHTMLManyLinks(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+	tags, err := url.Parse("http://willdemaine.co.uk/tags")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, LinkCount: 150, ManyLinks: true})
+	r.Add(PageInfo{URL: about, LinkCount: 10})
+	r.Add(PageInfo{URL: tags, LinkCount: 400, ManyLinks: true})
+
+	report := r.build()
+	require.Len(t, report.ManyLinks, 2)
+	assert.Equal(t, tags, report.ManyLinks[0].URL)
+	assert.Equal(t, root, report.ManyLinks[1].URL)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "Pages with many links")
+	assert.Contains(t, buf.String(), `http://willdemaine.co.uk/tags</a> (400 links)`)
+	assert.NotContains(t, buf.String(), `http://willdemaine.co.uk/about</a> (`)
+}
+
+func TestReportHTMLNearDuplicates(t *testing.T) {
+	post, err := url.Parse("http://willdemaine.co.uk/post")
+	require.NoError(t, err)
+	amp, err := url.Parse("http://willdemaine.co.uk/post/amp")
+	require.NoError(t, err)
+	print, err := url.Parse("http://willdemaine.co.uk/post/print")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: post})
+	r.Add(PageInfo{URL: print, NearDuplicateOf: post})
+	r.Add(PageInfo{URL: amp, NearDuplicateOf: post})
+	r.Add(PageInfo{URL: about})
+
+	report := r.build()
+	assert.Equal(t, []nearDuplicateCluster{{URL: post, Duplicates: []*url.URL{amp, print}}}, report.NearDuplicates)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "Near duplicates")
+	assert.Contains(t, buf.String(), `<li><a href="#%2fpost%2famp">http://willdemaine.co.uk/post/amp</a></li>`)
+}
+
+func TestReportHTMLExternalAssetHosts(t *testing.T) {
+	root, err := url.Parse("https://willdemaine.co.uk")
+	require.NoError(t, err)
+	about, err := url.Parse("https://willdemaine.co.uk/about")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, Assets: []string{
+		"/main.css",
+		"https://cdn.jsdelivr.net/a.js",
+		"https://cdn.jsdelivr.net/b.js",
+		"//fonts.googleapis.com/css",
+	}})
+	r.Add(PageInfo{URL: about, Assets: []string{
+		"https://WillDemaine.co.uk/me.jpg",
+		"https://cdn.jsdelivr.net/a.js",
+	}})
+	assert.Empty(t, r.build().ExternalAssetHosts)
+
+	r.SetExternalAssetHosts(root)
+	assert.Equal(t, []assetHost{
+		{Host: "cdn.jsdelivr.net", Pages: 2, Assets: 2},
+		{Host: "fonts.googleapis.com", Pages: 1, Assets: 1},
+	}, r.build().ExternalAssetHosts)
+}
+
+func TestReportHTMLRedirectChains(t *testing.T) {
+	old, err := url.Parse("http://willdemaine.co.uk/old")
+	require.NoError(t, err)
+	final, err := url.Parse("https://willdemaine.co.uk/new")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: old, RedirectChain: []RedirectHop{{URL: old, StatusCode: 301}, {URL: final, StatusCode: 200}}})
+	r.Add(PageInfo{URL: about})
+
+	report := r.build()
+	require.Len(t, report.Redirected, 1)
+	assert.Equal(t, old, report.Redirected[0].URL)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "<li>http://willdemaine.co.uk/old (301) &rarr; https://willdemaine.co.uk/new (200)</li>")
+}
+
+func TestReportHTMLThinPages(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+	soon, err := url.Parse("http://willdemaine.co.uk/soon")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, TextLength: 150, Thin: true})
+	r.Add(PageInfo{URL: about, TextLength: 2000})
+	r.Add(PageInfo{URL: soon, TextLength: 12, Thin: true})
+
+	report := r.build()
+	require.Len(t, report.Thin, 2)
+	assert.Equal(t, soon, report.Thin[0].URL)
+	assert.Equal(t, root, report.Thin[1].URL)
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, r.Report(buf))
+	assert.Contains(t, buf.String(), "Thin pages")
+	assert.Contains(t, buf.String(), `http://willdemaine.co.uk/soon</a> (12 bytes of text)`)
+	assert.NotContains(t, buf.String(), `http://willdemaine.co.uk/about</a> (`)
+}
+
+func TestReportHTMLAssetKinds(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	about, err := url.Parse("http://willdemaine.co.uk/about")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{
+		URL:        root,
+		Assets:     []string{"/main.css", "/logo.png", "/app.js"},
+		AssetKinds: map[string]string{"/main.css": "stylesheet", "/logo.png": "image", "/app.js": "script"},
+	})
+	r.Add(PageInfo{
+		URL:        about,
+		Assets:     []string{"/main.css", "/me.jpg"},
+		AssetKinds: map[string]string{"/main.css": "stylesheet", "/me.jpg": "image"},
+	})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	assert.Equal(t, []assetKindCount{
+		{Kind: "image", Count: 2},
+		{Kind: "script", Count: 1},
+		{Kind: "stylesheet", Count: 1},
+	}, report.AssetKinds)
+	assert.Contains(t, buf.String(), "Assets by type")
+}
+
+func TestReportHTMLMixedContent(t *testing.T) {
+	secure, err := url.Parse("https://willdemaine.co.uk/secure")
+	require.NoError(t, err)
+
+	mixed, err := url.Parse("https://willdemaine.co.uk/mixed")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: secure, Assets: []string{"/main.css"}})
+	r.Add(PageInfo{URL: mixed, Assets: []string{"http://cdn.example.com/a.png"}, MixedContent: []string{"http://cdn.example.com/a.png"}})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	require.Len(t, report.MixedContent, 1)
+	assert.Equal(t, mixed, report.MixedContent[0].URL)
+	assert.Contains(t, buf.String(), "Mixed content")
+	assert.Contains(t, buf.String(), "loads http://cdn.example.com/a.png")
+}
+
+func TestReportHTMLOrphans(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	linked, err := url.Parse("http://willdemaine.co.uk/linked")
+	require.NoError(t, err)
+
+	orphan, err := url.Parse("http://willdemaine.co.uk/orphan")
+	require.NoError(t, err)
+
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	// Nothing links to the root, but it's where the crawl starts so it isn't an orphan.
+	r.Add(PageInfo{URL: root, Links: []*url.URL{linked}, FromSitemap: true})
+	r.Add(PageInfo{URL: linked, FromSitemap: true, Depth: 1})
+	r.Add(PageInfo{URL: orphan, FromSitemap: true, Depth: 1})
+	// Being listed in the sitemap doesn't stop a page being an orphan.
+	r.Add(PageInfo{URL: sitemap, Links: []*url.URL{linked, orphan}, IsSitemap: true})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	assert.Equal(t, []*url.URL{orphan}, report.Orphans)
+	assert.Contains(t, buf.String(), "Orphan pages")
+}
+
+func TestReportHTMLTitlesAndDescriptions(t *testing.T) {
+	var urls []*url.URL
+	for _, path := range []string{"/a", "/b", "/c", "/d", "/e.pdf"} {
+		uri, err := url.Parse("http://willdemaine.co.uk" + path)
+		require.NoError(t, err)
+		urls = append(urls, uri)
+	}
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: urls[0], HTML: true, Title: "Home", Description: "Home page"})
+	r.Add(PageInfo{URL: urls[1], HTML: true, Title: "Home"})
+	r.Add(PageInfo{URL: urls[2], HTML: true, Title: "Contact", Description: "Get in touch"})
+	r.Add(PageInfo{URL: urls[3], HTML: true, Description: "No title"})
+	r.Add(PageInfo{URL: urls[4]})
+
+	buf := bytes.NewBuffer(nil)
+	err := r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	assert.Equal(t, []duplicateTitle{{Title: "Home", URLs: []*url.URL{urls[0], urls[1]}}}, report.DuplicateTitles)
+	assert.Equal(t, []*url.URL{urls[1]}, report.MissingDescriptions)
+	assert.Contains(t, buf.String(), "Duplicate titles")
+	assert.Contains(t, buf.String(), "Missing descriptions")
+}
+
+func TestReportHTMLResources(t *testing.T) {
+	robots, err := url.Parse("http://willdemaine.co.uk/robots.txt")
+	require.NoError(t, err)
+
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	assert.Implements(t, (*ResourceReporter)(nil), r)
+	r.AddResource(Resource{URL: robots, StatusCode: 200})
+	r.AddResource(Resource{URL: sitemap, Error: "timeout"})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "<li>http://willdemaine.co.uk/robots.txt: 200</li>")
+	assert.Contains(t, buf.String(), "<li>http://willdemaine.co.uk/sitemap.xml: timeout</li>")
+}
+
+func TestReportHTMLCaching(t *testing.T) {
+	root, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	uncached, err := url.Parse("http://willdemaine.co.uk/uncached")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.Add(PageInfo{URL: root, Cache: CacheHeaders{CacheControl: "max-age=60", ETag: `"abc"`}})
+	r.Add(PageInfo{URL: uncached})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	require.Len(t, report.Caching, 2)
+	assert.Equal(t, root, report.Caching[0].URL)
+	assert.Contains(t, buf.String(), "Caching")
+	assert.Contains(t, buf.String(), "<td>max-age=60</td>")
+	assert.Contains(t, buf.String(), "<td>missing</td>")
+}
+
+func TestReportHTMLRedirectLoops(t *testing.T) {
+	a, err := url.Parse("http://willdemaine.co.uk/a")
+	require.NoError(t, err)
+
+	b, err := url.Parse("http://willdemaine.co.uk/b")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	assert.Implements(t, (*FailureReporter)(nil), r)
+	r.AddFailure(Failure{URL: a, Category: FailureRedirectLoop, RedirectChain: []*url.URL{a, b, a}})
+	r.AddFailure(Failure{URL: b, Category: "other"})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	require.Len(t, report.RedirectLoops, 1)
+	assert.Equal(t, a, report.RedirectLoops[0].URL)
+	assert.Contains(t, buf.String(), "Redirect loops")
+	assert.Contains(t, buf.String(), "http://willdemaine.co.uk/a &rarr; http://willdemaine.co.uk/b &rarr; http://willdemaine.co.uk/a")
+}
+
+func TestReportHTMLUnreachableHosts(t *testing.T) {
+	a, err := url.Parse("http://shop.willdemaine.co.uk/a")
+	require.NoError(t, err)
+
+	b, err := url.Parse("http://shop.willdemaine.co.uk/b")
+	require.NoError(t, err)
+
+	c, err := url.Parse("http://old.willdemaine.co.uk/c")
+	require.NoError(t, err)
+
+	r := NewHTML()
+	r.AddFailure(Failure{URL: a, Category: FailureUnreachableHost})
+	r.AddFailure(Failure{URL: c, Category: FailureUnreachableHost})
+	r.AddFailure(Failure{URL: b, Category: FailureUnreachableHost})
+
+	buf := bytes.NewBuffer(nil)
+	err = r.Report(buf)
+	assert.NoError(t, err)
+
+	report := r.build()
+	assert.Equal(t, []unreachableHost{
+		{Host: "old.willdemaine.co.uk", URLs: []*url.URL{c}},
+		{Host: "shop.willdemaine.co.uk", URLs: []*url.URL{a, b}},
+	}, report.UnreachableHosts)
+	assert.Contains(t, buf.String(), "Unreachable hosts")
+}
+
+func TestReportHTMLSizes(t *testing.T) {
+	r := NewHTML()
+	sizes := []int64{0, 10<<10 - 1, 10 << 10, 500 << 10, 1 << 20, 5 << 20}
+	for i, size := range sizes {
+		page, err := url.Parse(fmt.Sprintf("http://willdemaine.co.uk/%d", i))
+		require.NoError(t, err)
+		r.Add(PageInfo{URL: page, Size: size})
+	}
+
+	buf := bytes.NewBuffer(nil)
+	err := r.Report(buf)
+	assert.NoError(t, err)
+
+	counts := make(map[string]int)
+	for _, bucket := range r.build().Sizes {
+		counts[bucket.Label] = bucket.Count
+	}
+	assert.Equal(t, map[string]int{
+		"< 10KB":       2,
+		"10KB - 100KB": 1,
+		"100KB - 1MB":  1,
+		"> 1MB":        2,
+	}, counts)
+	assert.Contains(t, buf.String(), "Page sizes")
+}
+
+func TestReportHTMLMetadata(t *testing.T) {
+	r := NewHTML()
+	assert.Implements(t, (*MetadataReporter)(nil), r)
+	r.SetMetadata(map[string]string{
+		"title": "Willy's <site>",
+		"date":  "2017-06-01",
+	})
+
+	buf := bytes.NewBuffer(nil)
+	err := r.Report(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "<title>Willy&#39;s &lt;site&gt;</title>")
+	assert.Contains(t, buf.String(), "<h1>Willy&#39;s &lt;site&gt;</h1>")
+	assert.Contains(t, buf.String(), "<dt>date</dt><dd>2017-06-01</dd>")
+}
+
+func TestReportHTMLGroupByDirectory(t *testing.T) {
+	paths := []string{"", "/about", "/blog/", "/blog/first", "/blog/2017/second", "/docs/install", "/zebra"}
+
+	r := NewHTML()
+	for _, path := range paths {
+		uri, err := url.Parse("http://willdemaine.co.uk" + path)
+		require.NoError(t, err)
+		r.Add(PageInfo{URL: uri})
+	}
+
+	groups := groupByDirectory(r.sitemap)
+	names := make([]string, len(groups))
+	grouped := make(map[string][]string)
+	for i, group := range groups {
+		names[i] = group.Name
+		for _, page := range group.Pages {
+			grouped[group.Name] = append(grouped[group.Name], page.URL.Path)
+		}
+	}
+	assert.Equal(t, []string{"/", "/blog/*", "/docs/*"}, names)
+	assert.Equal(t, map[string][]string{
+		"/":       {"", "/about", "/zebra"},
+		"/blog/*": {"/blog/", "/blog/2017/second", "/blog/first"},
+		"/docs/*": {"/docs/install"},
+	}, grouped)
+
+	buf := bytes.NewBuffer(nil)
+	err := r.Report(buf)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "<details>")
+
+	r.SetGroupByDirectory(true)
+	buf.Reset()
+	err = r.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "<summary>/ (3)</summary>")
+	assert.Contains(t, buf.String(), "<summary>/blog/* (3)</summary>")
+	assert.Contains(t, buf.String(), "<summary>/docs/* (1)</summary>")
 }
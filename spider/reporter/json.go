@@ -0,0 +1,70 @@
+package reporter
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// JSON is a reporter that outputs the crawl as a JSON ResultSet, which can be read back with
+// ReadResultSet.
+type JSON struct {
+	pages     map[string]PageInfo
+	metadata  map[string]string
+	showDepth bool
+	sync.Mutex
+}
+
+// NewJSON creates a new JSON reporter.
+func NewJSON() *JSON {
+	return &JSON{
+		pages: make(map[string]PageInfo),
+	}
+}
+
+// Add a page to the result set. Pages which have already been added are ignored.
+func (r *JSON) Add(page PageInfo) {
+	r.Lock()
+	defer r.Unlock()
+	key := page.key()
+	if _, ok := r.pages[key]; ok {
+		return
+	}
+	r.pages[key] = page
+}
+
+// SetMetadata sets metadata which is included in the result set.
+func (r *JSON) SetMetadata(metadata map[string]string) {
+	r.Lock()
+	defer r.Unlock()
+	r.metadata = metadata
+}
+
+// SetShowDepth includes how many links away from the root each page was found.
+func (r *JSON) SetShowDepth(show bool) {
+	r.Lock()
+	defer r.Unlock()
+	r.showDepth = show
+}
+
+// ResultSet returns the pages added so far.
+func (r *JSON) ResultSet() ResultSet {
+	r.Lock()
+	defer r.Unlock()
+	set := newResultSet(r.pages)
+	set.Metadata = r.metadata
+	if r.showDepth {
+		for i := range set.Pages {
+			depth := r.pages[set.Pages[i].URL].Depth
+			set.Pages[i].Depth = &depth
+		}
+	}
+	return set
+}
+
+// Report writes the result set as JSON to the given writer.
+func (r *JSON) Report(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r.ResultSet())
+}
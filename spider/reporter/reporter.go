@@ -2,11 +2,174 @@ package reporter
 
 import (
 	"io"
+	"net/http"
 	"net/url"
+	"strings"
+	"time"
 )
 
+// PageInfo holds everything we know about a crawled page.
+type PageInfo struct {
+	URL *url.URL
+	// Method is set for pages crawled by submitting a form, such as POST, and empty for links.
+	// Body is the form data which was submitted.
+	Method string
+	Body   url.Values
+	Links  []*url.URL
+	Assets []string
+	// ResultsTruncated is true if some of the page's links and assets were left out because the
+	// crawl reached its limit on how many are stored.
+	ResultsTruncated bool
+	// Soft404 is true if the page responded OK but looks like a "not found" page.
+	Soft404 bool
+	// Latency is how long the page took to fetch.
+	Latency time.Duration
+	// Slow is true if the page took longer than the slow page threshold to fetch.
+	Slow bool
+	// ParseDuration is how long it took to extract the page's links and assets, not counting
+	// time spent waiting for the body. It is zero if the page wasn't parsed.
+	ParseDuration time.Duration
+	// FromSitemap is true if the page was listed in the site's sitemap.
+	FromSitemap bool
+	// Cache holds the caching headers the page was served with.
+	Cache CacheHeaders
+	// StatusCode is the status the page responded with.
+	StatusCode int
+	// Depth is how many links away from the root the page was found. The root has depth 0.
+	Depth int
+	// Referrer is the page, or sitemap, the page was first found on. It is nil for the root
+	// and seeds.
+	Referrer *url.URL
+	// Size is the size of the page's body in bytes.
+	Size int64
+	// HTML is true if the page was parsed as HTML. Title and Description are only set for
+	// HTML pages, and are empty if the page doesn't have them.
+	HTML        bool
+	Title       string
+	Description string
+	// Canonical is the absolute URL the page declares as canonical, or nil if it doesn't.
+	Canonical *url.URL
+	// IsSitemap is true if this is the sitemap rather than a page. Its links are the URLs
+	// listed in it.
+	IsSitemap bool
+	// AssetKinds maps each asset to its kind, such as "image" or "stylesheet". It is nil unless
+	// assets are being classified.
+	AssetKinds map[string]string
+	// MixedContent lists the assets of an https page which are loaded over http.
+	MixedContent []string
+	// ThirdPartyHosts are the other hosts the page warms up connections to with preconnect or
+	// dns-prefetch hints, sorted.
+	ThirdPartyHosts []string
+	// LinkCount is the number of distinct links on the page, including external ones.
+	LinkCount int
+	// ManyLinks is true if the page has more links than the high link threshold.
+	ManyLinks bool
+	// NearDuplicateOf is the first page crawled whose text is almost the same as this page's, or
+	// nil if there isn't one.
+	NearDuplicateOf *url.URL
+	// RedirectChain is every URL visited to reach the page, starting with the page's URL and
+	// ending with the one which responded, or nil if the page wasn't redirected.
+	RedirectChain []RedirectHop
+	// TextLength is the number of bytes of text on the page, without its markup. It is only set
+	// for HTML pages.
+	TextLength int
+	// Thin is true if the page has less text than the minimum content length.
+	Thin bool
+}
+
+// key identifies the page for deduplication, the same way the spider's queue does. Pages
+// fetched with GET are keyed by their URL alone, while others also take the method and body
+// into account, so a form posted back to the page it's on is reported as well as the page.
+func (p PageInfo) key() string {
+	method := strings.ToUpper(p.Method)
+	if method == "" || method == http.MethodGet {
+		return p.URL.String()
+	}
+	return method + " " + p.URL.String() + " " + p.Body.Encode()
+}
+
+// RedirectHop is a URL visited while following redirects, and the status it responded with.
+type RedirectHop struct {
+	URL        *url.URL
+	StatusCode int
+}
+
+// CacheHeaders are the HTTP caching headers of a response. Missing headers are empty.
+type CacheHeaders struct {
+	CacheControl string
+	ETag         string
+	Expires      string
+}
+
 // Interface describes a reporter.
 type Interface interface {
-	Add(uri *url.URL, links []*url.URL, assets []string)
+	Add(page PageInfo)
 	Report(io.Writer) error
 }
+
+// Resource is a site wide file which the spider consulted, such as robots.txt.
+type Resource struct {
+	URL *url.URL
+	// StatusCode is the response status, or zero if no response was received.
+	StatusCode int
+	Error      string
+}
+
+// ResourceReporter is a reporter which can also report the site wide files the spider consulted.
+type ResourceReporter interface {
+	AddResource(resource Resource)
+}
+
+// MetadataReporter is a reporter which can describe the crawl, e.g. with a title and date,
+// in its output.
+type MetadataReporter interface {
+	SetMetadata(metadata map[string]string)
+}
+
+// DepthReporter is a reporter which can show how many links away from the root each page was
+// found.
+type DepthReporter interface {
+	SetShowDepth(show bool)
+}
+
+// AssetHostReporter is a reporter which can show the hosts other than the root's which serve
+// the site's assets, such as CDNs.
+type AssetHostReporter interface {
+	SetExternalAssetHosts(root *url.URL)
+}
+
+// Categories of failure.
+const (
+	// FailureRedirectLoop is a page whose redirects lead back to a URL already visited.
+	FailureRedirectLoop = "redirect_loop"
+	// FailureUnreachableHost is a page whose host couldn't be resolved.
+	FailureUnreachableHost = "unreachable_host"
+)
+
+// Failure is a page which couldn't be crawled.
+type Failure struct {
+	URL *url.URL
+	// Category groups failures with the same cause, such as FailureRedirectLoop.
+	Category string
+	Error    string
+	// RedirectChain is every URL visited in a redirect loop, ending with the repeated one.
+	RedirectChain []*url.URL
+}
+
+// FailureReporter is a reporter which can also report pages which couldn't be crawled.
+type FailureReporter interface {
+	AddFailure(failure Failure)
+}
+
+// AssetStatus is the outcome of probing an asset.
+type AssetStatus struct {
+	URL *url.URL
+	// StatusCode is the response status, or zero if no response was received.
+	StatusCode int
+	Error      string
+}
+
+// AssetReporter is a reporter which can also report whether assets were reachable.
+type AssetReporter interface {
+	AddAssetStatus(status AssetStatus)
+}
@@ -0,0 +1,60 @@
+package reporter
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ResultSet is the outcome of a crawl in a form which can be saved and compared with later crawls.
+type ResultSet struct {
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Pages    []PageResult      `json:"pages"`
+	// Truncated is true if some pages' links and assets were left out because the crawl
+	// reached its limit on how many are stored.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// PageResult is a crawled page within a ResultSet.
+type PageResult struct {
+	URL    string   `json:"url"`
+	Links  []string `json:"links"`
+	Assets []string `json:"assets"`
+	// Method is set for pages crawled by submitting a form, such as POST.
+	Method string `json:"method,omitempty"`
+	// AssetKinds maps each asset to its kind, if assets were classified.
+	AssetKinds map[string]string `json:"asset_kinds,omitempty"`
+	// Depth is how many links away from the root the page was found, if depth is shown.
+	Depth *int `json:"depth,omitempty"`
+}
+
+// ReadResultSet reads a ResultSet written as JSON, such as by the JSON reporter.
+func ReadResultSet(r io.Reader) (ResultSet, error) {
+	var set ResultSet
+	err := json.NewDecoder(r).Decode(&set)
+	return set, err
+}
+
+// newResultSet creates a ResultSet from pages keyed by their requests. Pages are sorted by key.
+func newResultSet(pages map[string]PageInfo) ResultSet {
+	set := ResultSet{
+		Pages: make([]PageResult, 0, len(pages)),
+	}
+	for _, key := range sortedKeys(pages) {
+		page := pages[key]
+		result := PageResult{
+			URL:        page.URL.String(),
+			Method:     page.Method,
+			Links:      make([]string, len(page.Links)),
+			Assets:     append([]string{}, page.Assets...),
+			AssetKinds: page.AssetKinds,
+		}
+		for i, link := range page.Links {
+			result.Links[i] = link.String()
+		}
+		if page.ResultsTruncated {
+			set.Truncated = true
+		}
+		set.Pages = append(set.Pages, result)
+	}
+	return set
+}
@@ -0,0 +1,73 @@
+package spider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// formContentType is the content type POST bodies are sent with.
+const formContentType = "application/x-www-form-urlencoded"
+
+// Request is a page to crawl, along with how to request it. Links are always crawled with GET,
+// but some pages are only reachable by submitting a form.
+type Request struct {
+	URL *url.URL
+	// Method is GET if empty.
+	Method string
+	// Body is form encoded and sent with POST requests.
+	Body url.Values
+	// Header is sent with the request. It isn't taken into account for deduplication.
+	Header http.Header
+}
+
+// method returns the request's method, defaulting to GET.
+func (r Request) method() string {
+	if r.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(r.Method)
+}
+
+// key identifies the request for deduplication. GET requests are keyed by their URL alone so
+// they're deduplicated against links, while others also take the method and body into account.
+func (r Request) key() string {
+	method := r.method()
+	if method == http.MethodGet {
+		return r.URL.String()
+	}
+	return method + " " + r.URL.String() + " " + r.Body.Encode()
+}
+
+// idempotent returns true if making the request more than once has the same effect as making
+// it once, so that it's safe to retry.
+func (r Request) idempotent() bool {
+	switch r.method() {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// idempotencyKey is derived from the request, so every attempt at it sends the same key.
+func (r Request) idempotencyKey() string {
+	sum := sha256.Sum256([]byte(r.key()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// do makes the request.
+func (r Request) do(ctx context.Context, requester Requester) (*http.Response, error) {
+	method := r.method()
+	if method == http.MethodGet {
+		return requester.Do(ctx, method, r.URL, nil, r.Header)
+	}
+	header := http.Header{}
+	for key, values := range r.Header {
+		header[key] = values
+	}
+	header.Set("Content-Type", formContentType)
+	return requester.Do(ctx, method, r.URL, strings.NewReader(r.Body.Encode()), header)
+}
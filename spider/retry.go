@@ -0,0 +1,151 @@
+package spider
+
+import (
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// retryBaseBackoff is how long we wait before the first retry. It doubles after each attempt.
+	retryBaseBackoff = time.Millisecond * 100
+	// retryMaxBackoff caps the wait between any two attempts.
+	retryMaxBackoff = time.Second * 5
+	// retryBudgetMaxTokens caps how many retries can be saved up while requests are succeeding.
+	retryBudgetMaxTokens = 10
+)
+
+// retryBudget limits retries to a proportion of all requests across the crawl, so that a flaky
+// origin can't make us spend all our time retrying. Every request adds ratio tokens to the bucket
+// and every retry takes one. A nil retryBudget never runs out.
+type retryBudget struct {
+	ratio  float64
+	tokens float64
+	sync.Mutex
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio}
+}
+
+// request records that a request was made.
+func (b *retryBudget) request() {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetMaxTokens {
+		b.tokens = retryBudgetMaxTokens
+	}
+}
+
+// retry takes a token for a retry, returning false if there are none left.
+func (b *retryBudget) retry() bool {
+	if b == nil {
+		return true
+	}
+	b.Lock()
+	defer b.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// isDNSError returns true if the request failed because the host couldn't be resolved.
+func isDNSError(err error) bool {
+	for {
+		switch e := err.(type) {
+		case *net.DNSError:
+			return true
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		default:
+			return false
+		}
+	}
+}
+
+// isRetryable returns true if a failed request is worth trying again. Server errors and
+// network errors, including timeouts, are retryable.
+func isRetryable(err error) bool {
+	if httpErr, ok := err.(httpResponseError); ok {
+		return httpErr.statusCode >= 500
+	}
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// shouldRetry decides whether a failed request is worth trying again, using the predicate
+// from WithRetryOn if there is one. Hosts which don't resolve are never retried, whatever the
+// predicate says.
+func (s *Spider) shouldRetry(err error) bool {
+	if isDNSError(err) {
+		return false
+	}
+	if s.retryOn == nil {
+		return isRetryable(err)
+	}
+	var statusCode int
+	if httpErr, ok := err.(httpResponseError); ok {
+		statusCode = httpErr.statusCode
+	}
+	return s.retryOn(statusCode, err)
+}
+
+// withRetries calls fetch until it succeeds or fails with an error which isn't retryable,
+// backing off exponentially between attempts. It gives up once the time spent on the URL
+// would exceed the max retry duration or the retry budget is spent, returning the last error.
+// Requests which aren't idempotent, such as POSTs, are only retried if they carry an
+// idempotency key.
+func (s *Spider) withRetries(req Request, fetch func() error) error {
+	uri := req.URL
+	start := time.Now()
+	backoff := retryBaseBackoff
+	for {
+		s.retryBudget.request()
+		err := fetch()
+		if err == nil || s.maxRetryDuration <= 0 || !s.shouldRetry(err) {
+			return err
+		}
+		if !req.idempotent() && s.idempotencyKeyHeader == "" {
+			s.logger.Warn("Not retrying request without an idempotency key",
+				zap.String("url", uri.String()),
+				zap.String("method", req.method()),
+				zap.Error(err),
+			)
+			return err
+		}
+		if time.Since(start)+backoff > s.maxRetryDuration {
+			s.logger.Warn("Giving up retrying URL", zap.String("url", uri.String()), zap.Error(err))
+			return err
+		}
+		if !s.retryBudget.retry() {
+			s.logger.Warn("Retry budget spent, not retrying URL", zap.String("url", uri.String()), zap.Error(err))
+			return err
+		}
+
+		s.logger.Info("Retrying URL",
+			zap.String("url", uri.String()),
+			zap.Duration("backoff", backoff),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(backoff):
+		case <-s.runCtx.Done():
+			return err
+		}
+		backoff *= 2
+		if backoff > retryMaxBackoff {
+			backoff = retryMaxBackoff
+		}
+	}
+}
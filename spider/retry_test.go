@@ -0,0 +1,83 @@
+package spider
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dnsErr is how the http client reports a host which doesn't resolve.
+var dnsErr = &url.Error{
+	Op:  "Get",
+	URL: "http://nope.willdemaine.co.uk",
+	Err: &net.OpError{
+		Op:  "dial",
+		Net: "tcp",
+		Err: &net.DNSError{Err: "no such host", Name: "nope.willdemaine.co.uk", IsNotFound: true},
+	},
+}
+
+func TestIsRetryable(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"server error", httpResponseError{statusCode: 503}, true},
+		{"not found", httpResponseError{statusCode: 404}, false},
+		{"network error", &net.OpError{Op: "dial", Err: errors.New("refused")}, true},
+		{"other error", errors.New("bad markup"), false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, isRetryable(test.err))
+		})
+	}
+}
+
+func TestShouldRetryDNSError(t *testing.T) {
+	s := New(WithRoot(willydURL))
+	assert.False(t, s.shouldRetry(dnsErr))
+
+	s = New(WithRoot(willydURL), WithRetryOn(func(int, error) bool { return true }))
+	assert.False(t, s.shouldRetry(dnsErr))
+	assert.True(t, s.shouldRetry(errors.New("bad markup")))
+}
+
+func TestIsDNSError(t *testing.T) {
+	assert.True(t, isDNSError(dnsErr))
+	assert.True(t, isDNSError(&net.DNSError{Err: "no such host", Name: "foo"}))
+	assert.False(t, isDNSError(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	assert.False(t, isDNSError(httpResponseError{statusCode: 503}))
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(0.1)
+	assert.False(t, budget.retry())
+
+	for i := 0; i < 25; i++ {
+		budget.request()
+	}
+	assert.True(t, budget.retry())
+	assert.True(t, budget.retry())
+	assert.False(t, budget.retry())
+
+	// Tokens can't be saved up forever.
+	for i := 0; i < 1000; i++ {
+		budget.request()
+	}
+	for i := 0; i < retryBudgetMaxTokens; i++ {
+		assert.True(t, budget.retry())
+	}
+	assert.False(t, budget.retry())
+}
+
+func TestRetryBudgetNil(t *testing.T) {
+	var budget *retryBudget
+	budget.request()
+	assert.True(t, budget.retry())
+}
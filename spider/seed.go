@@ -0,0 +1,38 @@
+package spider
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// readSeedFile reads absolute http(s) URLs from a file, one per line. Blank lines and lines
+// starting with # are ignored.
+func readSeedFile(path string) ([]*url.URL, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open seed file")
+	}
+	defer f.Close()
+
+	var urls []*url.URL
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		uri, err := url.Parse(text)
+		if err != nil || (uri.Scheme != "http" && uri.Scheme != "https") || uri.Host == "" {
+			return nil, errors.Errorf("invalid URL on line %d of seed file: %q", line, text)
+		}
+		urls = append(urls, uri)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read seed file")
+	}
+	return urls, nil
+}
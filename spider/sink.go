@@ -0,0 +1,26 @@
+package spider
+
+import "github.com/Willyham/gospider/spider/reporter"
+
+// ResultSink receives every page as it is crawled, e.g. to stream results into a database or
+// queue rather than building a report at the end. It is called from the workers, so it must be
+// safe for concurrent use.
+type ResultSink interface {
+	// Consume stores the page. An error stops the crawl, like a page which fails to be fetched.
+	Consume(page reporter.PageInfo) error
+}
+
+// resultSinkError is returned when the result sink fails, so that it isn't mistaken for a
+// failure to fetch the page.
+type resultSinkError struct {
+	err error
+}
+
+func (e resultSinkError) Error() string {
+	return "result sink failed: " + e.err.Error()
+}
+
+// Cause returns the sink's error, for errors.Cause.
+func (e resultSinkError) Cause() error {
+	return e.err
+}
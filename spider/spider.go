@@ -1,10 +1,17 @@
 package spider
 
 import (
+	"bufio"
 	"context"
 	"io"
+	"math/rand"
+	"mime"
+	"net"
 	"net/http"
+	"net/http/cookiejar"
 	"net/url"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,15 +20,49 @@ import (
 	"github.com/Willyham/gospider/spider/internal/concurrency"
 	"github.com/Willyham/gospider/spider/internal/parser"
 	"github.com/Willyham/gospider/spider/reporter"
+	"github.com/pkg/errors"
 	"github.com/temoto/robotstxt"
 )
 
 const (
 	workerPollInterval = time.Millisecond * 100
 	userAgent          = "gospider/v1.0"
+
+	// lenientParseMinBody is the body size above which finding no links at all is
+	// treated as a sign of broken markup when lenient parsing is enabled.
+	lenientParseMinBody = 512
+
+	// genericContentType is what servers send when they don't know what they're serving.
+	genericContentType = "application/octet-stream"
+	// sniffLen is how much of the body http.DetectContentType looks at.
+	sniffLen = 512
 )
 
 var robotsTxtPath, _ = url.Parse("/robots.txt")
+var sitemapPath, _ = url.Parse("/sitemap.xml")
+
+// defaultExcludeExtensions are extensions of files which are almost never HTML, so aren't
+// worth requesting.
+var defaultExcludeExtensions = []string{
+	".pdf", ".zip", ".gz", ".tgz", ".tar", ".rar", ".7z", ".exe", ".dmg", ".iso", ".bin",
+	".mp4", ".mov", ".avi", ".mkv", ".webm", ".mp3", ".wav", ".ogg", ".flac",
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".ico", ".svg",
+	".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
+}
+
+// DefaultExcludeExtensions returns the extensions which aren't crawled unless overridden
+// with WithExcludeExtensions.
+func DefaultExcludeExtensions() []string {
+	return append([]string(nil), defaultExcludeExtensions...)
+}
+
+// newProductionLogger builds the default logger. It's a variable so that tests can make it fail.
+var newProductionLogger = func() (*zap.Logger, error) {
+	return zap.NewProduction()
+}
+
+// defaultIndexNames are the files which are served for a directory by default.
+var defaultIndexNames = []string{"index.html"}
 
 // Option is a function that configures the spider.
 type Option func(*Spider)
@@ -33,6 +74,28 @@ func WithRoot(root *url.URL) Option {
 	}
 }
 
+// WithFollowSubdomains sets whether subdomains of the root's host are treated as part of the site.
+func WithFollowSubdomains(follow bool) Option {
+	return func(s *Spider) {
+		s.followSubdomains = follow
+	}
+}
+
+// WithAllowedHosts sets hosts which are treated as part of the site, along with the root's host
+// and its subdomains if they're followed. The hosts don't need to be subdomains of the root.
+func WithAllowedHosts(hosts []string) Option {
+	return func(s *Spider) {
+		s.allowedHosts = hosts
+	}
+}
+
+// WithDeniedHosts sets hosts which are never crawled, even if they are allowed.
+func WithDeniedHosts(hosts []string) Option {
+	return func(s *Spider) {
+		s.deniedHosts = hosts
+	}
+}
+
 // WithIgnoreRobots sets whether or not the spider should ignore
 // the robots.txt data.
 func WithIgnoreRobots(ignore bool) Option {
@@ -41,7 +104,8 @@ func WithIgnoreRobots(ignore bool) Option {
 	}
 }
 
-// WithConcurrency sets how many workers will request urls concurrently.
+// WithConcurrency sets how many workers will request urls concurrently. Each worker follows
+// redirects one hop at a time, so it is also a ceiling on how many requests are in flight.
 func WithConcurrency(con int) Option {
 	return func(s *Spider) {
 		s.concurrency = con
@@ -55,6 +119,35 @@ func WithRequester(req Requester) Option {
 	}
 }
 
+// WithRequesterFactory sets a function which creates the requester for each host, such as
+// one with its own auth or proxy when following subdomains. It's called once per host, and
+// replaces the requester set by WithRequester. Requesters don't share cookies, so the session
+// from WithLogin is only kept by the requester for the login URL's host.
+func WithRequesterFactory(factory func(host string) Requester) Option {
+	return func(s *Spider) {
+		s.requesterFactory = factory
+	}
+}
+
+// WithMaxQueueBytes bounds the estimated memory used by URLs waiting to be crawled. URLs vary in
+// length, so this gives tighter control than counting them. Once the queue is full, new URLs
+// are spilled to a temporary file and read back as the queue empties.
+func WithMaxQueueBytes(max int64) Option {
+	return func(s *Spider) {
+		s.queue.maxBytes = max
+	}
+}
+
+// WithGlobalRateLimit caps the total rate of page requests across every host, e.g. to stay
+// under an egress quota. It applies on top of any per host delay.
+func WithGlobalRateLimit(perSecond float64) Option {
+	return func(s *Spider) {
+		if perSecond > 0 {
+			s.globalRate = newTokenBucket(perSecond)
+		}
+	}
+}
+
 // WithTimeout sets the request timeout.
 func WithTimeout(dur time.Duration) Option {
 	return func(s *Spider) {
@@ -69,144 +162,1966 @@ func WithUserAgent(agent string) Option {
 	}
 }
 
-// Spider can run requests against a URI until it sees every internal page on that site
-// at least once. It can be configued with Option arguments which override defaults.
-type Spider struct {
-	ignoreRobots     bool
-	followSubdomains bool
-	concurrency      int
-	rootURL          *url.URL
-	requestTimeout   time.Duration
-	userAgent        string
-
-	requester Requester
-	reporter  reporter.Interface
-	worker    concurrency.Worker
-	logger    *zap.Logger
-	robots    *robotstxt.RobotsData
-	queue     *urlQueue
-	wg        sync.WaitGroup
+// WithRobotsUserAgent sets the product token, such as "gospider", used to pick the robots.txt
+// rules which apply to us. By default it is the product portion of the user agent, up to the
+// first slash or space.
+func WithRobotsUserAgent(token string) Option {
+	return func(s *Spider) {
+		s.robotsUserAgent = token
+	}
 }
 
-// New creates a new spider with the given options.
-func New(options ...Option) *Spider {
-	logger, _ := zap.NewProduction()
-	spider := &Spider{
-		concurrency:    1,
-		ignoreRobots:   false,
-		requestTimeout: time.Second * 5,
-		userAgent:      userAgent,
-		requester: client{
-			logger: logger,
-			client: http.DefaultClient,
-		},
-		logger:   logger,
-		queue:    newURLQueue(),
-		reporter: reporter.NewHTML(),
+// WithLenientParsing sets whether the spider should fall back to a more lenient
+// parser when the tokenizer recovers no links from a page.
+func WithLenientParsing(lenient bool) Option {
+	return func(s *Spider) {
+		s.lenientParsing = lenient
 	}
-	// Default to spider.work, but allow this to be overridden for testing
-	// by having worker as a field on the Spider struct.
-	spider.worker = concurrency.WorkFunc((*spider).work)
-	for _, op := range options {
-		op(spider)
+}
+
+// WithDepthInReport shows how many links away from the root each page was first found in
+// reporters which implement reporter.DepthReporter, such as the HTML and JSON reporters.
+func WithDepthInReport(show bool) Option {
+	return func(s *Spider) {
+		s.depthInReport = show
 	}
+}
 
-	if spider.rootURL == nil {
-		panic("must supply a root URL")
+// WithExternalAssetHosts shows which hosts other than the root's, such as CDNs and analytics
+// providers, serve the site's assets and how many pages depend on each, in reporters which
+// implement reporter.AssetHostReporter, such as the HTML reporter.
+func WithExternalAssetHosts(show bool) Option {
+	return func(s *Spider) {
+		s.externalAssetHosts = show
 	}
+}
 
-	return spider
+// WithRedirectChains records every hop taken to reach pages which were redirected, along with
+// the status of each, and shows them in the report. The chain is rebuilt from the final
+// response, which net/http's client links back to each redirect, so it isn't recorded for
+// requesters whose responses don't keep the request they answer.
+func WithRedirectChains(record bool) Option {
+	return func(s *Spider) {
+		s.redirectChains = record
+	}
 }
 
-// Run the spider. Start at the root and follow all valid URLs, building a map
-// of the site.
-func (s *Spider) Run() error {
-	if s.robots == nil && !s.ignoreRobots {
-		robots, err := s.readRobotsData(s.rootURL)
-		if err != nil {
-			return err
+// WithOnError sets a function which is called with the URL and error each time a page fails
+// to be fetched or parsed, as it happens and whether or not the failure stops the crawl. It is
+// called from the workers, so it must be safe for concurrent use.
+func WithOnError(f func(uri *url.URL, err error)) Option {
+	return func(s *Spider) {
+		s.onError = f
+	}
+}
+
+// WithOnComplete sets a function which is called exactly once when Run finishes,
+// whether the crawl completed, failed, or was cancelled.
+func WithOnComplete(f func(RunStats)) Option {
+	return func(s *Spider) {
+		s.onComplete = f
+	}
+}
+
+// WithFormSubmission submits POST forms found on internal pages with their default values,
+// and crawls the pages they lead to. POST requests are only deduplicated against others with
+// the same URL and body. Submitting forms can change things on the site, so only use this on
+// sites where that's safe.
+func WithFormSubmission(submit bool) Option {
+	return func(s *Spider) {
+		s.submitForms = submit
+	}
+}
+
+// WithRequests sets requests, such as POSTs, which are crawled along with the root. They're
+// filtered like seeds, e.g. by robots.txt and WithCrawlFilter.
+func WithRequests(requests ...Request) Option {
+	return func(s *Spider) {
+		s.requests = requests
+	}
+}
+
+// WithProgress sets a function which is called with the progress of the crawl every interval
+// while Run is going, and once more when it finishes. Duration is how long the crawl has been
+// running, and Err is never set.
+func WithProgress(interval time.Duration, f func(RunStats)) Option {
+	return func(s *Spider) {
+		s.progressInterval = interval
+		s.onProgress = f
+	}
+}
+
+// WithLogger sets the logger, e.g. to log only warnings and errors.
+func WithLogger(logger *zap.Logger) Option {
+	return func(s *Spider) {
+		s.logger = logger
+		if c, ok := s.requester.(client); ok {
+			c.logger = logger
+			s.requester = c
 		}
-		s.robots = robots
 	}
+}
 
-	// Add our root to the queue to start us off.
-	s.queue.Append(s.rootURL)
-	s.wg.Add(1)
+// WithContextPropagation sets a function which is called with every request before it is
+// sent, along with its context, which is derived from the one passed to RunContext. It can
+// inject headers from the context, such as an OpenTelemetry propagator's trace headers, so
+// that crawls show up in traces. It has no effect with WithRequester.
+func WithContextPropagation(propagate func(ctx context.Context, req *http.Request)) Option {
+	return func(s *Spider) {
+		s.propagate = propagate
+	}
+}
 
-	pool := concurrency.NewWorkerPool(s.logger, s.concurrency, s.worker)
-	go pool.Start()
+// WithHTTPTrace calls the function with the DNS, connect, TLS and time to first byte timings of
+// every request, for diagnosing slow crawls. It has no effect with WithRequester.
+func WithHTTPTrace(trace func(uri *url.URL, timings TraceTimings)) Option {
+	return func(s *Spider) {
+		s.httpTrace = trace
+	}
+}
 
-	// Wait until we're done with all work, the drain the pool too.
-	s.wg.Wait()
-	pool.StopWait()
-	return nil
+// WithHeaderFunc sets a function which is called with the URL of every page before it is
+// requested, and whose headers are sent with the request. It can vary headers by path, such as
+// sending a different token to each section of a site.
+func WithHeaderFunc(headerFunc func(*url.URL) http.Header) Option {
+	return func(s *Spider) {
+		s.headerFunc = headerFunc
+	}
 }
 
-// Report writes the report to the writer.
-func (s *Spider) Report(w io.Writer) error {
-	return s.reporter.Report(w)
+// WithBrowserHeaders sends the headers a browser would, such as Accept and Accept-Language,
+// with each page request. The headers rotate between a few common browsers, each with a
+// matching user agent which replaces the one set by WithUserAgent. robots.txt is still matched
+// against our own user agent. It is off by default.
+func WithBrowserHeaders(browser bool) Option {
+	return func(s *Spider) {
+		s.browserHeaders = browser
+	}
 }
 
-// work is the function used by the worker in the pool. Each worker will poll the URL queue
-// for items. If a URL is found, it will collect the links/assets for the URL and report them.
-func (s *Spider) work() error {
-	next := s.queue.Next()
-	if next == nil {
-		time.Sleep(workerPollInterval)
-		return nil
+// WithLogin sets a login form which is submitted before crawling begins. The session
+// cookies it sets are kept by the requester which submitted it. The default requester sends
+// them with every subsequent request, but requesters made by WithRequesterFactory don't share
+// cookies, so only the one for the login URL's host is logged in.
+func WithLogin(loginURL *url.URL, formData url.Values) Option {
+	return func(s *Spider) {
+		s.loginURL = loginURL
+		s.loginForm = formData
 	}
-	s.logger.Info("Items left in queue", zap.Int("number", len(s.queue.urls)))
-	defer s.wg.Done()
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
-	defer cancel()
+// WithSoft404Matcher sets a function which detects "not found" pages that respond
+// with a 200. Matching pages are reported as soft 404s. The body is reused once the
+// matcher returns, so it must not be retained.
+func WithSoft404Matcher(matcher func(body []byte) bool) Option {
+	return func(s *Spider) {
+		s.soft404Matcher = matcher
+	}
+}
 
-	body, err := s.requester.Request(ctx, next)
-	if err != nil {
-		// TODO: Maybe make err retryable.
-		return err
+// WithEventWriter sets a writer which receives a newline delimited JSON event
+// as each page is fetched, each link is found, and when the crawl is done.
+func WithEventWriter(w io.Writer) Option {
+	return func(s *Spider) {
+		s.events = newEventWriter(w)
 	}
+}
 
-	results, err := parser.ByToken(body)
-	if err != nil {
-		return err
+// WithSlowPageThreshold sets a fetch time above which pages are reported as slow.
+// Unlike the request timeout, slow pages are still crawled.
+func WithSlowPageThreshold(threshold time.Duration) Option {
+	return func(s *Spider) {
+		s.slowPageThreshold = threshold
 	}
+}
 
-	// TODO: Move these predicates out of the work function
-	onlyInternal := createIsInternalPredicate(s.rootURL, s.followSubdomains)
-	asAbsolute := createAbsoluteTransformer(s.rootURL)
-	notSeen := createNotSeenPredicate(s.queue)
-	allowedByRobots := createShouldRequestByRobotsPredicate(s.userAgent, s.robots)
+// WithHighLinkThreshold sets a number of links above which pages are reported as having too
+// many, which can be a sign of link spam. Every distinct link counts, including external ones.
+func WithHighLinkThreshold(threshold int) Option {
+	return func(s *Spider) {
+		s.highLinkThreshold = threshold
+	}
+}
 
-	absoluteLinks := mapURLs(asAbsolute, results.Links)
-	internalLinks := filter(onlyInternal, absoluteLinks)
+// WithMinContentLength sets a number of bytes of text, not counting markup, below which HTML
+// pages are reported as thin, which is useful for content audits. Zero disables it.
+func WithMinContentLength(min int) Option {
+	return func(s *Spider) {
+		s.minContentLength = min
+	}
+}
 
-	// Report all links before we filter out the ones we need to fetch.
-	s.reporter.Add(next, internalLinks, results.Assets)
-	s.logger.Info("Found links", zap.Int("links", len(internalLinks)))
+// WithNearDuplicateDetection reports clusters of pages whose text is almost the same, such as
+// the same article under several URLs. The threshold is how alike two pages' SimHashes must be
+// to count as near duplicates, from 0 to 1, where 1 only matches pages with the same words.
+func WithNearDuplicateDetection(threshold float64) Option {
+	return func(s *Spider) {
+		s.nearDuplicates = newNearDuplicates(threshold)
+		s.tokenParser.ComputeSimHash = true
+	}
+}
 
-	// Filter out links that we've already seen or that aren't allowed by the robots.txt file.
-	toAdd := filter(allowedByRobots,
-		filter(notSeen, internalLinks),
-	)
-	for _, link := range toAdd {
-		s.logger.Info("Enqueing link to fetch", zap.String("url", link.String()))
-		s.queue.Append(link)
-		s.wg.Add(1)
+// WithSkipNearDuplicateLinks doesn't follow the links on pages found to be near duplicates of
+// an earlier page, since they usually lead to the same places. It only has an effect with
+// WithNearDuplicateDetection.
+func WithSkipNearDuplicateLinks(skip bool) Option {
+	return func(s *Spider) {
+		s.skipNearDuplicateLinks = skip
 	}
+}
 
-	return nil
+// WithSkipFragmentOnlyLinks ignores links which only lead back to the page they're on, such as
+// "#top" or "#", so they're neither crawled nor reported as links.
+func WithSkipFragmentOnlyLinks(skip bool) Option {
+	return func(s *Spider) {
+		s.skipFragmentOnlyLinks = skip
+	}
+}
+
+// WithTreatWWWAsSame sets whether links to the root's host with or without a "www."
+// prefix should be treated as the same site.
+func WithTreatWWWAsSame(same bool) Option {
+	return func(s *Spider) {
+		s.treatWWWAsSame = same
+	}
+}
+
+// WithAutoSave saves the queue and the requests seen so far to the file at path every
+// interval while crawling, and when the crawl fails or is cancelled, so that a long crawl can
+// survive a crash. If the file exists when the crawl starts, the crawl resumes from it rather
+// than starting from the root. Only pages crawled after resuming are reported. The file is
+// removed once the crawl finishes.
+func WithAutoSave(path string, interval time.Duration) Option {
+	return func(s *Spider) {
+		s.autoSavePath = path
+		s.autoSaveInterval = interval
+	}
+}
+
+// WithInitialSeenURLs marks the URLs as already seen, so they are never fetched.
+func WithInitialSeenURLs(urls []*url.URL) Option {
+	return func(s *Spider) {
+		for _, uri := range urls {
+			s.queue.MarkSeen(uri)
+		}
+	}
+}
+
+// WithAdaptiveConcurrency makes the spider adjust how many workers fetch at once between
+// min and max, backing off when the error rate or latency spikes and ramping back up
+// when requests are healthy. It overrides WithConcurrency. A page which fails with an error
+// that isn't retried stops the crawl, so use it with WithMaxRetryDuration for failures to
+// shrink concurrency rather than end the crawl.
+func WithAdaptiveConcurrency(min, max int) Option {
+	return func(s *Spider) {
+		s.limiter = newAdaptiveLimiter(min, max)
+	}
+}
+
+// WithSitemapSeeding sets whether the spider should read the root's sitemap.xml and
+// crawl every page in it, as well as the pages found by following links.
+func WithSitemapSeeding(seed bool) Option {
+	return func(s *Spider) {
+		s.seedFromSitemap = seed
+	}
+}
+
+// WithMaxAssetsPerPage caps how many assets are reported for each page.
+// Zero means no limit.
+func WithMaxAssetsPerPage(max int) Option {
+	return func(s *Spider) {
+		s.maxAssetsPerPage = max
+	}
+}
+
+// WithResultLimit caps how many links and assets are reported across the whole crawl, so that
+// the reporter's memory stays bounded on very large sites. Once the limit is reached pages are
+// still reported, but with their links and assets truncated, and marked as such. Zero means no
+// limit.
+func WithResultLimit(max int) Option {
+	return func(s *Spider) {
+		s.resultLimit = max
+	}
+}
+
+// WithCrawlFilter adds a filter which every link must pass before it is crawled. Filters
+// are applied in the order they are added, after the built in robots.txt and seen checks.
+func WithCrawlFilter(f CrawlFilter) Option {
+	return func(s *Spider) {
+		s.crawlFilters = append(s.crawlFilters, f)
+	}
+}
+
+// WithSniffContentType sets whether to detect the type of pages from their body when the
+// Content-Type header is missing or generic. Otherwise such pages are parsed as HTML.
+func WithSniffContentType(sniff bool) Option {
+	return func(s *Spider) {
+		s.sniffContentType = sniff
+	}
+}
+
+// WithMaxRetryDuration enables retrying pages which fail with a server or network error, and
+// caps the total time spent retrying any one page. Once exceeded, the page is recorded as a
+// failure and the crawl carries on. Zero, the default, disables retries.
+func WithMaxRetryDuration(d time.Duration) Option {
+	return func(s *Spider) {
+		s.maxRetryDuration = d
+	}
+}
+
+// WithReporter sets the reporter which crawled pages are added to. The default reporter
+// writes a HTML sitemap.
+func WithReporter(r reporter.Interface) Option {
+	return func(s *Spider) {
+		s.reporter = r
+	}
+}
+
+// WithRetryBudget limits retries to roughly the given proportion of all requests made during
+// the crawl. Once the budget is spent, failures aren't retried until enough requests have
+// been made to earn more. It only has an effect when retries are enabled with WithMaxRetryDuration.
+func WithRetryBudget(ratio float64) Option {
+	return func(s *Spider) {
+		s.retryBudget = newRetryBudget(ratio)
+	}
+}
+
+// WithExcludeExtensions sets the extensions of links which aren't crawled, replacing the defaults.
+// Extend the defaults by appending to DefaultExcludeExtensions, or pass nil to crawl every link.
+func WithExcludeExtensions(extensions []string) Option {
+	return func(s *Spider) {
+		s.excludeExtensions = extensions
+	}
+}
+
+// WithReportMetadata sets details about the crawl, such as a title or date, for reporters
+// which can show them.
+func WithReportMetadata(metadata map[string]string) Option {
+	return func(s *Spider) {
+		s.reportMetadata = metadata
+	}
+}
+
+// WithSkipUnchanged skips pages whose sitemap lastmod hasn't changed since they were put in the
+// store by a previous crawl, reporting the stored result instead. Crawled pages with a lastmod are
+// put in the store. It only has an effect when seeding from the sitemap.
+func WithSkipUnchanged(store LastModStore) Option {
+	return func(s *Spider) {
+		s.lastModStore = store
+	}
+}
+
+// WithSeedFile sets a file of URLs, one per line, which are crawled along with the root.
+// Seeds don't have to be on the root's host. They're normalised and filtered like links, e.g. by
+// robots.txt and WithCrawlFilter, but aren't limited by depth. With WithMaxDepth(0), only the
+// root and the seeds are fetched, which makes the spider a batch URL checker.
+func WithSeedFile(path string) Option {
+	return func(s *Spider) {
+		s.seedFile = path
+	}
+}
+
+// WithMaxDepth sets how many links away from the root, or a seed, pages are crawled.
+// Negative means no limit, which is the default.
+func WithMaxDepth(depth int) Option {
+	return func(s *Spider) {
+		s.maxDepth = depth
+	}
+}
+
+// WithMaxSubdomainDepth sets how many links away from the root pages on subdomains of the
+// root's host are crawled, when following them with WithFollowSubdomains, so that a large
+// subdomain can be crawled less deeply than the main site. WithMaxDepth still applies to every
+// page. Negative means no limit, which is the default.
+func WithMaxSubdomainDepth(depth int) Option {
+	return func(s *Spider) {
+		s.subdomainDepth = depth
+	}
+}
+
+// WithSinglePage fetches only the root and any seeds, reporting their links and assets
+// without following them. It takes precedence over WithMaxDepth.
+func WithSinglePage(single bool) Option {
+	return func(s *Spider) {
+		s.singlePage = single
+	}
+}
+
+// WithMaxTotalBytes stops the crawl once the pages fetched add up to the given number of
+// bytes. Pages already being fetched are allowed to finish, so the total may go slightly over.
+// Pages crawled before the limit was reached are still reported. Zero means no limit.
+func WithMaxTotalBytes(max int64) Option {
+	return func(s *Spider) {
+		s.maxTotalBytes = max
+	}
+}
+
+// WithExtraLinkAttributes sets attributes, such as data-href, which are also read as links
+// on any tag.
+func WithExtraLinkAttributes(attrs []string) Option {
+	return func(s *Spider) {
+		s.tokenParser.ExtraLinkAttributes = attrs
+	}
+}
+
+// WithExtraAssetAttributes sets attributes, such as data-src, which are also read as assets
+// on any tag. This picks up images which are lazy loaded.
+func WithExtraAssetAttributes(attrs []string) Option {
+	return func(s *Spider) {
+		s.tokenParser.ExtraAssetAttributes = attrs
+	}
+}
+
+// WithParseComments also collects links and assets from commented out markup, which some
+// templates leave behind. It is off by default.
+func WithParseComments(parse bool) Option {
+	return func(s *Spider) {
+		s.tokenParser.ParseComments = parse
+	}
+}
+
+// WithStatusServer serves /healthz and /status on the given address, such as ":8080", while
+// the spider runs. /status returns the crawl's progress as JSON. The server is shut down when
+// the crawl ends.
+func WithStatusServer(addr string) Option {
+	return func(s *Spider) {
+		s.statusAddr = addr
+	}
+}
+
+// WithJSLinkExtraction collects links from the contents of inline scripts, which is useful for
+// single page apps that keep their routes in JavaScript. Each match of the pattern is a link,
+// or its first group if it has one, e.g. `["'](/[a-z0-9/-]+)["']`. Matches are only crawled if
+// they are internal. External scripts aren't fetched. This is off by default because string
+// literals which look like paths often aren't pages.
+func WithJSLinkExtraction(pattern *regexp.Regexp) Option {
+	return func(s *Spider) {
+		s.tokenParser.ScriptLinkPattern = pattern
+	}
+}
+
+// WithFocusKeywords crawls links whose path or anchor text contains any of the keywords before
+// other links, so that the sections of a site about a topic are covered early. Links matching
+// more keywords are crawled first. Matching ignores case.
+func WithFocusKeywords(keywords []string) Option {
+	return func(s *Spider) {
+		s.focusKeywords = make([]string, len(keywords))
+		for i, keyword := range keywords {
+			s.focusKeywords[i] = strings.ToLower(keyword)
+		}
+		s.tokenParser.CollectAnchorText = len(keywords) > 0
+	}
+}
+
+// WithUpgradeInsecureLinks rewrites internal http links to https when the root is https, so
+// that the site isn't crawled twice. Links with an explicit port are left as they are.
+func WithUpgradeInsecureLinks(upgrade bool) Option {
+	return func(s *Spider) {
+		s.upgradeInsecureLinks = upgrade
+	}
+}
+
+// WithIdempotencyKey sends a key derived from each request which isn't idempotent, such as a
+// submitted POST form, in the given header, e.g. Idempotency-Key. Every attempt at a request
+// sends the same key, so the server can tell a retry from a second submission. Without it,
+// those requests are never retried.
+func WithIdempotencyKey(header string) Option {
+	return func(s *Spider) {
+		s.idempotencyKeyHeader = header
+	}
+}
+
+// WithRetryOn replaces the check for which failures are retried, which by default is server
+// errors and network errors. The status code is zero if the request failed without a response.
+// Hosts which don't resolve are never retried, whatever it returns. Retries still need
+// WithMaxRetryDuration to be set.
+func WithRetryOn(retryOn func(statusCode int, err error) bool) Option {
+	return func(s *Spider) {
+		s.retryOn = retryOn
+	}
+}
+
+// WithRandomizedQueue shuffles the links found on each page before they are queued, so pages
+// aren't fetched in such a regular order. Every page is still crawled. The seed makes the
+// order repeatable.
+func WithRandomizedQueue(seed int64) Option {
+	return func(s *Spider) {
+		s.linkRand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithSampleRate only crawls a random fraction of the links found, between 0 and 1, for a quick
+// sample of a large site rather than full coverage. The root is always crawled, and links
+// which are skipped aren't considered again when found on other pages. The seed makes the
+// sample repeatable. Fractions outside 0 to 1 are clamped to that range.
+func WithSampleRate(fraction float64, seed int64) Option {
+	return func(s *Spider) {
+		if fraction < 0 {
+			fraction = 0
+		}
+		if fraction > 1 {
+			fraction = 1
+		}
+		s.sampleRate = fraction
+		s.sampleRand = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithCollapseIndexPages treats /dir, /dir/ and /dir/index.html as the same page, so only the
+// first of them found is crawled. It's fetched as it was linked, since not every server
+// redirects between the forms. Paths without an extension are assumed to be directories.
+func WithCollapseIndexPages(collapse bool) Option {
+	return func(s *Spider) {
+		s.collapseIndexPages = collapse
+	}
+}
+
+// WithMaxConcurrentParses limits how many pages are parsed at once, so that many workers can
+// wait on the network without parsing saturating the CPU. Pages are parsed as they are read
+// unless the whole body is needed, so a slow body can hold a slot. Zero means no limit.
+func WithMaxConcurrentParses(max int) Option {
+	return func(s *Spider) {
+		s.maxConcurrentParses = max
+	}
+}
+
+// WithIgnoreQueryStrings strips the query string from every URL before it is deduplicated and
+// crawled, whether it was linked or came from the sitemap, a seed or a form, so /page?a=1 and
+// /page?b=2 are both crawled as /page. It's a blunt way to avoid crawler traps on sites whose
+// query parameters don't change the content.
+func WithIgnoreQueryStrings(ignore bool) Option {
+	return func(s *Spider) {
+		s.ignoreQueryStrings = ignore
+	}
+}
+
+// WithIndexPageNames sets the file names which WithCollapseIndexPages treats as a directory's
+// index. The default is index.html.
+func WithIndexPageNames(names []string) Option {
+	return func(s *Spider) {
+		s.indexNames = names
+	}
+}
+
+// WithReportCallback sets a function which is called with every page as it is reported, e.g.
+// to feed a live dashboard. It is called from the workers, so it must be safe for concurrent use.
+func WithReportCallback(f func(reporter.PageInfo)) Option {
+	return func(s *Spider) {
+		s.onReport = f
+	}
+}
+
+// WithResultSink sends every page to the sink as it is reported, alongside the reporter.
+func WithResultSink(sink ResultSink) Option {
+	return func(s *Spider) {
+		s.resultSink = sink
+	}
+}
+
+// WithMaxUniqueQueryKeys skips URLs with more than the given number of distinct query
+// parameters, which are usually generated by a crawler trap such as a faceted search.
+// Zero means no limit.
+func WithMaxUniqueQueryKeys(max int) Option {
+	return func(s *Spider) {
+		s.maxQueryKeys = max
+	}
+}
+
+// WithReportSitemap reports the sitemap as a page which links to every internal URL in it, so
+// reports such as GraphML show which pages are only reachable from the sitemap. It has no
+// effect without WithSitemapSeeding.
+func WithReportSitemap(report bool) Option {
+	return func(s *Spider) {
+		s.reportSitemap = report
+	}
+}
+
+// WithHostTimeout overrides the request timeout for particular hosts, e.g. to give a slow
+// API host longer than the rest of the site. Hosts are matched case insensitively.
+func WithHostTimeout(timeouts map[string]time.Duration) Option {
+	return func(s *Spider) {
+		s.hostTimeouts = make(map[string]time.Duration, len(timeouts))
+		for host, timeout := range timeouts {
+			s.hostTimeouts[strings.ToLower(host)] = timeout
+		}
+	}
+}
+
+// WithConnectTimeout sets how long to wait for a connection to a host, so that hosts which
+// aren't there fail fast even with a long request timeout. The request timeout still applies
+// to the request as a whole. It has no effect with WithRequester.
+func WithConnectTimeout(d time.Duration) Option {
+	return func(s *Spider) {
+		s.transport.connectTimeout = d
+	}
+}
+
+// WithResponseHeaderTimeout sets how long to wait for a response's headers once the request
+// has been sent. Reading the body is only limited by the request timeout, so slow bodies can
+// still stream. It has no effect with WithRequester.
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(s *Spider) {
+		s.transport.responseHeaderTimeout = d
+	}
+}
+
+// WithMaxParseBytes limits how much of each page is parsed, to bound the memory used by very
+// large pages. Links and assets are still collected from the start of the page. Unless the
+// whole body is needed, e.g. for WithSoft404Matcher, the rest isn't downloaded either.
+// Zero means no limit.
+func WithMaxParseBytes(max int) Option {
+	return func(s *Spider) {
+		s.maxParseBytes = max
+	}
+}
+
+// WithMaxCrawlDelay honors the Crawl-delay robots.txt gives for our user agent, spacing out
+// requests to each host by it, but by no more than max so that an absurd delay can't make the
+// crawl take days. Crawl-delay is ignored if this isn't set.
+func WithMaxCrawlDelay(max time.Duration) Option {
+	return func(s *Spider) {
+		s.maxCrawlDelay = max
+	}
+}
+
+// WithDelayFunc sets a function which decides how long to wait before each request, given the
+// host and how long the last request to it took, which is zero for the first. It is called
+// from the workers, so it must be safe for concurrent use. It is separate from the Crawl-delay
+// honored by WithMaxCrawlDelay, and the longer of the two waits is used.
+func WithDelayFunc(f func(host string, lastLatency time.Duration) time.Duration) Option {
+	return func(s *Spider) {
+		s.delayFunc = f
+	}
+}
+
+// WithRespectMetaRobots obeys pages' robots meta tags. Links aren't followed from nofollow
+// pages, and noindex pages are crawled but left out of the report.
+func WithRespectMetaRobots(respect bool) Option {
+	return func(s *Spider) {
+		s.respectMetaRobots = respect
+	}
+}
+
+// WithAssetClassification reports the kind of each asset, such as image or stylesheet, based
+// on the tag it was loaded by or its extension.
+func WithAssetClassification(classify bool) Option {
+	return func(s *Spider) {
+		s.classifyAssets = classify
+	}
+}
+
+// WithAssetProbing requests every unique asset found once the crawl is done, with a HEAD
+// request, and reports whether it was reachable to reporters which implement
+// reporter.AssetReporter, such as the one created by reporter.NewAssetInventory.
+func WithAssetProbing(probe bool) Option {
+	return func(s *Spider) {
+		s.assetProbing = probe
+	}
+}
+
+// WithAssetProbeInterval limits asset probing to one request every interval, separately from
+// any limits on crawling pages. Zero means no limit.
+func WithAssetProbeInterval(interval time.Duration) Option {
+	return func(s *Spider) {
+		s.assetProbeInterval = interval
+	}
+}
+
+// WithResolver sets the DNS resolver used to look up hosts, e.g. to crawl a site through
+// split horizon DNS. It has no effect with WithRequester or WithDialer.
+func WithResolver(resolver *net.Resolver) Option {
+	return func(s *Spider) {
+		s.transport.resolver = resolver
+	}
+}
+
+// WithDialer sets the function used to connect to hosts, which replaces the default dialer
+// along with WithConnectTimeout and WithResolver. It can map hosts to other addresses, such
+// as a local test server. It has no effect with WithRequester.
+func WithDialer(dial DialFunc) Option {
+	return func(s *Spider) {
+		s.transport.dial = dial
+	}
+}
+
+// WithForceHTTP1 only speaks HTTP/1.1, even to servers which support HTTP/2, for older
+// servers whose HTTP/2 support hangs or errors. It has no effect with WithRequester.
+func WithForceHTTP1(force bool) Option {
+	return func(s *Spider) {
+		s.transport.forceHTTP1 = force
+	}
+}
+
+// Spider can run requests against a URI until it sees every internal page on that site
+// at least once. It can be configued with Option arguments which override defaults.
+//
+// All options must be passed to New. A spider's configuration is read concurrently by
+// its workers, so it must not change once Run has been called, and Run must only be
+// called once.
+type Spider struct {
+	ignoreRobots      bool
+	followSubdomains  bool
+	concurrency       int
+	rootURL           *url.URL
+	requestTimeout    time.Duration
+	userAgent         string
+	robotsUserAgent   string
+	lenientParsing    bool
+	onComplete        func(RunStats)
+	onError           func(uri *url.URL, err error)
+	onProgress        func(RunStats)
+	progressInterval  time.Duration
+	loginURL          *url.URL
+	loginForm         url.Values
+	soft404Matcher    func(body []byte) bool
+	slowPageThreshold time.Duration
+	highLinkThreshold int
+	treatWWWAsSame    bool
+	seedFromSitemap   bool
+	maxAssetsPerPage  int
+	resultLimit       int
+	// storedResults is how many links and assets have been reported, for WithResultLimit.
+	storedResults          int
+	storedResultsLock      sync.Mutex
+	crawlFilters           []CrawlFilter
+	sniffContentType       bool
+	maxRetryDuration       time.Duration
+	allowedHosts           []string
+	deniedHosts            []string
+	excludeExtensions      []string
+	reportMetadata         map[string]string
+	lastModStore           LastModStore
+	seedFile               string
+	maxDepth               int
+	maxTotalBytes          int64
+	tokenParser            parser.TokenParser
+	statusAddr             string
+	upgradeInsecureLinks   bool
+	retryOn                func(statusCode int, err error) bool
+	linkRand               *rand.Rand
+	linkRandLock           sync.Mutex
+	sampleRate             float64
+	sampleRand             *rand.Rand
+	sampleRandLock         sync.Mutex
+	collapseIndexPages     bool
+	indexNames             []string
+	onReport               func(reporter.PageInfo)
+	maxQueryKeys           int
+	reportSitemap          bool
+	hostTimeouts           map[string]time.Duration
+	transport              transportConfig
+	maxParseBytes          int
+	delayFunc              func(host string, lastLatency time.Duration) time.Duration
+	maxCrawlDelay          time.Duration
+	respectMetaRobots      bool
+	classifyAssets         bool
+	singlePage             bool
+	submitForms            bool
+	requests               []Request
+	ignoreQueryStrings     bool
+	propagate              func(ctx context.Context, req *http.Request)
+	headerFunc             func(*url.URL) http.Header
+	maxConcurrentParses    int
+	focusKeywords          []string
+	assetProbing           bool
+	assetProbeInterval     time.Duration
+	autoSavePath           string
+	autoSaveInterval       time.Duration
+	browserHeaders         bool
+	depthInReport          bool
+	requesterFactory       func(host string) Requester
+	idempotencyKeyHeader   string
+	nearDuplicates         *nearDuplicates
+	skipNearDuplicateLinks bool
+	httpTrace              func(uri *url.URL, timings TraceTimings)
+	skipFragmentOnlyLinks  bool
+	externalAssetHosts     bool
+	redirectChains         bool
+	subdomainDepth         int
+	resultSink             ResultSink
+	minContentLength       int
+	// parseSlots has room for WithMaxConcurrentParses parses, and is nil if there's no limit.
+	parseSlots chan struct{}
+	// crawlDelay is the robots.txt crawl delay, capped by maxCrawlDelay, once robots.txt is read.
+	crawlDelay    time.Duration
+	crawlSchedule *hostSchedule
+	// probedAssets collects the assets to probe, and is nil unless probing.
+	probedAssets *assetSet
+	// browserProfile counts the requests sent with browser headers, to rotate between them.
+	browserProfile uint32
+	// hostRequesters are the requesters made by requesterFactory, by host.
+	hostRequesters     map[string]Requester
+	hostRequestersLock sync.Mutex
+	// globalRate limits requests across every host, if set.
+	globalRate *tokenBucket
+
+	requester   Requester
+	reporter    reporter.Interface
+	worker      concurrency.Worker
+	logger      *zap.Logger
+	robots      *robotstxt.RobotsData
+	queue       *urlQueue
+	pending     pendingCount
+	counters    counters
+	events      *eventWriter
+	limiter     *adaptiveLimiter
+	retryBudget *retryBudget
+	latencies   *hostLatencies
+	pool        *concurrency.WorkerPool
+	paused      bool
+	poolLock    sync.Mutex
+	// runCtx and the sitemap maps are written before crawling starts, so they are safe to read
+	// from workers. Requests are made with contexts derived from runCtx.
+	runCtx          context.Context
+	sitemapURLs     map[string]bool
+	sitemapLastMods map[string]time.Time
+}
+
+// New creates a new spider with the given options.
+func New(options ...Option) *Spider {
+	logger, err := newProductionLogger()
+	if err != nil || logger == nil {
+		// A crawl is still useful without logs, so don't fail because of them.
+		logger = zap.NewNop()
+	}
+	// Keep cookies between requests so that logged in sessions work. This can't error
+	// without options.
+	jar, _ := cookiejar.New(nil)
+	spider := &Spider{
+		concurrency:       1,
+		ignoreRobots:      false,
+		requestTimeout:    time.Second * 5,
+		userAgent:         userAgent,
+		excludeExtensions: defaultExcludeExtensions,
+		maxDepth:          -1,
+		indexNames:        defaultIndexNames,
+		requester: client{
+			logger: logger,
+			client: &http.Client{
+				Jar:           jar,
+				CheckRedirect: checkRedirect,
+			},
+		},
+		logger:          logger,
+		queue:           newURLQueue(),
+		latencies:       newHostLatencies(),
+		crawlSchedule:   newHostSchedule(),
+		runCtx:          context.Background(),
+		sitemapURLs:     make(map[string]bool),
+		sitemapLastMods: make(map[string]time.Time),
+		reporter:        reporter.NewHTML(),
+		subdomainDepth:  -1,
+	}
+	// Default to spider.work, but allow this to be overridden for testing
+	// by having worker as a field on the Spider struct.
+	spider.worker = concurrency.WorkFunc((*spider).work)
+	for _, op := range options {
+		op(spider)
+	}
+
+	if spider.rootURL == nil {
+		panic("must supply a root URL")
+	}
+	spider.queue.logger = spider.logger
+	if spider.collapseIndexPages {
+		spider.queue.keyTransform = createIndexTransformer(spider.indexNames)
+	}
+	if spider.events != nil {
+		spider.events.logger = spider.logger
+	}
+	if c, ok := spider.requester.(client); ok && !spider.transport.isZero() {
+		c.client.Transport = newTransport(spider.transport)
+	}
+	if spider.assetProbing {
+		spider.probedAssets = newAssetSet()
+	}
+	if spider.maxConcurrentParses > 0 {
+		spider.parseSlots = make(chan struct{}, spider.maxConcurrentParses)
+	}
+	if c, ok := spider.requester.(client); ok && spider.propagate != nil {
+		c.propagate = spider.propagate
+		spider.requester = c
+	}
+	if c, ok := spider.requester.(client); ok && spider.httpTrace != nil {
+		c.trace = spider.httpTrace
+		spider.requester = c
+	}
+	if r, ok := spider.reporter.(reporter.MetadataReporter); ok && spider.reportMetadata != nil {
+		r.SetMetadata(spider.reportMetadata)
+	}
+	if r, ok := spider.reporter.(reporter.DepthReporter); ok && spider.depthInReport {
+		r.SetShowDepth(true)
+	}
+	if r, ok := spider.reporter.(reporter.AssetHostReporter); ok && spider.externalAssetHosts {
+		r.SetExternalAssetHosts(spider.rootURL)
+	}
+
+	return spider
+}
+
+// Run the spider. Start at the root and follow all valid URLs, building a map
+// of the site.
+func (s *Spider) Run() error {
+	return s.RunContext(context.Background())
+}
+
+// RunContext runs the spider until it has seen every page, a page fails, or the context
+// is done, whichever comes first.
+func (s *Spider) RunContext(ctx context.Context) (err error) {
+	s.runCtx = ctx
+	start := time.Now()
+	defer s.queue.closeSpill()
+	defer func() {
+		stats := s.counters.snapshot()
+		stats.Duration = time.Since(start)
+		stats.Err = err
+		s.events.done(stats)
+		if s.onComplete != nil {
+			s.onComplete(stats)
+		}
+	}()
+
+	if s.statusAddr != "" {
+		stop, err := s.startStatusServer(s.statusAddr, start)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	if s.onProgress != nil && s.progressInterval > 0 {
+		defer s.startProgress(start)()
+	}
+
+	if s.loginURL != nil {
+		err := s.login(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if s.robots == nil && !s.ignoreRobots {
+		robots, err := s.readRobotsData(ctx, s.rootURL)
+		if err != nil {
+			return err
+		}
+		s.robots = robots
+	}
+	if s.robots != nil && !s.ignoreRobots && s.maxCrawlDelay > 0 {
+		s.crawlDelay = s.robots.FindGroup(s.robotsAgent()).CrawlDelay
+		if s.crawlDelay > s.maxCrawlDelay {
+			s.logger.Warn("Crawl delay in robots.txt is too long, capping it",
+				zap.Duration("crawlDelay", s.crawlDelay),
+				zap.Duration("max", s.maxCrawlDelay),
+			)
+			s.crawlDelay = s.maxCrawlDelay
+		}
+	}
+
+	var seeds []*url.URL
+	if s.seedFile != "" {
+		seeds, err = readSeedFile(s.seedFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	resumed := false
+	if s.autoSavePath != "" {
+		resumed, err = s.resume()
+		if err != nil {
+			return err
+		}
+		stop := s.startAutoSave()
+		defer func() {
+			stop(err)
+		}()
+	}
+	if !resumed {
+		if err := s.enqueueRoot(); err != nil {
+			return err
+		}
+	}
+	s.enqueueSeeds(seeds)
+	s.enqueueRequests(s.requests)
+
+	if s.seedFromSitemap {
+		if err := s.readSitemap(ctx); err != nil {
+			return err
+		}
+	}
+
+	workers := s.concurrency
+	if s.limiter != nil {
+		workers = s.limiter.max
+	}
+	pool := concurrency.NewWorkerPool(s.logger, workers, s.worker)
+	s.setPool(pool)
+	poolErr := make(chan error, 1)
+	go func() {
+		poolErr <- pool.Start()
+	}()
+
+	select {
+	case <-s.pending.Zero():
+		// We're done with all work, so drain the pool too. The last page may still have
+		// failed, in which case the pool stopped itself with that error.
+		pool.StopWait()
+		if err := <-poolErr; err != concurrency.Stopped {
+			return err
+		}
+		return s.probeAssets(ctx)
+	case err := <-poolErr:
+		// The pool only stops by itself when a worker fails. That may be because the
+		// context was cancelled while a request was in flight.
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	case <-ctx.Done():
+		pool.StopWait()
+		return ctx.Err()
+	}
+}
+
+// enqueueRoot adds our root to the queue to start us off.
+func (s *Spider) enqueueRoot() error {
+	root := s.normalize(s.rootURL)
+	if !s.ignoreRobots && !createShouldRequestByRobotsPredicate(s.robotsAgent(), s.robots)(root) {
+		return RootUnreachableError{URL: root, Err: ErrDisallowedByRobots}
+	}
+	s.enqueue(&queueItem{url: root, root: true})
+	return nil
+}
+
+// normalize rewrites the URL the same way wherever it was found, so that each page is only
+// crawled once however it is written.
+func (s *Spider) normalize(uri *url.URL) *url.URL {
+	if s.treatWWWAsSame {
+		uri = createWWWTransformer(s.rootURL)(uri)
+	}
+	if s.ignoreQueryStrings {
+		uri = removeQuery(uri)
+	}
+	return uri
+}
+
+// enqueueSeeds enqueues the seeds which should be crawled. Like the root, they have depth 0, but
+// otherwise they're filtered the same as links.
+func (s *Spider) enqueueSeeds(seeds []*url.URL) {
+	shouldCrawl := s.createCrawlFilter()
+	for _, seed := range seeds {
+		seed = s.normalize(seed)
+		if !shouldCrawl(newCrawlContext(seed, 0, nil)) {
+			continue
+		}
+		if s.enqueue(&queueItem{url: seed}) {
+			s.logger.Info("Enqueued link from seed file", zap.String("url", seed.String()))
+		}
+	}
+}
+
+// enqueueRequests enqueues the requests which should be crawled. They're normalised and
+// filtered like seeds, with the filters seeing each request's method.
+func (s *Spider) enqueueRequests(requests []Request) {
+	shouldCrawl := s.createCrawlFilter()
+	for _, req := range requests {
+		req.URL = s.normalize(req.URL)
+		ctx := newCrawlContext(req.URL, 0, nil)
+		ctx.Method = req.method()
+		if !shouldCrawl(ctx) {
+			continue
+		}
+		if s.enqueue(&queueItem{url: req.URL, method: req.Method, body: req.Body}) {
+			s.logger.Info("Enqueued request", zap.String("method", ctx.Method), zap.String("url", req.URL.String()))
+		}
+	}
+}
+
+// Pause stops the spider from fetching any more pages until Resume is called. Pages which
+// are already being fetched are allowed to finish, and the queue is kept. It is safe to call
+// Pause before Run, in which case the spider starts paused.
+func (s *Spider) Pause() {
+	s.poolLock.Lock()
+	defer s.poolLock.Unlock()
+	s.paused = true
+	if s.pool != nil {
+		s.pool.Pause()
+	}
+}
+
+// Resume continues a crawl which was paused.
+func (s *Spider) Resume() {
+	s.poolLock.Lock()
+	defer s.poolLock.Unlock()
+	s.paused = false
+	if s.pool != nil {
+		s.pool.Resume()
+	}
+}
+
+// setPool records the pool running the crawl so that it can be paused.
+func (s *Spider) setPool(pool *concurrency.WorkerPool) {
+	s.poolLock.Lock()
+	defer s.poolLock.Unlock()
+	s.pool = pool
+	if s.paused {
+		pool.Pause()
+	}
+}
+
+// Report writes the report to the writer.
+func (s *Spider) Report(w io.Writer) error {
+	return s.reporter.Report(w)
+}
+
+// work is the function used by the worker in the pool. Each worker will poll the URL queue
+// for items. If a URL is found, it will crawl it and record the outcome.
+func (s *Spider) work() error {
+	if !s.limiter.acquire() {
+		time.Sleep(workerPollInterval)
+		return nil
+	}
+	next := s.queue.Next()
+	if next == nil {
+		s.limiter.release()
+		if err := s.queue.spillError(); err != nil {
+			return err
+		}
+		time.Sleep(workerPollInterval)
+		return nil
+	}
+	defer func() {
+		// Pages interrupted by cancelling the crawl are left pending, so a resumed crawl
+		// fetches them. Every other page is finished with, even if it failed.
+		if s.runCtx.Err() == nil {
+			s.queue.Done(next)
+		}
+	}()
+	s.logger.Info("Items left in queue", zap.Int("number", s.queue.Len()))
+	defer s.pending.Done()
+	if s.byteLimitReached() {
+		// Drop the rest of the queue without fetching it so that the crawl finishes.
+		s.limiter.release()
+		return nil
+	}
+
+	done := s.counters.startPage()
+	defer done()
+	err := s.crawl(next)
+	s.limiter.release()
+	if _, ok := err.(resultSinkError); ok {
+		// The page was crawled but couldn't be stored. That stops the crawl, but it isn't the
+		// page failing, so it isn't counted or reported as one.
+		s.counters.addPage()
+		return err
+	}
+	if err != nil {
+		s.counters.addError()
+		s.events.error(next.url, err)
+		if s.onError != nil {
+			cause := err
+			if retryable, ok := err.(concurrency.RetryableError); ok {
+				cause = retryable.Err
+			}
+			s.onError(next.url, cause)
+		}
+		if next.root {
+			return newRootUnreachableError(next.url, err)
+		}
+		return err
+	}
+	s.counters.addPage()
+	return nil
+}
+
+// crawl collects the links/assets for the URL, reports them, and enqueues any links
+// which should be crawled next.
+func (s *Spider) crawl(item *queueItem) error {
+	next := item.url
+	req := item.request()
+	req.Header = s.requestHeader(next)
+	if !req.idempotent() && s.idempotencyKeyHeader != "" {
+		req.Header = cloneHeader(req.Header)
+		req.Header.Set(s.idempotencyKeyHeader, req.idempotencyKey())
+	}
+
+	var page fetchedPage
+	err := s.withRetries(req, func() error {
+		s.delay(next)
+		ctx, cancel := context.WithTimeout(s.runCtx, s.timeoutFor(next))
+		defer cancel()
+
+		start := time.Now()
+		var err error
+		page, err = s.fetch(ctx, req)
+		latency := time.Since(start)
+		s.latencies.set(next.Hostname(), latency)
+		s.limiter.record(err, latency)
+		return err
+	})
+	if err != nil {
+		// A page which redirects in a loop is broken, but the rest of the site may be fine.
+		if loopErr, ok := err.(redirectLoopError); ok {
+			s.logger.Warn("Page redirects in a loop", zap.String("url", next.String()), zap.Error(err))
+			s.reportFailure(reporter.Failure{
+				URL:           next,
+				Category:      reporter.FailureRedirectLoop,
+				Error:         err.Error(),
+				RedirectChain: loopErr.chain,
+			})
+			return concurrency.NewRetryableError(err)
+		}
+		// Likewise a host which doesn't exist, which is common when following subdomains.
+		if isDNSError(err) {
+			s.logger.Warn("Host could not be resolved", zap.String("url", next.String()), zap.Error(err))
+			s.reportFailure(reporter.Failure{
+				URL:      next,
+				Category: reporter.FailureUnreachableHost,
+				Error:    err.Error(),
+			})
+			return concurrency.NewRetryableError(err)
+		}
+		// A URL which kept failing after being retried shouldn't stop the rest of the crawl.
+		if s.maxRetryDuration > 0 && s.shouldRetry(err) {
+			return concurrency.NewRetryableError(err)
+		}
+		return err
+	}
+	results, headers, latency, soft404 := page.results, page.headers, page.latency, page.soft404
+	s.counters.addBytes(page.size)
+	if soft404 {
+		s.logger.Warn("Page looks like a soft 404", zap.String("url", next.String()))
+	}
+
+	// TODO: Move these predicates out of the work function
+	onlyInternal := s.createIsInternalPredicate()
+	asAbsolute := createAbsoluteTransformer(s.rootURL)
+
+	if s.skipFragmentOnlyLinks {
+		results.Links = filter(createOtherPagePredicate(next), results.Links)
+	}
+
+	// Pages often link to the same URL many times, so dedup before doing any more work.
+	absoluteLinks := mapURLs(s.normalize, mapURLs(asAbsolute, results.Links))
+	if s.upgradeInsecureLinks && s.rootURL.Scheme == "https" {
+		absoluteLinks = mapURLs(createUpgradeTransformer(onlyInternal), absoluteLinks)
+	}
+	// The transforms keep links in order, so they still line up with the parsed links.
+	var anchorText map[string]string
+	if len(s.focusKeywords) > 0 {
+		anchorText = make(map[string]string)
+		for i, link := range results.Links {
+			if text := results.AnchorText[link.String()]; text != "" {
+				anchorText[absoluteLinks[i].String()] += " " + text
+			}
+		}
+	}
+	absoluteLinks = unique(absoluteLinks)
+	internalLinks := filter(onlyInternal, absoluteLinks)
+
+	assets := uniqueAssets(results.AssetURLs())
+	if s.maxAssetsPerPage > 0 && len(assets) > s.maxAssetsPerPage {
+		s.logger.Warn("Too many assets on page, truncating",
+			zap.String("url", next.String()),
+			zap.Int("assets", len(assets)),
+		)
+		assets = assets[:s.maxAssetsPerPage]
+	}
+
+	// Report all links before we filter out the ones we need to fetch.
+	info := reporter.PageInfo{
+		URL:           next,
+		Method:        item.method,
+		Body:          item.body,
+		Links:         internalLinks,
+		Assets:        assets,
+		Soft404:       soft404,
+		Latency:       latency,
+		Slow:          s.slowPageThreshold > 0 && latency > s.slowPageThreshold,
+		ParseDuration: page.parseDuration,
+		FromSitemap:   s.sitemapURLs[s.queue.urlKey(next)],
+		StatusCode:    page.status,
+		Size:          page.size,
+		Depth:         item.depth,
+		Referrer:      item.referrer,
+		MixedContent:  mixedContent(next, assets),
+		HTML:          page.html,
+		AssetKinds:    s.assetKinds(results.Assets, assets),
+		Title:         results.Title,
+		Description:   results.Description,
+		Canonical:     resolveCanonical(next, results.Canonical),
+		Cache: reporter.CacheHeaders{
+			CacheControl: headers.Get("Cache-Control"),
+			ETag:         headers.Get("ETag"),
+			Expires:      headers.Get("Expires"),
+		},
+		ThirdPartyHosts: thirdPartyHosts(next, results.PreconnectHosts),
+		LinkCount:       len(absoluteLinks),
+		ManyLinks:       s.highLinkThreshold > 0 && len(absoluteLinks) > s.highLinkThreshold,
+		NearDuplicateOf: s.nearDuplicates.match(next, results.SimHash),
+	}
+	if s.redirectChains {
+		info.RedirectChain = reportRedirects(page.redirects)
+	}
+	if page.html {
+		info.TextLength = results.TextLength
+		info.Thin = s.minContentLength > 0 && results.TextLength < s.minContentLength
+	}
+	if s.respectMetaRobots && results.NoIndex {
+		s.logger.Info("Page asks not to be indexed, not reporting it", zap.String("url", next.String()))
+	} else {
+		if err := s.addPage(info); err != nil {
+			return err
+		}
+		if lastMod, ok := s.sitemapLastMods[s.queue.urlKey(next)]; ok && s.lastModStore != nil {
+			s.lastModStore.Put(info, lastMod)
+		}
+	}
+	s.logger.Info("Found links", zap.Int("links", len(internalLinks)))
+	for _, link := range internalLinks {
+		s.events.linkFound(next, link)
+	}
+
+	if s.respectMetaRobots && results.NoFollow {
+		s.logger.Info("Page asks for its links not to be followed", zap.String("url", next.String()))
+		return nil
+	}
+	if s.skipNearDuplicateLinks && info.NearDuplicateOf != nil {
+		s.logger.Info("Page is a near duplicate, not following its links",
+			zap.String("url", next.String()),
+			zap.String("duplicateOf", info.NearDuplicateOf.String()),
+		)
+		return nil
+	}
+	if s.singlePage {
+		return nil
+	}
+	if s.byteLimitReached() {
+		s.logger.Warn("Byte limit reached, not enqueuing any more links",
+			zap.Int64("bytes", s.counters.totalBytes()),
+		)
+		return nil
+	}
+	s.enqueueLinks(internalLinks, item, anchorText)
+	if s.submitForms {
+		s.enqueueForms(results.Forms, item)
+	}
+	return nil
+}
+
+// assetKinds returns the kinds of the reported assets, or nil if assets aren't being classified.
+func (s *Spider) assetKinds(parsed []parser.Asset, reported []string) map[string]string {
+	if !s.classifyAssets {
+		return nil
+	}
+	kinds := make(map[string]string, len(reported))
+	for _, asset := range reported {
+		kinds[asset] = ""
+	}
+	// Use the first kind found for an asset which appears more than once.
+	for _, asset := range parsed {
+		if kind, ok := kinds[asset.URL]; ok && kind == "" {
+			kinds[asset.URL] = asset.Kind
+		}
+	}
+	return kinds
+}
+
+// focusScore returns how many of the focus keywords are in the link's path or anchor text.
+func (s *Spider) focusScore(link *url.URL, anchorText string) int {
+	if len(s.focusKeywords) == 0 {
+		return 0
+	}
+	path := strings.ToLower(link.Path)
+	anchorText = strings.ToLower(anchorText)
+	score := 0
+	for _, keyword := range s.focusKeywords {
+		if strings.Contains(path, keyword) || strings.Contains(anchorText, keyword) {
+			score++
+		}
+	}
+	return score
+}
+
+// byteLimitReached returns true if the pages fetched so far have used up WithMaxTotalBytes.
+func (s *Spider) byteLimitReached() bool {
+	return s.maxTotalBytes > 0 && s.counters.totalBytes() >= s.maxTotalBytes
+}
+
+// shuffle returns the links in a random order.
+func (s *Spider) shuffle(links []*url.URL) []*url.URL {
+	s.linkRandLock.Lock()
+	order := s.linkRand.Perm(len(links))
+	s.linkRandLock.Unlock()
+
+	shuffled := make([]*url.URL, len(links))
+	for i, j := range order {
+		shuffled[i] = links[j]
+	}
+	return shuffled
+}
+
+// sampled returns true if a link should be crawled under WithSampleRate.
+func (s *Spider) sampled() bool {
+	if s.sampleRand == nil {
+		return true
+	}
+	s.sampleRandLock.Lock()
+	defer s.sampleRandLock.Unlock()
+	return s.sampleRand.Float64() < s.sampleRate
+}
+
+// requesterFor returns the requester for the URL's host.
+func (s *Spider) requesterFor(uri *url.URL) Requester {
+	if s.requesterFactory == nil {
+		return s.requester
+	}
+	host := strings.ToLower(uri.Host)
+	s.hostRequestersLock.Lock()
+	defer s.hostRequestersLock.Unlock()
+	if s.hostRequesters == nil {
+		s.hostRequesters = make(map[string]Requester)
+	}
+	requester, ok := s.hostRequesters[host]
+	if !ok {
+		requester = s.requesterFactory(host)
+		s.hostRequesters[host] = requester
+	}
+	return requester
+}
+
+// timeoutFor returns the request timeout for the URL's host.
+func (s *Spider) timeoutFor(uri *url.URL) time.Duration {
+	if timeout, ok := s.hostTimeouts[strings.ToLower(uri.Hostname())]; ok {
+		return timeout
+	}
+	return s.requestTimeout
+}
+
+// enqueueLinks enqueues the links found on the item's page which should be crawled. The anchor
+// text of each link is only needed for WithFocusKeywords.
+func (s *Spider) enqueueLinks(links []*url.URL, item *queueItem, anchorText map[string]string) {
+	if s.linkRand != nil {
+		links = s.shuffle(links)
+	}
+	shouldCrawl := s.createCrawlFilter()
+	for _, link := range links {
+		if !shouldCrawl(newCrawlContext(link, item.depth+1, item.url)) {
+			continue
+		}
+		if !s.sampled() {
+			s.queue.MarkSeen(link)
+			continue
+		}
+		next := &queueItem{
+			url:      link,
+			depth:    item.depth + 1,
+			referrer: item.url,
+			priority: s.focusScore(link, anchorText[link.String()]),
+		}
+		if s.enqueue(next) {
+			s.logger.Info("Enqueued link to fetch", zap.String("url", link.String()))
+		}
+	}
+}
+
+// enqueueForms enqueues the POST forms found on the item's page which should be submitted.
+// Forms without an action submit to the page they're on.
+func (s *Spider) enqueueForms(forms []parser.Form, item *queueItem) {
+	onlyInternal := s.createIsInternalPredicate()
+	asAbsolute := createAbsoluteTransformer(s.rootURL)
+	shouldCrawl := s.createCrawlFilter()
+	for _, form := range forms {
+		if form.Method != http.MethodPost {
+			continue
+		}
+		action := item.url
+		if form.Action.String() != "" {
+			action = s.normalize(asAbsolute(form.Action))
+		}
+		if !onlyInternal(action) {
+			continue
+		}
+		ctx := newCrawlContext(action, item.depth+1, item.url)
+		ctx.Method = form.Method
+		if !shouldCrawl(ctx) {
+			continue
+		}
+		next := &queueItem{url: action, depth: item.depth + 1, referrer: item.url, method: form.Method, body: form.Values}
+		if s.enqueue(next) {
+			s.logger.Info("Enqueued form to submit", zap.String("url", action.String()))
+		}
+	}
+}
+
+// fetchedPage is what we learnt from fetching a page.
+type fetchedPage struct {
+	results parser.Results
+	status  int
+	headers http.Header
+	latency time.Duration
+	size    int64
+	soft404 bool
+	// html is true if the page was parsed.
+	html bool
+	// parseDuration doesn't include time spent waiting for the body.
+	parseDuration time.Duration
+	// redirects is every hop taken to reach the page, or nil if it wasn't redirected.
+	redirects []redirectHop
+}
+
+// reportRedirects converts the redirect hops for the report.
+func reportRedirects(chain []redirectHop) []reporter.RedirectHop {
+	if chain == nil {
+		return nil
+	}
+	hops := make([]reporter.RedirectHop, len(chain))
+	for i, hop := range chain {
+		hops[i] = reporter.RedirectHop{URL: hop.url, StatusCode: hop.statusCode}
+	}
+	return hops
+}
+
+// fetch requests the page and parses it. When nothing needs to look at the whole body, it is
+// tokenized straight from the response so large pages are never held in memory. Otherwise it is
+// read into a pooled buffer, which nothing may hold on to after fetch returns.
+func (s *Spider) fetch(ctx context.Context, req Request) (fetchedPage, error) {
+	if !s.lenientParsing && s.soft404Matcher == nil {
+		return s.fetchStreaming(ctx, req)
+	}
+
+	uri := req.URL
+	start := time.Now()
+	buf, res, err := doPooled(ctx, s.requesterFor(req.URL), req)
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	defer putBody(buf)
+	page := fetchedPage{
+		status:    res.StatusCode,
+		headers:   res.Header,
+		latency:   time.Since(start),
+		size:      int64(buf.Len()),
+		redirects: redirectChain(res),
+	}
+	body := buf.Bytes()
+	s.events.pageFetched(uri)
+	peek := body
+	if len(peek) > sniffLen {
+		peek = peek[:sniffLen]
+	}
+	if !s.isHTML(uri, res.Header, peek) {
+		return page, nil
+	}
+
+	if s.maxParseBytes > 0 && len(body) > s.maxParseBytes {
+		s.logger.Warn("Page too big, only parsing the start of it", zap.String("url", uri.String()))
+		body = body[:s.maxParseBytes]
+	}
+	release := s.acquireParse()
+	parseStart := time.Now()
+	page.results, err = s.parse(uri, body)
+	page.parseDuration = time.Since(parseStart)
+	release()
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	page.html = true
+	page.soft404 = s.soft404Matcher != nil && s.soft404Matcher(body)
+	return page, nil
+}
+
+// fetchStreaming requests the page and tokenizes the body as it is read. The latency
+// includes parsing, since the two overlap, but the parse duration excludes time spent
+// waiting to read the body.
+func (s *Spider) fetchStreaming(ctx context.Context, req Request) (fetchedPage, error) {
+	uri := req.URL
+	start := time.Now()
+	res, err := req.do(ctx, s.requesterFor(req.URL))
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	defer res.Body.Close()
+
+	counter := &countingReader{reader: res.Body}
+	body := bufio.NewReader(counter)
+	// Peek only returns an error if the body is shorter than sniffLen, which is fine.
+	peek, _ := body.Peek(sniffLen)
+	if !s.isHTML(uri, res.Header, peek) {
+		s.events.pageFetched(uri)
+		// We don't read the rest of the body, so only the server knows how big it is.
+		size := res.ContentLength
+		if size < 0 {
+			size = counter.count
+		}
+		return fetchedPage{
+			status:    res.StatusCode,
+			headers:   res.Header,
+			latency:   time.Since(start),
+			size:      size,
+			redirects: redirectChain(res),
+		}, nil
+	}
+
+	var parsed io.Reader = body
+	var limited *io.LimitedReader
+	if s.maxParseBytes > 0 {
+		limited = &io.LimitedReader{R: body, N: int64(s.maxParseBytes)}
+		parsed = limited
+	}
+	release := s.acquireParse()
+	parseStart, readStart := time.Now(), counter.readTime
+	results, err := s.tokenParser.ParseReader(parsed)
+	parseDuration := time.Since(parseStart) - (counter.readTime - readStart)
+	release()
+	if err != nil {
+		return fetchedPage{}, err
+	}
+	if limited != nil && limited.N == 0 {
+		s.logger.Warn("Page too big, only parsed the start of it", zap.String("url", uri.String()))
+	}
+	s.events.pageFetched(uri)
+	return fetchedPage{
+		results:       results,
+		status:        res.StatusCode,
+		headers:       res.Header,
+		latency:       time.Since(start),
+		size:          counter.count,
+		html:          true,
+		redirects:     redirectChain(res),
+		parseDuration: parseDuration,
+	}, nil
+}
+
+// acquireParse waits until a page can be parsed without going over WithMaxConcurrentParses,
+// and returns a function to call once parsing is done.
+func (s *Spider) acquireParse() func() {
+	if s.parseSlots == nil {
+		return func() {}
+	}
+	s.parseSlots <- struct{}{}
+	return func() {
+		<-s.parseSlots
+	}
+}
+
+// countingReader counts the bytes read through it, and how long reading took.
+type countingReader struct {
+	reader   io.Reader
+	count    int64
+	readTime time.Duration
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.reader.Read(p)
+	r.readTime += time.Since(start)
+	r.count += int64(n)
+	return n, err
+}
+
+// isHTML decides from the Content-Type header whether a page should be parsed. If the header
+// is missing or generic, the page is assumed to be HTML unless sniffing is enabled, in which
+// case the type is detected from the start of the body.
+func (s *Spider) isHTML(uri *url.URL, headers http.Header, start []byte) bool {
+	mediaType, _, err := mime.ParseMediaType(headers.Get("Content-Type"))
+	if err != nil || mediaType == genericContentType {
+		if !s.sniffContentType {
+			return true
+		}
+		mediaType, _, _ = mime.ParseMediaType(http.DetectContentType(start))
+	}
+
+	html := mediaType == "text/html" || mediaType == "application/xhtml+xml"
+	if !html {
+		s.logger.Info("Not parsing non HTML page", zap.String("url", uri.String()), zap.String("type", mediaType))
+	}
+	return html
+}
+
+// enqueue adds the link to the queue unless it has already been seen. The pending count is
+// incremented first so that it can't reach zero if another worker finishes the link
+// before we return.
+func (s *Spider) enqueue(item *queueItem) bool {
+	s.pending.Add(1)
+	if !s.queue.AppendUnseen(item) {
+		s.pending.Done()
+		return false
+	}
+	return true
+}
+
+// robotsAgent returns the token robots.txt rules are matched against.
+func (s *Spider) robotsAgent() string {
+	if s.robotsUserAgent != "" {
+		return s.robotsUserAgent
+	}
+	return productToken(s.userAgent)
+}
+
+// createIsInternalPredicate creates the predicate which decides whether a link is part of the site.
+func (s *Spider) createIsInternalPredicate() urlPredicate {
+	internal := createIsInternalPredicate(s.rootURL, s.followSubdomains)
+	if len(s.allowedHosts) == 0 && len(s.deniedHosts) == 0 {
+		return internal
+	}
+	return createHostListPredicate(internal, s.allowedHosts, s.deniedHosts)
+}
+
+// createCrawlFilter creates the filter which decides whether a found link is crawled. It
+// skips links we've already seen, that aren't allowed by the robots.txt file, that have an
+// excluded extension or that are too deep, and then applies any filters added with WithCrawlFilter.
+func (s *Spider) createCrawlFilter() CrawlFilter {
+	notSeen := createNotSeenPredicate(s.queue)
+	filters := []CrawlFilter{
+		// Only GET requests are seen by URL alone. Others are deduplicated when enqueued.
+		func(ctx CrawlContext) bool {
+			return ctx.Method != http.MethodGet || notSeen(ctx.URL)
+		},
+		fromURLPredicate(createShouldRequestByRobotsPredicate(s.robotsAgent(), s.robots)),
+		fromURLPredicate(createExcludeExtensionsPredicate(s.excludeExtensions)),
+	}
+	if s.maxDepth >= 0 {
+		filters = append(filters, func(ctx CrawlContext) bool {
+			return ctx.Depth <= s.maxDepth
+		})
+	}
+	if s.subdomainDepth >= 0 {
+		subdomain := "." + strings.ToLower(s.rootURL.Hostname())
+		filters = append(filters, func(ctx CrawlContext) bool {
+			return ctx.Depth <= s.subdomainDepth || !strings.HasSuffix(strings.ToLower(ctx.URL.Hostname()), subdomain)
+		})
+	}
+	if s.maxQueryKeys > 0 {
+		filters = append(filters, fromURLPredicate(createMaxQueryKeysPredicate(s.maxQueryKeys)))
+	}
+	return allFilters(append(filters, s.crawlFilters...)...)
+}
+
+// parse extracts links and assets from the body. The tokenizer gives up quietly on badly broken
+// markup (e.g. an unterminated comment), so if lenient parsing is enabled and it finds no links in
+// a substantial body, we retry with the regex parser.
+func (s *Spider) parse(uri *url.URL, body []byte) (parser.Results, error) {
+	results, err := s.tokenParser.Parse(body)
+	if err != nil || !s.lenientParsing {
+		return results, err
+	}
+	if len(results.Links) == 0 && len(body) >= lenientParseMinBody {
+		s.logger.Warn("No links found by tokenizer, falling back to lenient parsing",
+			zap.String("url", uri.String()))
+		return parser.ByRegex(body)
+	}
+	return results, nil
+}
+
+// login submits the login form so the session is used for the rest of the crawl.
+func (s *Spider) login(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+
+	res, err := s.requesterFor(s.loginURL).Do(ctx, http.MethodPost, s.loginURL,
+		strings.NewReader(s.loginForm.Encode()),
+		http.Header{"Content-Type": {formContentType}},
+	)
+	if err != nil {
+		return errors.Wrap(err, "login failed")
+	}
+	return res.Body.Close()
+}
+
+// readSitemap fetches the sitemap and enqueues every page in it which we are allowed to crawl.
+// A missing or broken sitemap is logged rather than stopping the crawl, so it only returns an
+// error if the result sink fails.
+func (s *Spider) readSitemap(ctx context.Context) error {
+	sitemapURL := s.rootURL.ResolveReference(sitemapPath)
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout)
+	defer cancel()
+
+	body, err := Get(ctx, s.requesterFor(sitemapURL), sitemapURL)
+	s.reportResource(sitemapURL, err)
+	if err != nil {
+		s.logger.Warn("Failed to fetch sitemap", zap.String("url", sitemapURL.String()), zap.Error(err))
+		return nil
+	}
+	entries, err := parser.SitemapEntries(body)
+	if err != nil {
+		s.logger.Warn("Failed to parse sitemap", zap.String("url", sitemapURL.String()), zap.Error(err))
+		return nil
+	}
+
+	onlyInternal := s.createIsInternalPredicate()
+	shouldCrawl := s.createCrawlFilter()
+
+	urls := make([]*url.URL, len(entries))
+	for i, entry := range entries {
+		urls[i] = s.normalize(entry.URL)
+		s.sitemapURLs[s.queue.urlKey(urls[i])] = true
+		if !entry.LastMod.IsZero() {
+			s.sitemapLastMods[s.queue.urlKey(urls[i])] = entry.LastMod
+		}
+	}
+	internalURLs := filter(onlyInternal, unique(urls))
+	if s.reportSitemap {
+		err := s.addPage(reporter.PageInfo{
+			URL:        sitemapURL,
+			Links:      internalURLs,
+			StatusCode: http.StatusOK,
+			Size:       int64(len(body)),
+			IsSitemap:  true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Sitemap links are treated as if they were linked from the root page.
+	for _, link := range internalURLs {
+		if !shouldCrawl(newCrawlContext(link, 1, sitemapURL)) {
+			continue
+		}
+		item := &queueItem{url: link, depth: 1, referrer: sitemapURL}
+		skipped, err := s.skipUnchanged(item)
+		if err != nil {
+			return err
+		}
+		if skipped {
+			continue
+		}
+		if s.enqueue(item) {
+			s.logger.Info("Enqueued link from sitemap", zap.String("url", link.String()))
+		}
+	}
+	return nil
+}
+
+// skipUnchanged checks whether the item's page is unchanged since it was stored by a previous
+// crawl. If so, the page is marked as seen and its stored result is reported instead, and the
+// links it had are followed as if it had been crawled. It returns an error if the result sink
+// fails to take the page.
+func (s *Spider) skipUnchanged(item *queueItem) (bool, error) {
+	if s.lastModStore == nil {
+		return false, nil
+	}
+	lastMod, ok := s.sitemapLastMods[s.queue.urlKey(item.url)]
+	if !ok {
+		return false, nil
+	}
+	page, storedLastMod, ok := s.lastModStore.Get(item.url)
+	if !ok || lastMod.After(storedLastMod) {
+		return false, nil
+	}
+
+	s.logger.Info("Skipping unchanged page", zap.String("url", item.url.String()))
+	s.queue.MarkSeen(item.url)
+	page.FromSitemap = true
+	page.Depth = item.depth
+	page.Referrer = item.referrer
+	if err := s.addPage(page); err != nil {
+		return false, err
+	}
+	s.enqueueLinks(page.Links, item, nil)
+	return true, nil
+}
+
+// addPage reports a crawled page. It returns an error if the result sink fails to take it.
+func (s *Spider) addPage(page reporter.PageInfo) error {
+	page = s.limitResults(page)
+	if s.probedAssets != nil {
+		s.probedAssets.add(page.URL, page.Assets)
+	}
+	s.reporter.Add(page)
+	if s.onReport != nil {
+		s.onReport(page)
+	}
+	if s.resultSink != nil {
+		if err := s.resultSink.Consume(page); err != nil {
+			return resultSinkError{err}
+		}
+	}
+	return nil
+}
+
+// limitResults truncates the page's links and then its assets to whatever is left of the
+// result limit.
+func (s *Spider) limitResults(page reporter.PageInfo) reporter.PageInfo {
+	if s.resultLimit <= 0 {
+		return page
+	}
+	s.storedResultsLock.Lock()
+	defer s.storedResultsLock.Unlock()
+
+	remaining := s.resultLimit - s.storedResults
+	if len(page.Links)+len(page.Assets) <= remaining {
+		s.storedResults += len(page.Links) + len(page.Assets)
+		return page
+	}
+	if s.storedResults < s.resultLimit {
+		s.logger.Warn("Result limit reached, truncating links and assets",
+			zap.String("url", page.URL.String()),
+			zap.Int("limit", s.resultLimit),
+		)
+	}
+	// Copy what's kept so the truncated results don't hold on to the rest.
+	page.ResultsTruncated = true
+	if len(page.Links) > remaining {
+		page.Links = append([]*url.URL(nil), page.Links[:remaining]...)
+	}
+	remaining -= len(page.Links)
+	if len(page.Assets) > remaining {
+		page.Assets = append([]string(nil), page.Assets[:remaining]...)
+	}
+	s.storedResults = s.resultLimit
+	if page.AssetKinds != nil {
+		kinds := make(map[string]string, len(page.Assets))
+		for _, asset := range page.Assets {
+			kinds[asset] = page.AssetKinds[asset]
+		}
+		page.AssetKinds = kinds
+	}
+	return page
+}
+
+// reportResource tells the reporter about a site wide file we fetched, if it's interested.
+func (s *Spider) reportResource(uri *url.URL, err error) {
+	r, ok := s.reporter.(reporter.ResourceReporter)
+	if !ok {
+		return
+	}
+
+	resource := reporter.Resource{
+		URL:        uri,
+		StatusCode: http.StatusOK,
+	}
+	if err != nil {
+		resource.StatusCode = 0
+		resource.Error = err.Error()
+		if httpErr, ok := err.(httpResponseError); ok {
+			resource.StatusCode = httpErr.statusCode
+		}
+	}
+	r.AddResource(resource)
+}
+
+// reportFailure tells the reporter about a page which couldn't be crawled, if it's interested.
+func (s *Spider) reportFailure(failure reporter.Failure) {
+	r, ok := s.reporter.(reporter.FailureReporter)
+	if !ok {
+		return
+	}
+	r.AddFailure(failure)
 }
 
 // readRobotsData makes a request to the root + /robots.txt and parses the data.
 // In the event of a 4XX, we assume crawling is allowed. In the event of a 5XX,
 // we assume it is disallowed.
-func (s *Spider) readRobotsData(root *url.URL) (*robotstxt.RobotsData, error) {
+func (s *Spider) readRobotsData(ctx context.Context, root *url.URL) (*robotstxt.RobotsData, error) {
 	robotsURL := root.ResolveReference(robotsTxtPath)
-	ctx, cancel := context.WithTimeout(context.Background(), s.requestTimeout)
+	ctx, cancel := context.WithTimeout(ctx, s.requestTimeout)
 	defer cancel()
 
-	res, err := s.requester.Request(ctx, robotsURL)
+	res, err := Get(ctx, s.requesterFor(robotsURL), robotsURL)
+	s.reportResource(robotsURL, err)
 	if err != nil {
 		httpErr, ok := err.(httpResponseError)
 		if ok {
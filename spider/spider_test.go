@@ -1,26 +1,88 @@
 package spider
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/Willyham/gospider/spider/internal/concurrency"
 	"github.com/Willyham/gospider/spider/mocks"
+	"github.com/Willyham/gospider/spider/reporter"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 var willydURL, _ = url.Parse("http://willdemaine.co.uk")
 var willydRobots, _ = url.Parse("http://willdemaine.co.uk/robots.txt")
+var willydFoo, _ = url.Parse("http://willdemaine.co.uk/foo")
+var willydBar, _ = url.Parse("http://willdemaine.co.uk/bar")
+
+// onGet sets up an expected GET request on the mock requester.
+func onGet(r *mocks.Requester, uri *url.URL) *mock.Call {
+	return r.On("Do", mock.Anything, http.MethodGet, uri, mock.Anything, mock.Anything)
+}
+
+// respond creates a mock return value which responds with the body each time it's called.
+func respond(body []byte) func(context.Context, string, *url.URL, io.Reader, http.Header) *http.Response {
+	return func(context.Context, string, *url.URL, io.Reader, http.Header) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		}
+	}
+}
+
+// queuedURLs returns the URLs waiting in the queue.
+func queuedURLs(q *urlQueue) []string {
+	urls := make([]string, len(q.items))
+	for i, item := range q.items {
+		urls[i] = item.url.String()
+	}
+	return urls
+}
+
+// recordingReporter is a reporter which records every page it is given.
+type recordingReporter struct {
+	pages []reporter.PageInfo
+	sync.Mutex
+}
+
+func (r *recordingReporter) Add(page reporter.PageInfo) {
+	r.Lock()
+	r.pages = append(r.pages, page)
+	r.Unlock()
+}
+
+func (r *recordingReporter) Report(io.Writer) error {
+	return nil
+}
 
 func TestReadRobotsData(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydRobots).Return([]byte(`
+	onGet(requester, willydRobots).Return(respond([]byte(`
 		User-agent: *
 		Disallow: /foo/
 		Disallow: /bar/
-	`), nil)
+	`)), nil)
 
 	s := New(
 		WithRoot(willydURL),
@@ -28,7 +90,7 @@ func TestReadRobotsData(t *testing.T) {
 		WithUserAgent("agent"),
 	)
 
-	data, err := s.readRobotsData(willydURL)
+	data, err := s.readRobotsData(context.Background(), willydURL)
 	assert.NoError(t, err)
 	assert.True(t, data.TestAgent("/", "Agent"))
 	assert.False(t, data.TestAgent("/foo/a", "Agent"))
@@ -45,7 +107,7 @@ func TestNoRoot(t *testing.T) {
 
 func TestReadRobotsDataHTTPError(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydRobots).Return([]byte{}, httpResponseError{
+	onGet(requester, willydRobots).Return(nil, httpResponseError{
 		statusCode: 500,
 	})
 
@@ -54,27 +116,27 @@ func TestReadRobotsDataHTTPError(t *testing.T) {
 		WithRequester(requester),
 	)
 
-	data, err := s.readRobotsData(willydURL)
+	data, err := s.readRobotsData(context.Background(), willydURL)
 	assert.NoError(t, err)
 	assert.False(t, data.TestAgent("/", "Foo"))
 }
 
 func TestReadRobotsDataError(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydRobots).Return([]byte{}, assert.AnError)
+	onGet(requester, willydRobots).Return(nil, assert.AnError)
 
 	s := New(
 		WithRoot(willydURL),
 		WithRequester(requester),
 	)
 
-	_, err := s.readRobotsData(willydURL)
+	_, err := s.readRobotsData(context.Background(), willydURL)
 	assert.Error(t, err)
 }
 
 func TestReadRobotsDataMissing(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydRobots).Return([]byte{}, httpResponseError{
+	onGet(requester, willydRobots).Return(nil, httpResponseError{
 		statusCode: 404,
 	})
 
@@ -83,23 +145,23 @@ func TestReadRobotsDataMissing(t *testing.T) {
 		WithRequester(requester),
 	)
 
-	data, err := s.readRobotsData(willydURL)
+	data, err := s.readRobotsData(context.Background(), willydURL)
 	assert.NoError(t, err)
 	assert.True(t, data.TestAgent("/", "Foo"))
 }
 
 func TestWorkerNoItems(t *testing.T) {
 	s := New(WithRoot(willydURL))
-	s.wg.Add(1)
+	s.pending.Add(1)
 	err := s.work()
 	assert.NoError(t, err)
 }
 
 func TestWorker(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydURL).Return([]byte(`
+	onGet(requester, willydURL).Return(respond([]byte(`
 		<a href="/foo/bar"></a>
-	`), nil)
+	`)), nil)
 
 	s := New(
 		WithRoot(willydURL),
@@ -110,31 +172,228 @@ func TestWorker(t *testing.T) {
 	)
 	s.queue.Append(willydURL)
 
-	s.wg.Add(1)
+	s.pending.Add(1)
+	err := s.work()
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"http://willdemaine.co.uk/foo/bar"}, queuedURLs(s.queue))
+}
+
+func TestWorkerRepeatedLinks(t *testing.T) {
+	body := strings.Repeat(`<a href="/foo/bar"></a>`, 50)
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(body)), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+	)
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
 	err := s.work()
 	assert.NoError(t, err)
 
-	assert.Len(t, s.queue.urls, 1)
-	assert.Equal(t, "http://willdemaine.co.uk/foo/bar", s.queue.urls[0].String())
+	assert.Equal(t, []string{"http://willdemaine.co.uk/foo/bar"}, queuedURLs(s.queue))
+}
+
+func TestWorkerPagination(t *testing.T) {
+	body, err := ioutil.ReadFile("./internal/parser/testdata/pagination.html")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond(body), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+	)
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err = s.work()
+	assert.NoError(t, err)
+
+	queued := queuedURLs(s.queue)
+	assert.Contains(t, queued, "http://willdemaine.co.uk/posts/page/3/")
+	assert.Contains(t, queued, "http://willdemaine.co.uk/posts/page/1/")
+}
+
+func TestRunCanonicalMismatch(t *testing.T) {
+	body, err := ioutil.ReadFile("./internal/parser/testdata/canonical.html")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond(body), nil)
+	onGet(requester, willydURL.ResolveReference(&url.URL{Path: "/posts/page/3/"})).Return(respond([]byte("<html></html>")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Canonicalised elsewhere")
+	assert.Contains(t, buf.String(), "http://willdemaine.co.uk</a> &rarr; http://willdemaine.co.uk/posts/</li>")
+}
+
+func TestWorkerThirdPartyHosts(t *testing.T) {
+	body, err := ioutil.ReadFile("./internal/parser/testdata/preconnect.html")
+	require.NoError(t, err)
+	body = append(body, `<link rel="preconnect" href="http://willdemaine.co.uk">`...)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond(body), nil)
+
+	var pages []reporter.PageInfo
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithReportCallback(func(page reporter.PageInfo) {
+			pages = append(pages, page)
+		}),
+	)
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err = s.work()
+	require.NoError(t, err)
+
+	// The page's own host isn't a third party.
+	require.Len(t, pages, 1)
+	assert.Equal(t, []string{"analytics.example.net", "cdn.example.com", "fonts.gstatic.com"}, pages[0].ThirdPartyHosts)
+	assert.Equal(t, []string{"https://fonts.googleapis.com/css?family=Lato"}, pages[0].Assets)
+}
+
+func TestWorkerMinContentLength(t *testing.T) {
+	body, err := ioutil.ReadFile("./internal/parser/testdata/thin.html")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		min      int
+		expected bool
+	}{
+		{"under", 200, true},
+		{"over", 10, false},
+		{"disabled", 0, false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond(body), nil)
+
+			var pages []reporter.PageInfo
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithMinContentLength(test.min),
+				WithReportCallback(func(page reporter.PageInfo) {
+					pages = append(pages, page)
+				}),
+			)
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			require.NoError(t, err)
+
+			require.Len(t, pages, 1)
+			assert.Equal(t, len("Coming soon."), pages[0].TextLength)
+			assert.Equal(t, test.expected, pages[0].Thin)
+		})
+	}
+}
+
+func TestRunExternalAssetHosts(t *testing.T) {
+	body, err := ioutil.ReadFile("./internal/parser/testdata/cdn.html")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond(body), nil)
+	// The about page uses one of the same CDNs, and one of its own.
+	onGet(requester, willydURL.ResolveReference(&url.URL{Path: "/about"})).Return(respond([]byte(
+		`<html><script src="https://cdn.jsdelivr.net/npm/jquery/dist/jquery.min.js"></script>`+
+			`<img src="https://i.imgur.com/team.png"><img src="http://willdemaine.co.uk/images/logo.png">`)), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithExternalAssetHosts(true),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	report := buf.String()
+	assert.Contains(t, report, "Third-party asset hosts")
+	assert.Contains(t, report, "<tr><td>cdn.jsdelivr.net</td><td>2</td><td>2</td></tr>")
+	assert.Contains(t, report, "<tr><td>fonts.googleapis.com</td><td>1</td><td>1</td></tr>")
+	assert.Contains(t, report, "<tr><td>images.example-cdn.com</td><td>1</td><td>1</td></tr>")
+	assert.Contains(t, report, "<tr><td>www.google-analytics.com</td><td>1</td><td>1</td></tr>")
+	assert.Contains(t, report, "<tr><td>i.imgur.com</td><td>1</td><td>1</td></tr>")
+	assert.NotContains(t, report, "<tr><td>willdemaine.co.uk</td>")
+}
+
+func TestWorkerLenientParsing(t *testing.T) {
+	body, err := ioutil.ReadFile("./internal/parser/testdata/broken.html")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		lenient  bool
+		expected int
+	}{
+		{"strict", false, 0},
+		{"lenient", true, 3},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond(body), nil)
+
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithLenientParsing(test.lenient),
+			)
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			assert.NoError(t, err)
+			assert.Len(t, queuedURLs(s.queue), test.expected)
+		})
+	}
 }
 
 func TestWorkerRequestError(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydURL).Return(nil, httpResponseError{
+	onGet(requester, willydURL).Return(nil, httpResponseError{
 		statusCode: 500,
 	})
 
 	s := New(WithRoot(willydURL), WithRequester(requester))
 	s.queue.Append(willydURL)
 
-	s.wg.Add(1)
+	s.pending.Add(1)
 	err := s.work()
 	assert.Error(t, err)
 }
 
 func TestRun(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydURL).Return([]byte("foo"), nil)
+	onGet(requester, willydURL).Return(respond([]byte("foo")), nil)
 
 	s := New(
 		WithRoot(willydURL),
@@ -148,7 +407,7 @@ func TestRun(t *testing.T) {
 		if next == nil {
 			return nil
 		}
-		defer s.wg.Done()
+		defer s.pending.Done()
 		return nil
 	})
 	err := s.Run()
@@ -157,8 +416,8 @@ func TestRun(t *testing.T) {
 
 func TestRunRobots(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydRobots).Return([]byte("foo"), nil)
-	requester.On("Request", mock.Anything, willydURL).Return([]byte("foo"), nil)
+	onGet(requester, willydRobots).Return(respond([]byte("foo")), nil)
+	onGet(requester, willydURL).Return(respond([]byte("foo")), nil)
 
 	s := New(
 		WithRoot(willydURL),
@@ -171,7 +430,7 @@ func TestRunRobots(t *testing.T) {
 		if next == nil {
 			return nil
 		}
-		defer s.wg.Done()
+		defer s.pending.Done()
 		return nil
 	})
 	err := s.Run()
@@ -180,7 +439,7 @@ func TestRunRobots(t *testing.T) {
 
 func TestRunRobotsError(t *testing.T) {
 	requester := &mocks.Requester{}
-	requester.On("Request", mock.Anything, willydRobots).Return(nil, assert.AnError)
+	onGet(requester, willydRobots).Return(nil, assert.AnError)
 
 	s := New(
 		WithRoot(willydURL),
@@ -190,3 +449,3411 @@ func TestRunRobotsError(t *testing.T) {
 	err := s.Run()
 	assert.Error(t, err)
 }
+
+func TestNewLoggerFailure(t *testing.T) {
+	defer func(original func() (*zap.Logger, error)) {
+		newProductionLogger = original
+	}(newProductionLogger)
+	newProductionLogger = func() (*zap.Logger, error) {
+		return nil, assert.AnError
+	}
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte("foo")), nil)
+
+	var s *Spider
+	require.NotPanics(t, func() {
+		s = New(WithRoot(willydURL), WithRequester(requester), WithIgnoreRobots(true))
+	})
+	require.NotNil(t, s.logger)
+	assert.NoError(t, s.Run())
+}
+
+func TestRunRootNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+	)
+	err = s.Run()
+	rootErr, ok := err.(RootUnreachableError)
+	require.True(t, ok, "expected RootUnreachableError, got %v", err)
+	assert.Equal(t, root.String(), rootErr.URL.String())
+	assert.Equal(t, httpResponseError{statusCode: http.StatusNotFound}, rootErr.Err)
+}
+
+func TestRunRootDisallowedByRobots(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydRobots).Return(respond([]byte("User-agent: *\nDisallow: /")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+	)
+	err := s.Run()
+	assert.Equal(t, RootUnreachableError{URL: willydURL, Err: ErrDisallowedByRobots}, err)
+	requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, willydURL, mock.Anything, mock.Anything)
+}
+
+func TestRunMidCrawlErrorNotRoot(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	onGet(requester, willydFoo).Return(nil, assert.AnError)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+	)
+	err := s.Run()
+	assert.Equal(t, assert.AnError, err)
+}
+
+func TestRunOnCompleteSuccess(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+
+	var calls []RunStats
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithOnComplete(func(stats RunStats) {
+			calls = append(calls, stats)
+		}),
+	)
+
+	err := s.Run()
+	assert.NoError(t, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, 2, calls[0].Pages)
+	assert.Equal(t, 0, calls[0].Errors)
+	assert.NoError(t, calls[0].Err)
+	assert.True(t, calls[0].Duration > 0)
+}
+
+func TestRunOnCompleteError(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(nil, assert.AnError)
+
+	var calls []RunStats
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithOnComplete(func(stats RunStats) {
+			calls = append(calls, stats)
+		}),
+	)
+
+	err := s.Run()
+	assert.Equal(t, RootUnreachableError{URL: willydURL, Err: assert.AnError}, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, 0, calls[0].Pages)
+	assert.Equal(t, 1, calls[0].Errors)
+	assert.Equal(t, err, calls[0].Err)
+}
+
+func TestRunOnError(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	onGet(requester, willydFoo).Return(nil, assert.AnError)
+
+	var uris []*url.URL
+	var errs []error
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithOnError(func(uri *url.URL, err error) {
+			uris = append(uris, uri)
+			errs = append(errs, err)
+		}),
+	)
+	err := s.Run()
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, []*url.URL{willydFoo}, uris)
+	assert.Equal(t, []error{assert.AnError}, errs)
+}
+
+func TestRunOnErrorNotFatal(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	onGet(requester, willydFoo).Return(nil, concurrency.NewRetryableError(assert.AnError))
+
+	var errs []error
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithOnError(func(uri *url.URL, err error) {
+			errs = append(errs, err)
+		}),
+	)
+	require.NoError(t, s.Run())
+	assert.Equal(t, []error{assert.AnError}, errs)
+}
+
+func TestRunMaxQueueBytes(t *testing.T) {
+	long := strings.Repeat("a", 2000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			w.Write([]byte("<html></html>"))
+			return
+		}
+		w.Write([]byte("<html>"))
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(w, `<a href="/%d/%s"></a>`, i, long)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	var stats RunStats
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithMaxQueueBytes(8192),
+		WithOnComplete(func(s RunStats) {
+			stats = s
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	// Everything spilled to disk was still crawled, and the file was cleaned up.
+	assert.Equal(t, 21, stats.Pages)
+	assert.Nil(t, s.queue.spill)
+}
+
+func TestRunRequesterFactory(t *testing.T) {
+	blog, err := url.Parse("http://blog.willdemaine.co.uk/post")
+	require.NoError(t, err)
+
+	root := &mocks.Requester{}
+	onGet(root, willydURL).Return(respond([]byte(`<a href="/bar"></a><a href="http://blog.willdemaine.co.uk/post"></a>`)), nil)
+	onGet(root, willydBar).Return(respond([]byte("bar")), nil)
+	sub := &mocks.Requester{}
+	onGet(sub, blog).Return(respond([]byte("post")), nil)
+
+	var hosts []string
+	s := New(
+		WithRoot(willydURL),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+		WithRequesterFactory(func(host string) Requester {
+			hosts = append(hosts, host)
+			if host == "blog.willdemaine.co.uk" {
+				return sub
+			}
+			return root
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	root.AssertNumberOfCalls(t, "Do", 2)
+	sub.AssertNumberOfCalls(t, "Do", 1)
+	// The factory is only called once per host.
+	assert.ElementsMatch(t, []string{"willdemaine.co.uk", "blog.willdemaine.co.uk"}, hosts)
+}
+
+func TestRunMaxSubdomainDepth(t *testing.T) {
+	deep, err := url.Parse("http://willdemaine.co.uk/bar/deep")
+	require.NoError(t, err)
+	post, err := url.Parse("http://blog.willdemaine.co.uk/post")
+	require.NoError(t, err)
+	postDeep, err := url.Parse("http://blog.willdemaine.co.uk/post/deep")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/bar"></a><a href="http://blog.willdemaine.co.uk/post"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte(`<a href="/bar/deep"></a>`)), nil)
+	onGet(requester, deep).Return(respond([]byte("deep")), nil)
+	onGet(requester, post).Return(respond([]byte(`<a href="http://blog.willdemaine.co.uk/post/deep"></a>`)), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+		WithMaxDepth(2),
+		WithMaxSubdomainDepth(1),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	// Pages two links away are crawled on the root's host, but not on the subdomain.
+	requester.AssertNumberOfCalls(t, "Do", 4)
+	requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, postDeep, mock.Anything, mock.Anything)
+}
+
+type sliceSink struct {
+	pages []reporter.PageInfo
+	// failOn is a page the sink fails to store.
+	failOn *url.URL
+	sync.Mutex
+}
+
+func (s *sliceSink) Consume(page reporter.PageInfo) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.failOn != nil && page.URL.String() == s.failOn.String() {
+		return assert.AnError
+	}
+	s.pages = append(s.pages, page)
+	return nil
+}
+
+func TestRunResultSink(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+	onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+
+	sink := &sliceSink{}
+	s := New(WithRoot(willydURL), WithRequester(requester), WithIgnoreRobots(true), WithResultSink(sink))
+	err := s.Run()
+	require.NoError(t, err)
+
+	var urls []string
+	for _, page := range sink.pages {
+		urls = append(urls, page.URL.String())
+	}
+	assert.ElementsMatch(t, []string{willydURL.String(), willydFoo.String(), willydBar.String()}, urls)
+}
+
+func TestRunResultSinkError(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte(`<a href="/baz"></a>`)), nil)
+
+	sink := &sliceSink{failOn: willydBar}
+	s := New(WithRoot(willydURL), WithRequester(requester), WithIgnoreRobots(true), WithResultSink(sink))
+	err := s.Run()
+	assert.EqualError(t, err, "result sink failed: "+assert.AnError.Error())
+	// The crawl stops at the first failure, so neither /foo nor /baz are fetched.
+	requester.AssertNumberOfCalls(t, "Do", 2)
+}
+
+func TestRunResultSinkErrorOnRoot(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+
+	sink := &sliceSink{failOn: willydURL}
+	var stats RunStats
+	var pageErrors int
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithResultSink(sink),
+		WithOnError(func(*url.URL, error) {
+			pageErrors++
+		}),
+		WithOnComplete(func(s RunStats) {
+			stats = s
+		}),
+	)
+	err := s.Run()
+	// The root was fetched, so the sink's error isn't reported as the root being unreachable.
+	assert.EqualError(t, err, "result sink failed: "+assert.AnError.Error())
+	assert.Equal(t, assert.AnError, errors.Cause(err))
+	requester.AssertNumberOfCalls(t, "Do", 1)
+	// Nor is it counted as the page failing.
+	assert.Equal(t, 1, stats.Pages)
+	assert.Equal(t, 0, stats.Errors)
+	assert.Zero(t, pageErrors)
+}
+
+func TestRunResultSinkErrorOnSitemap(t *testing.T) {
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, sitemap).Return(respond([]byte(`
+		<urlset>
+			<url><loc>http://willdemaine.co.uk/foo</loc></url>
+		</urlset>
+	`)), nil)
+
+	sink := &sliceSink{failOn: sitemap}
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithSitemapSeeding(true),
+		WithReportSitemap(true),
+		WithResultSink(sink),
+	)
+	err = s.Run()
+	assert.EqualError(t, err, "result sink failed: "+assert.AnError.Error())
+	// The crawl stops before any pages are fetched.
+	requester.AssertNumberOfCalls(t, "Do", 1)
+}
+
+func TestRunOnCompleteCancelled(t *testing.T) {
+	var calls []RunStats
+	s := New(
+		WithRoot(willydURL),
+		WithIgnoreRobots(true),
+		WithOnComplete(func(stats RunStats) {
+			calls = append(calls, stats)
+		}),
+	)
+	// Never finish any work, so the crawl only ends when cancelled.
+	s.worker = concurrency.WorkFunc(func() error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+
+	err := s.RunContext(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, 0, calls[0].Pages)
+	assert.Equal(t, context.DeadlineExceeded, calls[0].Err)
+}
+
+func TestRunOnCompleteRobotsError(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydRobots).Return(nil, assert.AnError)
+
+	var calls []RunStats
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithOnComplete(func(stats RunStats) {
+			calls = append(calls, stats)
+		}),
+	)
+
+	err := s.Run()
+	assert.Error(t, err)
+	require.Len(t, calls, 1)
+	assert.Equal(t, err, calls[0].Err)
+}
+
+func TestRunLogin(t *testing.T) {
+	var crawled []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.PostFormValue("password") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session")
+		if err != nil || cookie.Value != "abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		crawled = append(crawled, r.URL.Path)
+		fmt.Fprint(w, `<a href="/private"></a>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	login, err := url.Parse(server.URL + "/login")
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithLogin(login, url.Values{"password": {"secret"}}),
+	)
+	err = s.Run()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/", "/private"}, crawled)
+}
+
+func TestRunLoginError(t *testing.T) {
+	login, err := url.Parse("http://willdemaine.co.uk/login")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	requester.On("Do", mock.Anything, http.MethodPost, login, mock.Anything, mock.Anything).
+		Return(nil, httpResponseError{statusCode: 403})
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithLogin(login, url.Values{}),
+	)
+	err = s.Run()
+	assert.Error(t, err)
+}
+
+func TestWorkerSoft404(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<h1>Page Not Found</h1>`)), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithSoft404Matcher(func(body []byte) bool {
+			return bytes.Contains(body, []byte("Page Not Found"))
+		}),
+	)
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	assert.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "Soft 404s")
+}
+
+func TestWorkerSlowPage(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil).After(time.Millisecond * 20)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithSlowPageThreshold(time.Millisecond*10),
+	)
+	err := s.Run()
+	assert.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "Slow pages")
+	assert.Contains(t, buf.String(), `<li><a href="#">http://willdemaine.co.uk</a>`)
+	assert.NotContains(t, buf.String(), `<li><a href="#/foo">http://willdemaine.co.uk/foo</a> (`)
+}
+
+func TestWorkerHighLinkThreshold(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&body, `<a href="/%d"></a><a href="/%d"></a>`, i, i)
+	}
+	body.WriteString(`<a href="http://example.com/"></a>`)
+
+	cases := []struct {
+		name      string
+		threshold int
+		expected  bool
+	}{
+		{"over", 20, true},
+		{"at", 21, false},
+		{"disabled", 0, false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond(body.Bytes()), nil)
+
+			var pages []reporter.PageInfo
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithHighLinkThreshold(test.threshold),
+				WithReportCallback(func(page reporter.PageInfo) {
+					pages = append(pages, page)
+				}),
+			)
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			require.NoError(t, err)
+
+			// Repeated links count once, and external links count too.
+			require.Len(t, pages, 1)
+			assert.Equal(t, 21, pages[0].LinkCount)
+			assert.Equal(t, test.expected, pages[0].ManyLinks)
+		})
+	}
+}
+
+func TestRunNearDuplicates(t *testing.T) {
+	article := `Go is an open source programming language which makes it simple to build secure,
+		scalable systems. It was designed at Google to improve programming productivity in an era of
+		multicore, networked machines and large codebases. The designers wanted to address criticism
+		of other languages in use at Google while keeping their useful characteristics: static typing
+		and run time efficiency, readability and usability, and high performance networking and
+		multiprocessing.`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><a href="/post"></a><a href="/post/print"></a><a href="/about"></a></html>`)
+		case "/post":
+			// The same article with a little more text, and a link only found here.
+			fmt.Fprintf(w, `<html><p>%s Share.</p><a href="/share"></a></html>`, article)
+		case "/post/print":
+			fmt.Fprintf(w, `<html><p>%s</p></html>`, article)
+		case "/about":
+			fmt.Fprint(w, `<html><p>Will writes about distributed systems and runs a lot.</p></html>`)
+		default:
+			fmt.Fprint(w, `<html></html>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name      string
+		skipLinks bool
+		pages     int
+	}{
+		{"follow links", false, 5},
+		{"skip links", true, 4},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			pages := make(map[string]reporter.PageInfo)
+			s := New(
+				WithRoot(root),
+				WithIgnoreRobots(true),
+				WithNearDuplicateDetection(0.9),
+				WithSkipNearDuplicateLinks(test.skipLinks),
+				WithReportCallback(func(page reporter.PageInfo) {
+					lock.Lock()
+					defer lock.Unlock()
+					pages[page.URL.Path] = page
+				}),
+			)
+			err := s.Run()
+			require.NoError(t, err)
+			require.Len(t, pages, test.pages)
+
+			// Links are taken last in first out, so the print version is crawled before the post,
+			// which is its near duplicate.
+			post, print := pages["/post"], pages["/post/print"]
+			require.NotNil(t, post.NearDuplicateOf)
+			assert.Equal(t, print.URL.String(), post.NearDuplicateOf.String())
+			assert.Nil(t, print.NearDuplicateOf)
+			assert.Nil(t, pages["/about"].NearDuplicateOf)
+			// Pages without text don't count as duplicates of each other.
+			assert.Nil(t, pages[""].NearDuplicateOf)
+
+			buf := bytes.NewBuffer(nil)
+			require.NoError(t, s.Report(buf))
+			assert.Contains(t, buf.String(), "Near duplicates")
+		})
+	}
+}
+
+func TestRunSkipFragmentOnlyLinks(t *testing.T) {
+	var lock sync.Mutex
+	fetches := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetches[r.URL.Path]++
+		lock.Unlock()
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><a href="#main">Skip</a><a href="/about">About</a>`)
+		case "/about":
+			fmt.Fprint(w, `<html><a href="#top">Top</a><a href="#">Menu</a><a href="/about#team">Team</a><a href="/">Home</a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name    string
+		skip    bool
+		fetches map[string]int
+	}{
+		// Each fragment is a new URL, and they're resolved against the root, so "#main" and
+		// "#top" both fetch the root again.
+		{"not skipped", false, map[string]int{"/": 3, "/about": 2}},
+		{"skipped", true, map[string]int{"/": 1, "/about": 1}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			fetches = make(map[string]int)
+			links := make(map[string][]string)
+			s := New(
+				WithRoot(root),
+				WithIgnoreRobots(true),
+				WithSkipFragmentOnlyLinks(test.skip),
+				WithReportCallback(func(page reporter.PageInfo) {
+					lock.Lock()
+					defer lock.Unlock()
+					for _, link := range page.Links {
+						links[page.URL.String()] = append(links[page.URL.String()], link.String())
+					}
+				}),
+			)
+			err := s.Run()
+			require.NoError(t, err)
+			assert.Equal(t, test.fetches, fetches)
+			if !test.skip {
+				return
+			}
+			// No page links to itself.
+			assert.Equal(t, map[string][]string{
+				server.URL + "/":      {server.URL + "/about"},
+				server.URL + "/about": {server.URL + "/"},
+			}, links)
+		})
+	}
+}
+
+func TestRunRedirectChains(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><a href="/old"></a></html>`)
+	})
+	mux.Handle("/old", http.RedirectHandler("/mid", http.StatusMovedPermanently))
+	mux.Handle("/mid", http.RedirectHandler("/final", http.StatusFound))
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html></html>`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	root, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	pages := make(map[string]reporter.PageInfo)
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithRedirectChains(true),
+		WithReportCallback(func(page reporter.PageInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			pages[page.URL.Path] = page
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	assert.Nil(t, pages["/"].RedirectChain)
+	chain := pages["/old"].RedirectChain
+	require.Len(t, chain, 3)
+	expected := []struct {
+		path   string
+		status int
+	}{
+		{"/old", http.StatusMovedPermanently},
+		{"/mid", http.StatusFound},
+		{"/final", http.StatusOK},
+	}
+	for i, hop := range expected {
+		assert.Equal(t, server.URL+hop.path, chain[i].URL.String())
+		assert.Equal(t, hop.status, chain[i].StatusCode)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, s.Report(buf))
+	assert.Contains(t, buf.String(), fmt.Sprintf("<li>%[1]s/old (301) &rarr; %[1]s/mid (302) &rarr; %[1]s/final (200)</li>", server.URL))
+}
+
+func TestWorkerTreatWWWAsSame(t *testing.T) {
+	body := []byte(`
+		<a href="/foo"></a>
+		<a href="http://www.willdemaine.co.uk/foo"></a>
+		<a href="http://www.willdemaine.co.uk/bar"></a>
+	`)
+
+	cases := []struct {
+		name     string
+		same     bool
+		expected []string
+	}{
+		{"disabled", false, []string{"http://willdemaine.co.uk/foo"}},
+		{"enabled", true, []string{"http://willdemaine.co.uk/foo", "http://willdemaine.co.uk/bar"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond(body), nil)
+
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithTreatWWWAsSame(test.same),
+			)
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			assert.NoError(t, err)
+
+			queued := queuedURLs(s.queue)
+			assert.Equal(t, test.expected, queued)
+		})
+	}
+}
+
+func TestRunInitialSeenURLs(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithInitialSeenURLs([]*url.URL{willydFoo}),
+	)
+	err := s.Run()
+	assert.NoError(t, err)
+
+	requester.AssertNumberOfCalls(t, "Do", 2)
+	requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, willydFoo, mock.Anything, mock.Anything)
+}
+
+func TestRunSitemapOrphans(t *testing.T) {
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+	orphan, err := url.Parse("http://willdemaine.co.uk/orphan")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	onGet(requester, sitemap).Return(respond([]byte(`
+		<urlset>
+			<url><loc>http://willdemaine.co.uk</loc></url>
+			<url><loc>http://willdemaine.co.uk/foo</loc></url>
+			<url><loc>http://willdemaine.co.uk/orphan</loc></url>
+			<url><loc>http://foo.bar.co.uk/external</loc></url>
+		</urlset>
+	`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+	onGet(requester, orphan).Return(respond([]byte("orphan")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithSitemapSeeding(true),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	requester.AssertExpectations(t)
+	requester.AssertNumberOfCalls(t, "Do", 4)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+
+	report := buf.String()
+	start := strings.Index(report, "Orphan pages")
+	require.True(t, start >= 0)
+	section := report[start : start+strings.Index(report[start:], "</div>")]
+	assert.Contains(t, section, "http://willdemaine.co.uk/orphan")
+	assert.NotContains(t, section, "http://willdemaine.co.uk/foo")
+	// Nothing links to the root, but it isn't an orphan.
+	assert.NotContains(t, section, ">http://willdemaine.co.uk<")
+}
+
+func TestRunSitemapMissing(t *testing.T) {
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte("foo")), nil)
+	onGet(requester, sitemap).Return(nil, httpResponseError{statusCode: 404})
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithSitemapSeeding(true),
+	)
+	err = s.Run()
+	assert.NoError(t, err)
+}
+
+func TestWorkerMaxAssetsPerPage(t *testing.T) {
+	body := strings.Repeat(`<img src="/dup.png">`, 20)
+	for i := 0; i < 20; i++ {
+		body += fmt.Sprintf(`<img src="/%d.png">`, i)
+	}
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(body)), nil)
+
+	rec := &recordingReporter{}
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithMaxAssetsPerPage(5),
+	)
+	s.reporter = rec
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	assert.NoError(t, err)
+
+	require.Len(t, rec.pages, 1)
+	assert.Equal(t, []string{"/dup.png", "/0.png", "/1.png", "/2.png", "/3.png"}, rec.pages[0].Assets)
+}
+
+func TestRunResultLimit(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a><img src="/a.png">`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte(`<img src="/b.png">`)), nil)
+
+	r := reporter.NewJSON()
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithReporter(r),
+		WithResultLimit(4),
+	)
+	require.NoError(t, s.Run())
+
+	set := r.ResultSet()
+	require.Len(t, set.Pages, 3)
+	stored := 0
+	for _, page := range set.Pages {
+		stored += len(page.Links) + len(page.Assets)
+	}
+	assert.Equal(t, 4, stored)
+	assert.True(t, set.Truncated)
+}
+
+func TestRunAssetProbing(t *testing.T) {
+	ok, err := url.Parse("http://willdemaine.co.uk/ok.png")
+	require.NoError(t, err)
+	missing, err := url.Parse("http://willdemaine.co.uk/missing.png")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><img src="/ok.png"><img src="/missing.png">`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte(`<img src="ok.png">`)), nil)
+	requester.On("Do", mock.Anything, http.MethodHead, ok, mock.Anything, mock.Anything).Return(respond(nil), nil).Once()
+	requester.On("Do", mock.Anything, http.MethodHead, missing, mock.Anything, mock.Anything).
+		Return(nil, httpResponseError{statusCode: http.StatusNotFound}).Once()
+
+	inventory := reporter.NewAssetInventory()
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithReporter(inventory),
+		WithAssetProbing(true),
+		WithAssetProbeInterval(time.Millisecond*10),
+	)
+	require.NoError(t, s.Run())
+	requester.AssertExpectations(t)
+
+	assert.Equal(t, []reporter.InventoryAsset{
+		{
+			URL:        missing.String(),
+			Probed:     true,
+			StatusCode: http.StatusNotFound,
+			Error:      "http response error: 404",
+			Pages:      []string{willydURL.String()},
+		},
+		{
+			URL:        ok.String(),
+			Probed:     true,
+			StatusCode: http.StatusOK,
+			Pages:      []string{willydURL.String(), willydFoo.String()},
+		},
+	}, inventory.Assets())
+}
+
+func TestRunDepthInReport(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte(`<a href="/"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+	slash, err := url.Parse("http://willdemaine.co.uk/")
+	require.NoError(t, err)
+	onGet(requester, slash).Return(respond([]byte("home")), nil)
+
+	r := reporter.NewJSON()
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithDepthInReport(true),
+		WithReporter(r),
+	)
+	require.NoError(t, s.Run())
+
+	depths := make(map[string]int)
+	for _, page := range r.ResultSet().Pages {
+		require.NotNil(t, page.Depth, page.URL)
+		depths[page.URL] = *page.Depth
+	}
+	assert.Equal(t, map[string]int{
+		willydURL.String(): 0,
+		willydFoo.String(): 1,
+		slash.String():     2,
+		willydBar.String(): 2,
+	}, depths)
+}
+
+func TestRunReportsResources(t *testing.T) {
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydRobots).Return(respond([]byte("User-agent: *")), nil)
+	onGet(requester, sitemap).Return(nil, httpResponseError{statusCode: 404})
+	onGet(requester, willydURL).Return(respond([]byte("foo")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithSitemapSeeding(true),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Consulted")
+	assert.Contains(t, buf.String(), "<li>http://willdemaine.co.uk/robots.txt: 200</li>")
+	assert.Contains(t, buf.String(), "<li>http://willdemaine.co.uk/sitemap.xml: 404</li>")
+}
+
+func TestRunConcurrentCrawl(t *testing.T) {
+	const pages = 50
+
+	var lock sync.Mutex
+	fetched := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched[r.URL.Path]++
+		lock.Unlock()
+
+		// Every page links to every other page, so workers race to enqueue the same links.
+		for i := 0; i < pages; i++ {
+			fmt.Fprintf(w, `<a href="/page/%d"></a>`, i)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithConcurrency(20),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	assert.Len(t, fetched, pages+1)
+	for path, count := range fetched {
+		assert.Equal(t, 1, count, path)
+	}
+}
+
+func TestRunCrawlFilter(t *testing.T) {
+	links := map[string]string{
+		"/":       `<a href="/a"></a><a href="/b"></a><a href="/c.pdf"></a>`,
+		"/a":      `<a href="/a/deep"></a>`,
+		"/b":      `<a href="/b/deep"></a>`,
+		"/a/deep": `<a href="/a/deeper"></a>`,
+	}
+
+	var lock sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched = append(fetched, r.URL.Path)
+		lock.Unlock()
+		fmt.Fprint(w, links[r.URL.Path])
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithCrawlFilter(func(ctx CrawlContext) bool {
+			return ctx.Depth <= 2 &&
+				ctx.Referrer.Path != "/b" &&
+				ctx.ContentType != "application/pdf"
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"/", "/a", "/b", "/a/deep"}, fetched)
+}
+
+func TestWorkerCacheHeaders(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(func(context.Context, string, *url.URL, io.Reader, http.Header) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Cache-Control": {"public, max-age=3600"},
+				"Etag":          {`"v1"`},
+				"Expires":       {"Wed, 21 Oct 2026 07:28:00 GMT"},
+			},
+			Body: ioutil.NopCloser(strings.NewReader("foo")),
+		}
+	}, nil)
+
+	s := New(WithRoot(willydURL), WithRequester(requester))
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "<td>public, max-age=3600</td>")
+	assert.Contains(t, buf.String(), "<td>&#34;v1&#34;</td>")
+	assert.Contains(t, buf.String(), "<td>Wed, 21 Oct 2026 07:28:00 GMT</td>")
+}
+
+func TestRunSniffContentType(t *testing.T) {
+	pages := map[string]string{
+		"/":       `<html><body><a href="/binary"></a></body></html>`,
+		"/binary": "\x89PNG\r\n\x1a\n" + `<a href="/hidden"></a>`,
+		"/hidden": "",
+	}
+
+	cases := []struct {
+		name     string
+		sniff    bool
+		expected []string
+	}{
+		{"sniffing", true, []string{"/", "/binary"}},
+		{"not sniffing", false, []string{"/", "/binary", "/hidden"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var fetched []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				fetched = append(fetched, r.URL.Path)
+				lock.Unlock()
+				// Stop the server from sniffing the type itself.
+				w.Header()["Content-Type"] = nil
+				fmt.Fprint(w, pages[r.URL.Path])
+			}))
+			defer server.Close()
+
+			root, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			s := New(
+				WithRoot(root),
+				WithIgnoreRobots(true),
+				WithSniffContentType(test.sniff),
+			)
+			err = s.Run()
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, fetched)
+		})
+	}
+}
+
+func TestWorkerSkipsNonHTML(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(func(context.Context, string, *url.URL, io.Reader, http.Header) *http.Response {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"image/png"}},
+			Body:       ioutil.NopCloser(strings.NewReader(`<a href="/foo/bar"></a>`)),
+		}
+	}, nil)
+
+	s := New(WithRoot(willydURL), WithRequester(requester))
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	assert.NoError(t, err)
+	assert.Empty(t, queuedURLs(s.queue))
+}
+
+func TestRunPauseResume(t *testing.T) {
+	var s *Spider
+	var lock sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched = append(fetched, r.URL.Path)
+		lock.Unlock()
+		if r.URL.Path == "/" {
+			// Pause while the root is in flight, so its links are queued but not fetched.
+			s.Pause()
+			fmt.Fprint(w, `<a href="/a"></a><a href="/b"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s = New(WithRoot(root), WithIgnoreRobots(true), WithConcurrency(2))
+	errs := make(chan error)
+	go func() {
+		errs <- s.Run()
+	}()
+
+	time.Sleep(workerPollInterval * 3)
+	lock.Lock()
+	assert.Equal(t, []string{"/"}, fetched)
+	lock.Unlock()
+	assert.Equal(t, 2, s.queue.Len())
+
+	s.Resume()
+	require.NoError(t, <-errs)
+	assert.ElementsMatch(t, []string{"/", "/a", "/b"}, fetched)
+}
+
+func TestRunMaxRetryDuration(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+
+	var attempts int64
+	onGet(requester, willydFoo).Return(nil, httpResponseError{statusCode: 503}).Run(func(mock.Arguments) {
+		atomic.AddInt64(&attempts, 1)
+	})
+
+	var stats RunStats
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithMaxRetryDuration(time.Millisecond*500),
+		WithOnComplete(func(s RunStats) {
+			stats = s
+		}),
+	)
+	start := time.Now()
+	err := s.Run()
+	require.NoError(t, err)
+
+	assert.True(t, time.Since(start) < time.Second*2)
+	assert.True(t, atomic.LoadInt64(&attempts) > 1)
+	assert.Equal(t, 2, stats.Pages)
+	assert.Equal(t, 1, stats.Errors)
+	requester.AssertCalled(t, "Do", mock.Anything, http.MethodGet, willydBar, mock.Anything, mock.Anything)
+}
+
+func TestWorkerNoRetryByDefault(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(nil, httpResponseError{statusCode: 503}).Once()
+
+	s := New(WithRoot(willydURL), WithRequester(requester))
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	assert.Equal(t, httpResponseError{statusCode: 503}, err)
+	requester.AssertExpectations(t)
+}
+
+func TestRunGraphMLReporter(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte(`<a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte(`<a href="/foo"></a>`)), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithReporter(reporter.NewGraphML()),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+
+	var graph struct {
+		Nodes []struct {
+			ID string `xml:"id,attr"`
+		} `xml:"graph>node"`
+		Edges []struct {
+			Source string `xml:"source,attr"`
+		} `xml:"graph>edge"`
+	}
+	err = xml.Unmarshal(buf.Bytes(), &graph)
+	require.NoError(t, err)
+	assert.Len(t, graph.Nodes, 3)
+	assert.Len(t, graph.Edges, 4)
+}
+
+func TestRunRedirectLoop(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="/loop"></a><a href="/fine"></a>`)
+		case "/loop":
+			http.Redirect(w, r, "/loop/again", http.StatusFound)
+		case "/loop/again":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var stats RunStats
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithOnComplete(func(s RunStats) {
+			stats = s
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Pages)
+	assert.Equal(t, 1, stats.Errors)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Redirect loops")
+	assert.Contains(t, buf.String(), fmt.Sprintf("%[1]s/loop &rarr; %[1]s/loop/again &rarr; %[1]s/loop", server.URL))
+}
+
+func TestRunAllowedAndDeniedHosts(t *testing.T) {
+	blog, _ := url.Parse("http://blog.willdemaine.co.uk/")
+	shop, _ := url.Parse("http://shop.willdemaine.co.uk/")
+	www, _ := url.Parse("http://www.willdemaine.co.uk/")
+	cdn, _ := url.Parse("http://cdn.example.com/")
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`
+		<a href="http://blog.willdemaine.co.uk/"></a>
+		<a href="http://shop.willdemaine.co.uk/"></a>
+		<a href="http://www.willdemaine.co.uk/"></a>
+		<a href="http://cdn.example.com/"></a>
+		<a href="http://example.com/"></a>
+	`)), nil)
+	onGet(requester, blog).Return(respond([]byte("blog")), nil)
+	onGet(requester, www).Return(respond([]byte("www")), nil)
+	onGet(requester, cdn).Return(respond([]byte("cdn")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+		WithAllowedHosts([]string{"cdn.example.com", "shop.willdemaine.co.uk"}),
+		WithDeniedHosts([]string{"shop.willdemaine.co.uk"}),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	// Subdomains are still followed, along with the allowed host, but not the denied one.
+	requester.AssertNumberOfCalls(t, "Do", 4)
+	requester.AssertCalled(t, "Do", mock.Anything, http.MethodGet, blog, mock.Anything, mock.Anything)
+	requester.AssertCalled(t, "Do", mock.Anything, http.MethodGet, www, mock.Anything, mock.Anything)
+	requester.AssertCalled(t, "Do", mock.Anything, http.MethodGet, cdn, mock.Anything, mock.Anything)
+	requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, shop, mock.Anything, mock.Anything)
+}
+
+func TestRunPageSizes(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond(bytes.Repeat([]byte("a"), 50<<10)), nil)
+	onGet(requester, willydBar).Return(respond(bytes.Repeat([]byte("a"), 2<<20)), nil)
+
+	r := &recordingReporter{}
+	s := New(WithRoot(willydURL), WithRequester(requester), WithIgnoreRobots(true), WithReporter(r))
+	err := s.Run()
+	require.NoError(t, err)
+
+	sizes := make(map[string]int64)
+	for _, page := range r.pages {
+		sizes[page.URL.Path] = page.Size
+	}
+	assert.Equal(t, map[string]int64{"": 38, "/foo": 50 << 10, "/bar": 2 << 20}, sizes)
+
+	html := reporter.NewHTML()
+	for _, page := range r.pages {
+		html.Add(page)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = html.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "<tr><td>&lt; 10KB</td><td>1</td></tr>")
+	assert.Contains(t, buf.String(), "<tr><td>10KB - 100KB</td><td>1</td></tr>")
+	assert.Contains(t, buf.String(), "<tr><td>100KB - 1MB</td><td>0</td></tr>")
+	assert.Contains(t, buf.String(), "<tr><td>&gt; 1MB</td><td>1</td></tr>")
+}
+
+func TestRunRetryBudget(t *testing.T) {
+	const pages = 20
+
+	cases := []struct {
+		name       string
+		options    []Option
+		maxRetries int64
+		minRetries int64
+	}{
+		{"no budget", nil, pages, pages},
+		{"budget", []Option{WithRetryBudget(0.1)}, 3, 0},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var requests int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/" {
+					for i := 0; i < pages; i++ {
+						fmt.Fprintf(w, `<a href="/page/%d"></a>`, i)
+					}
+					return
+				}
+				atomic.AddInt64(&requests, 1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}))
+			defer server.Close()
+
+			root, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			// Leave time for exactly one retry of each page.
+			options := append([]Option{
+				WithRoot(root),
+				WithIgnoreRobots(true),
+				WithMaxRetryDuration(retryBaseBackoff * 2),
+			}, test.options...)
+			s := New(options...)
+			err = s.Run()
+			require.NoError(t, err)
+
+			retries := atomic.LoadInt64(&requests) - pages
+			assert.True(t, retries <= test.maxRetries, "retries: %d", retries)
+			assert.True(t, retries >= test.minRetries, "retries: %d", retries)
+		})
+	}
+}
+
+func TestWorkerExcludeExtensions(t *testing.T) {
+	body := []byte(`
+		<a href="/doc.pdf"></a>
+		<a href="/setup.exe"></a>
+		<a href="/page"></a>
+	`)
+
+	cases := []struct {
+		name     string
+		options  []Option
+		expected []string
+	}{
+		{"defaults", nil, []string{"/page"}},
+		{"extended", []Option{WithExcludeExtensions(append(DefaultExcludeExtensions(), ".html"))}, []string{"/page"}},
+		{"allow pdf", []Option{WithExcludeExtensions([]string{".exe"})}, []string{"/doc.pdf", "/page"}},
+		{"disabled", []Option{WithExcludeExtensions(nil)}, []string{"/doc.pdf", "/setup.exe", "/page"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond(body), nil)
+
+			s := New(append([]Option{WithRoot(willydURL), WithRequester(requester)}, test.options...)...)
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			require.NoError(t, err)
+
+			var queued []string
+			for _, item := range s.queue.items {
+				queued = append(queued, item.url.Path)
+			}
+			assert.ElementsMatch(t, test.expected, queued)
+		})
+	}
+}
+
+func TestRunContextCancelsRequests(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(WithRoot(root), WithIgnoreRobots(true), WithTimeout(time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		errs <- s.RunContext(ctx)
+	}()
+
+	<-started
+	start := time.Now()
+	cancel()
+
+	select {
+	case err := <-errs:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("run didn't stop after the context was cancelled")
+	}
+	assert.True(t, time.Since(start) < time.Second)
+	<-cancelled
+}
+
+func TestRunReportMetadata(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte("foo")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithReportMetadata(map[string]string{
+			"title": "Weekly crawl",
+			"root":  willydURL.String(),
+		}),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "<h1>Weekly crawl</h1>")
+	assert.Contains(t, buf.String(), "<dt>root</dt><dd>http://willdemaine.co.uk</dd>")
+}
+
+func TestRunSkipUnchanged(t *testing.T) {
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+
+	sitemapBody := func(barLastMod string) []byte {
+		return []byte(`
+			<urlset>
+				<url><loc>http://willdemaine.co.uk/foo</loc><lastmod>2017-06-01</lastmod></url>
+				<url><loc>http://willdemaine.co.uk/bar</loc><lastmod>` + barLastMod + `</lastmod></url>
+			</urlset>
+		`)
+	}
+	store := NewMemoryLastModStore()
+
+	run := func(barLastMod string) (*mocks.Requester, *recordingReporter) {
+		requester := &mocks.Requester{}
+		onGet(requester, willydURL).Return(respond([]byte("root")), nil)
+		onGet(requester, sitemap).Return(respond(sitemapBody(barLastMod)), nil)
+		onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+		onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+
+		r := &recordingReporter{}
+		s := New(
+			WithRoot(willydURL),
+			WithRequester(requester),
+			WithIgnoreRobots(true),
+			WithSitemapSeeding(true),
+			WithSkipUnchanged(store),
+			WithReporter(r),
+		)
+		err := s.Run()
+		require.NoError(t, err)
+		return requester, r
+	}
+
+	requester, _ := run("2017-06-01")
+	requester.AssertNumberOfCalls(t, "Do", 4)
+
+	// Only bar has changed since, so foo's stored result is used instead of fetching it.
+	requester, r := run("2017-06-02")
+	requester.AssertNumberOfCalls(t, "Do", 3)
+	requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, willydFoo, mock.Anything, mock.Anything)
+
+	reported := make([]string, len(r.pages))
+	for i, page := range r.pages {
+		reported[i] = page.URL.String()
+	}
+	assert.ElementsMatch(t, []string{
+		"http://willdemaine.co.uk",
+		"http://willdemaine.co.uk/foo",
+		"http://willdemaine.co.uk/bar",
+	}, reported)
+}
+
+// writeSeedFile writes the seeds to a temporary file and returns its path.
+func writeSeedFile(t *testing.T, seeds string) string {
+	f, err := ioutil.TempFile("", "seeds")
+	require.NoError(t, err)
+	defer f.Close()
+
+	_, err = f.WriteString(seeds)
+	require.NoError(t, err)
+	return f.Name()
+}
+
+func TestRunSeedFile(t *testing.T) {
+	var lock sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched = append(fetched, r.URL.Path)
+		lock.Unlock()
+		fmt.Fprint(w, `<a href="/linked"></a>`)
+	}))
+	defer server.Close()
+
+	seeds := fmt.Sprintf("%[1]s/one\n\n# A comment\n  %[1]s/two  \n%[1]s/three\n", server.URL)
+	path := writeSeedFile(t, seeds)
+	defer os.Remove(path)
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithSeedFile(path),
+		WithMaxDepth(0),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/", "/one", "/two", "/three"}, fetched)
+}
+
+func TestRunSeedFileFiltered(t *testing.T) {
+	var lock sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+			return
+		}
+		lock.Lock()
+		fetched = append(fetched, r.URL.RequestURI())
+		lock.Unlock()
+		fmt.Fprint(w, "<html>")
+	}))
+	defer server.Close()
+
+	seeds := fmt.Sprintf("%[1]s/one?utm=seed\n%[1]s/private\n%[1]s/report.pdf\n%[1]s/skip\n", server.URL)
+	path := writeSeedFile(t, seeds)
+	defer os.Remove(path)
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithSeedFile(path),
+		WithIgnoreQueryStrings(true),
+		WithCrawlFilter(func(ctx CrawlContext) bool {
+			return ctx.URL.Path != "/skip"
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	// Seeds are normalised and filtered like links, by robots.txt, extension and crawl filters.
+	assert.ElementsMatch(t, []string{"/", "/one"}, fetched)
+}
+
+func TestRunIgnoreQueryStringsSitemap(t *testing.T) {
+	var lock sync.Mutex
+	var fetched []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched = append(fetched, r.URL.RequestURI())
+		lock.Unlock()
+		if r.URL.Path == "/sitemap.xml" {
+			fmt.Fprintf(w, `<urlset><url><loc>%[1]s/page?utm=sitemap</loc></url><url><loc>%[1]s/page?utm=other</loc></url></urlset>`, server.URL)
+			return
+		}
+		fmt.Fprint(w, "<html>")
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var pages []reporter.PageInfo
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithSitemapSeeding(true),
+		WithIgnoreQueryStrings(true),
+		WithReportCallback(func(page reporter.PageInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			pages = append(pages, page)
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	// Both sitemap entries are the same page once their queries are removed.
+	assert.ElementsMatch(t, []string{"/sitemap.xml", "/", "/page"}, fetched)
+	var fromSitemap []string
+	for _, page := range pages {
+		if page.FromSitemap {
+			fromSitemap = append(fromSitemap, page.URL.RequestURI())
+		}
+	}
+	assert.Equal(t, []string{"/page"}, fromSitemap)
+}
+
+func TestRunSinglePage(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a><img src="/logo.png">`)), nil)
+
+	var pages []reporter.PageInfo
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithSinglePage(true),
+		WithReportCallback(func(page reporter.PageInfo) {
+			pages = append(pages, page)
+		}),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	require.Len(t, pages, 1)
+	assert.Equal(t, []*url.URL{willydFoo, willydBar}, pages[0].Links)
+	assert.Equal(t, []string{"/logo.png"}, pages[0].Assets)
+	requester.AssertNumberOfCalls(t, "Do", 1)
+}
+
+func TestRunFormSubmission(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.PostForm.Encode())
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<html><form method="post" action="/search"><input type="hidden" name="q" value="go"><input type="submit"></form>`)
+			// A second form with the same action and values is only submitted once.
+			fmt.Fprint(w, `<form method="post" action="/search"><input name="q" value="go"></form>`)
+			fmt.Fprint(w, `<form action="/filter"><input name="tag" value="go"></form>`)
+		case "/search":
+			if r.Method == http.MethodPost {
+				fmt.Fprint(w, `<a href="/result"></a>`)
+			}
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		submit   bool
+		expected []string
+	}{
+		{"submitted", true, []string{"GET / ", "POST /search q=go", "GET /result "}},
+		{"not submitted", false, []string{"GET / "}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requests = nil
+			var pages []reporter.PageInfo
+			s := New(
+				WithRoot(root),
+				WithIgnoreRobots(true),
+				WithFormSubmission(test.submit),
+				WithReportCallback(func(page reporter.PageInfo) {
+					pages = append(pages, page)
+				}),
+			)
+			err := s.Run()
+			require.NoError(t, err)
+			assert.ElementsMatch(t, test.expected, requests)
+			if test.submit {
+				require.Len(t, pages, 3)
+				assert.Equal(t, http.MethodPost, pages[1].Method)
+				assert.Equal(t, "/search", pages[1].URL.Path)
+			}
+		})
+	}
+}
+
+func TestRunRequests(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path+" "+r.PostForm.Encode())
+		lock.Unlock()
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/search"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	search, err := url.Parse(server.URL + "/search")
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithRequests(
+			Request{URL: search, Method: "post", Body: url.Values{"q": {"go"}}},
+			Request{URL: search, Method: http.MethodPost, Body: url.Values{"q": {"rust"}}},
+			Request{URL: search, Method: http.MethodPost, Body: url.Values{"q": {"go"}}},
+		),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	// The GET for the link isn't deduplicated against the POSTs, or the POSTs against each other
+	// unless their bodies match.
+	assert.ElementsMatch(t, []string{"GET / ", "POST /search q=go", "POST /search q=rust", "GET /search "}, requests)
+}
+
+func TestRunRequestsFiltered(t *testing.T) {
+	var lock sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+			return
+		}
+		lock.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		lock.Unlock()
+		fmt.Fprint(w, "<html>")
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	search, err := url.Parse(server.URL + "/search")
+	require.NoError(t, err)
+	private, err := url.Parse(server.URL + "/private/search")
+	require.NoError(t, err)
+	upload, err := url.Parse(server.URL + "/upload")
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithRequests(
+			Request{URL: search, Method: http.MethodPost, Body: url.Values{"q": {"go"}}},
+			Request{URL: private, Method: http.MethodPost, Body: url.Values{"q": {"go"}}},
+			Request{URL: upload, Method: http.MethodPut},
+		),
+		WithCrawlFilter(func(ctx CrawlContext) bool {
+			return ctx.Method != http.MethodPut
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"GET /", "POST /search"}, requests)
+}
+
+func TestRunIdempotencyKey(t *testing.T) {
+	var lock sync.Mutex
+	// keys are the idempotency keys each POST body was sent with, one per attempt.
+	var keys map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			fmt.Fprint(w, `<html></html>`)
+			return
+		}
+		r.ParseForm()
+		lock.Lock()
+		body := r.PostForm.Encode()
+		keys[body] = append(keys[body], r.Header.Get("Idempotency-Key"))
+		attempts := len(keys[body])
+		lock.Unlock()
+		// Each submission fails twice before succeeding.
+		if attempts <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	search, err := url.Parse(server.URL + "/search")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		header   string
+		attempts int
+	}{
+		{"with key", "Idempotency-Key", 3},
+		{"without key", "", 1},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			keys = make(map[string][]string)
+			s := New(
+				WithRoot(root),
+				WithIgnoreRobots(true),
+				WithMaxRetryDuration(time.Second*5),
+				WithIdempotencyKey(test.header),
+				WithRequests(
+					Request{URL: search, Method: http.MethodPost, Body: url.Values{"q": {"go"}}},
+					Request{URL: search, Method: http.MethodPost, Body: url.Values{"q": {"rust"}}},
+				),
+			)
+			err := s.Run()
+			require.NoError(t, err)
+
+			require.Len(t, keys["q=go"], test.attempts)
+			require.Len(t, keys["q=rust"], test.attempts)
+			if test.header == "" {
+				assert.Empty(t, keys["q=go"][0])
+				return
+			}
+			// Retries of a request send the same key, and different requests different keys.
+			assert.NotEmpty(t, keys["q=go"][0])
+			for _, key := range keys["q=go"] {
+				assert.Equal(t, keys["q=go"][0], key)
+			}
+			for _, key := range keys["q=rust"] {
+				assert.Equal(t, keys["q=rust"][0], key)
+			}
+			assert.NotEqual(t, keys["q=go"][0], keys["q=rust"][0])
+		})
+	}
+}
+
+func TestRunSeedFileInvalid(t *testing.T) {
+	path := writeSeedFile(t, "http://willdemaine.co.uk/ok\n/relative\n")
+	defer os.Remove(path)
+
+	requester := &mocks.Requester{}
+	s := New(WithRoot(willydURL), WithRequester(requester), WithIgnoreRobots(true), WithSeedFile(path))
+	err := s.Run()
+	assert.EqualError(t, err, `invalid URL on line 2 of seed file: "/relative"`)
+	requester.AssertNotCalled(t, "Do", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestWorkerMaxDepth(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydFoo).Return(respond([]byte(`<a href="/bar"></a>`)), nil)
+
+	cases := []struct {
+		name     string
+		depth    int
+		expected int
+	}{
+		{"unlimited", -1, 1},
+		{"within", 2, 1},
+		{"too deep", 1, 0},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			s := New(WithRoot(willydURL), WithRequester(requester), WithMaxDepth(test.depth))
+			s.pending.Add(1)
+			s.queue.AppendUnseen(&queueItem{url: willydFoo, depth: 1})
+
+			err := s.work()
+			require.NoError(t, err)
+			assert.Len(t, queuedURLs(s.queue), test.expected)
+		})
+	}
+}
+
+func TestRunMaxTotalBytes(t *testing.T) {
+	var fetched int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetched, 1)
+		// Each page is 1000 bytes and links to two more, so the site is much bigger than the limit.
+		links := fmt.Sprintf(`<a href="%[1]s/a"></a><a href="%[1]s/b"></a>`, strings.TrimSuffix(r.URL.Path, "/"))
+		fmt.Fprint(w, links+strings.Repeat(" ", 1000-len(links)))
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var stats RunStats
+	r := &recordingReporter{}
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithConcurrency(2),
+		WithReporter(r),
+		WithMaxTotalBytes(5000),
+		WithOnComplete(func(s RunStats) { stats = s }),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	// Pages which were already being fetched when the limit was hit can take it slightly over.
+	assert.True(t, stats.Bytes >= 5000 && stats.Bytes <= 6000, "downloaded %d bytes", stats.Bytes)
+	assert.Equal(t, stats.Bytes/1000, atomic.LoadInt64(&fetched))
+	assert.Len(t, r.pages, int(stats.Bytes/1000))
+}
+
+func TestWorkerExtraAttributes(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<img data-src="/lazy.png"><div data-href="/foo"></div>`)), nil)
+
+	rec := &recordingReporter{}
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithReporter(rec),
+		WithExtraLinkAttributes([]string{"data-href"}),
+		WithExtraAssetAttributes([]string{"data-src"}),
+	)
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	require.NoError(t, err)
+
+	require.Len(t, rec.pages, 1)
+	assert.Equal(t, []string{"/lazy.png"}, rec.pages[0].Assets)
+	assert.Equal(t, []string{willydFoo.String()}, queuedURLs(s.queue))
+}
+
+func TestRunStatusServer(t *testing.T) {
+	var s *Spider
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			// Pause after the root so the crawl is stuck part way through.
+			s.Pause()
+			fmt.Fprint(w, `<a href="/a"></a><a href="/b"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	// Find a free port for the status server.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	s = New(WithRoot(root), WithIgnoreRobots(true), WithStatusServer(addr))
+	errs := make(chan error)
+	go func() {
+		errs <- s.Run()
+	}()
+
+	getStatus := func() (Status, error) {
+		var status Status
+		res, err := http.Get("http://" + addr + "/status")
+		if err != nil {
+			return status, err
+		}
+		defer res.Body.Close()
+		err = json.NewDecoder(res.Body).Decode(&status)
+		return status, err
+	}
+
+	var status Status
+	for i := 0; i < 100 && status.Pages == 0; i++ {
+		time.Sleep(workerPollInterval)
+		status, _ = getStatus()
+	}
+	require.Equal(t, 1, status.Pages)
+	assert.Equal(t, 2, status.QueueDepth)
+	assert.Equal(t, 0, status.Errors)
+	assert.True(t, status.Uptime > 0)
+
+	res, err := http.Get("http://" + addr + "/healthz")
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	s.Resume()
+	require.NoError(t, <-errs)
+
+	// The server is shut down once the crawl is over.
+	_, err = http.Get("http://" + addr + "/healthz")
+	assert.Error(t, err)
+}
+
+func TestWorkerJSLinkExtraction(t *testing.T) {
+	body := `<script>var routes = ["/foo", "/bar", "http://example.com/external"];</script>`
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(body)), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithJSLinkExtraction(regexp.MustCompile(`"([^"]+)"`)),
+	)
+	s.queue.Append(willydURL)
+
+	s.pending.Add(1)
+	err := s.work()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{willydFoo.String(), willydBar.String()}, queuedURLs(s.queue))
+}
+
+func TestWorkerUpgradeInsecureLinks(t *testing.T) {
+	root, err := url.Parse("https://willdemaine.co.uk")
+	require.NoError(t, err)
+	body := []byte(`
+		<a href="/foo"></a>
+		<a href="http://willdemaine.co.uk/foo"></a>
+		<a href="http://willdemaine.co.uk/bar"></a>
+		<a href="http://willdemaine.co.uk:8080/baz"></a>
+	`)
+
+	cases := []struct {
+		name     string
+		upgrade  bool
+		expected []string
+	}{
+		{"disabled", false, []string{
+			"https://willdemaine.co.uk/foo",
+			"http://willdemaine.co.uk/foo",
+			"http://willdemaine.co.uk/bar",
+			"http://willdemaine.co.uk:8080/baz",
+		}},
+		{"enabled", true, []string{
+			"https://willdemaine.co.uk/foo",
+			"https://willdemaine.co.uk/bar",
+			"http://willdemaine.co.uk:8080/baz",
+		}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, root).Return(respond(body), nil)
+
+			s := New(
+				WithRoot(root),
+				WithRequester(requester),
+				WithUpgradeInsecureLinks(test.upgrade),
+			)
+			s.queue.Append(root)
+
+			s.pending.Add(1)
+			err := s.work()
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, queuedURLs(s.queue))
+		})
+	}
+}
+
+func TestWorkerRetryOn(t *testing.T) {
+	forbidden := func(statusCode int, err error) bool {
+		return statusCode == http.StatusForbidden
+	}
+
+	cases := []struct {
+		name     string
+		retryOn  func(int, error) bool
+		status   int
+		attempts int
+		success  bool
+	}{
+		{"default skips 403", nil, http.StatusForbidden, 1, false},
+		{"custom retries 403", forbidden, http.StatusForbidden, 3, true},
+		{"custom skips 503", forbidden, http.StatusServiceUnavailable, 1, false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(nil, httpResponseError{statusCode: test.status}).Twice()
+			onGet(requester, willydURL).Return(respond([]byte("ok")), nil).Once()
+
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithMaxRetryDuration(time.Second),
+				WithRetryOn(test.retryOn),
+			)
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			if test.success {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+			requester.AssertNumberOfCalls(t, "Do", test.attempts)
+		})
+	}
+}
+
+func TestWorkerMixedContent(t *testing.T) {
+	root, err := url.Parse("https://willdemaine.co.uk")
+	require.NoError(t, err)
+	body := []byte(`<img src="/logo.png"><img src="http://willdemaine.co.uk/insecure.png">`)
+
+	requester := &mocks.Requester{}
+	onGet(requester, root).Return(respond(body), nil)
+
+	rec := &recordingReporter{}
+	s := New(WithRoot(root), WithRequester(requester), WithReporter(rec))
+	s.queue.Append(root)
+
+	s.pending.Add(1)
+	err = s.work()
+	require.NoError(t, err)
+
+	require.Len(t, rec.pages, 1)
+	assert.Equal(t, []string{"http://willdemaine.co.uk/insecure.png"}, rec.pages[0].MixedContent)
+}
+
+func TestRunRandomizedQueue(t *testing.T) {
+	crawl := func(seed int64) []string {
+		var lock sync.Mutex
+		var fetched []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			fetched = append(fetched, r.URL.Path)
+			lock.Unlock()
+			if r.URL.Path == "/" {
+				for i := 0; i < 10; i++ {
+					fmt.Fprintf(w, `<a href="/%d"></a>`, i)
+				}
+			}
+		}))
+		defer server.Close()
+
+		root, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		s := New(WithRoot(root), WithIgnoreRobots(true), WithRandomizedQueue(seed))
+		require.NoError(t, s.Run())
+		return fetched
+	}
+
+	first := crawl(1)
+	assert.ElementsMatch(t, []string{"/", "/0", "/1", "/2", "/3", "/4", "/5", "/6", "/7", "/8", "/9"}, first)
+	assert.Equal(t, first, crawl(1))
+	assert.NotEqual(t, first, crawl(2))
+}
+
+func TestRunSampleRate(t *testing.T) {
+	crawl := func(seed int64) []string {
+		var lock sync.Mutex
+		var fetched []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lock.Lock()
+			fetched = append(fetched, r.URL.Path)
+			lock.Unlock()
+			if r.URL.Path == "/" {
+				for i := 0; i < 200; i++ {
+					fmt.Fprintf(w, `<a href="/%d"></a>`, i)
+				}
+			}
+		}))
+		defer server.Close()
+
+		root, err := url.Parse(server.URL)
+		require.NoError(t, err)
+
+		s := New(WithRoot(root), WithIgnoreRobots(true), WithSampleRate(0.25, seed))
+		require.NoError(t, s.Run())
+		return fetched
+	}
+
+	first := crawl(1)
+	assert.Contains(t, first, "/")
+	// Roughly a quarter of the 200 links, plus the root.
+	assert.InDelta(t, 51, len(first), 15)
+	assert.ElementsMatch(t, first, crawl(1))
+}
+
+func TestSampleRateClamped(t *testing.T) {
+	s := New(WithRoot(willydURL), WithSampleRate(-0.5, 1))
+	assert.Equal(t, 0.0, s.sampleRate)
+	s = New(WithRoot(willydURL), WithSampleRate(1.5, 1))
+	assert.Equal(t, 1.0, s.sampleRate)
+}
+
+func TestRunRobotsAgentGroups(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydRobots).Return(respond([]byte(`
+User-agent: *
+Disallow: /foo
+
+User-agent: gospider
+Disallow: /bar
+`)), nil)
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+
+	s := New(WithRoot(willydURL), WithRequester(requester))
+	err := s.Run()
+	require.NoError(t, err)
+	requester.AssertCalled(t, "Do", mock.Anything, http.MethodGet, willydFoo, mock.Anything, mock.Anything)
+	requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, willydBar, mock.Anything, mock.Anything)
+}
+
+func TestRunRobotsUserAgent(t *testing.T) {
+	robots := []byte(`
+User-agent: *
+Disallow: /foo
+
+User-agent: mybot
+Disallow: /bar
+`)
+	cases := []struct {
+		name    string
+		options []Option
+		allowed *url.URL
+		denied  *url.URL
+	}{
+		// The product portion of the user agent is Mozilla, which only matches the wildcard.
+		{"product token", nil, willydBar, willydFoo},
+		{"robots user agent", []Option{WithRobotsUserAgent("mybot")}, willydFoo, willydBar},
+	}
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydRobots).Return(respond(robots), nil)
+			onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+			onGet(requester, test.allowed).Return(respond([]byte("allowed")), nil)
+
+			options := append([]Option{
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithUserAgent("Mozilla/5.0 (compatible; mybot/1.0)"),
+			}, test.options...)
+			require.NoError(t, New(options...).Run())
+			requester.AssertCalled(t, "Do", mock.Anything, http.MethodGet, test.allowed, mock.Anything, mock.Anything)
+			requester.AssertNotCalled(t, "Do", mock.Anything, http.MethodGet, test.denied, mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestRunCollapseIndexPages(t *testing.T) {
+	cases := []struct {
+		name     string
+		collapse bool
+		expected []string
+		fetched  []string
+	}{
+		{"disabled", false, []string{"", "/dir", "/dir/", "/dir/index.html"}, []string{"/", "/dir", "/dir/", "/dir/index.html"}},
+		// The first form linked is fetched as it was linked.
+		{"enabled", true, []string{"/", "/dir/"}, []string{"/", "/dir"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var fetched []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				fetched = append(fetched, r.URL.Path)
+				lock.Unlock()
+				fmt.Fprint(w, `<a href="/dir"></a><a href="/dir/"></a><a href="/dir/index.html"></a>`)
+			}))
+			defer server.Close()
+
+			root, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			s := New(WithRoot(root), WithIgnoreRobots(true), WithCollapseIndexPages(test.collapse))
+			require.NoError(t, s.Run())
+
+			// The server sees the root as "/" either way, so compare what was crawled instead.
+			var crawled []string
+			for u := range s.queue.seen {
+				crawled = append(crawled, strings.TrimPrefix(u, server.URL))
+			}
+			assert.ElementsMatch(t, test.expected, crawled)
+			assert.ElementsMatch(t, test.fetched, fetched)
+		})
+	}
+}
+
+func TestRunIgnoreQueryStrings(t *testing.T) {
+	cases := []struct {
+		name     string
+		ignore   bool
+		expected []string
+	}{
+		{"disabled", false, []string{"", "/page", "/page?a=1", "/page?a=2&b=3", "/page?"}},
+		{"enabled", true, []string{"", "/page"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `<a href="/page"></a><a href="/page?a=1"></a><a href="/page?a=2&b=3"></a><a href="/page?"></a>`)
+			}))
+			defer server.Close()
+
+			root, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			s := New(WithRoot(root), WithIgnoreRobots(true), WithIgnoreQueryStrings(test.ignore))
+			require.NoError(t, s.Run())
+
+			var crawled []string
+			for u := range s.queue.seen {
+				crawled = append(crawled, strings.TrimPrefix(u, server.URL))
+			}
+			assert.ElementsMatch(t, test.expected, crawled)
+		})
+	}
+}
+
+func TestRunHeaderFunc(t *testing.T) {
+	requester := &mocks.Requester{}
+	requester.On("Do", mock.Anything, http.MethodGet, willydURL, mock.Anything, http.Header{"Authorization": {"root"}}).
+		Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	requester.On("Do", mock.Anything, http.MethodGet, willydFoo, mock.Anything, http.Header{"Authorization": {"foo"}}).
+		Return(respond([]byte("foo")), nil)
+	requester.On("Do", mock.Anything, http.MethodGet, willydBar, mock.Anything, http.Header(nil)).
+		Return(respond([]byte("bar")), nil)
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithHeaderFunc(func(uri *url.URL) http.Header {
+			switch uri.Path {
+			case "/foo":
+				return http.Header{"Authorization": {"foo"}}
+			case "/bar":
+				return nil
+			}
+			return http.Header{"Authorization": {"root"}}
+		}),
+	)
+	require.NoError(t, s.Run())
+	requester.AssertExpectations(t)
+}
+
+func TestRunBrowserHeaders(t *testing.T) {
+	var lock sync.Mutex
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		headers = append(headers, r.Header)
+		lock.Unlock()
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a"></a><a href="/b"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithBrowserHeaders(true),
+		WithHeaderFunc(func(*url.URL) http.Header {
+			return http.Header{"Accept-Language": {"fr"}}
+		}),
+	)
+	require.NoError(t, s.Run())
+
+	require.Len(t, headers, 3)
+	agents := make(map[string]bool)
+	for _, header := range headers {
+		agents[header.Get("User-Agent")] = true
+		assert.True(t, strings.HasPrefix(header.Get("User-Agent"), "Mozilla/5.0 ("), header.Get("User-Agent"))
+		assert.Contains(t, header.Get("Accept"), "text/html")
+		assert.Equal(t, "fr", header.Get("Accept-Language"))
+		// Headers only Chrome sends come with Chrome's user agent.
+		if header.Get("Sec-Ch-Ua") != "" {
+			assert.Contains(t, header.Get("User-Agent"), "Chrome/")
+		}
+	}
+	assert.Len(t, agents, 3)
+}
+
+func TestRunBrowserHeadersDisabled(t *testing.T) {
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	require.NoError(t, New(WithRoot(root), WithIgnoreRobots(true)).Run())
+	assert.NotContains(t, header.Get("User-Agent"), "Mozilla")
+	assert.Empty(t, header.Get("Accept-Language"))
+}
+
+func TestRunContextPropagation(t *testing.T) {
+	var lock sync.Mutex
+	headers := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		headers[r.URL.Path] = r.Header.Get("Traceparent")
+		lock.Unlock()
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/about"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	type traceKey struct{}
+	var calls int64
+	s := New(
+		WithRoot(root),
+		WithContextPropagation(func(ctx context.Context, req *http.Request) {
+			atomic.AddInt64(&calls, 1)
+			if trace, ok := ctx.Value(traceKey{}).(string); ok {
+				req.Header.Set("Traceparent", trace)
+			}
+		}),
+	)
+	err = s.RunContext(context.WithValue(context.Background(), traceKey{}, "00-trace-span-01"))
+	require.NoError(t, err)
+
+	// robots.txt, the root and the page it links to.
+	assert.Equal(t, int64(3), atomic.LoadInt64(&calls))
+	assert.Equal(t, map[string]string{
+		"/robots.txt": "00-trace-span-01",
+		"/":           "00-trace-span-01",
+		"/about":      "00-trace-span-01",
+	}, headers)
+}
+
+// parseTracker records how many bodies are being read past their start at once. Pages are
+// parsed as they're read, so this is how many are being parsed.
+type parseTracker struct {
+	active int64
+	max    int64
+}
+
+func (p *parseTracker) body(head string) io.ReadCloser {
+	return ioutil.NopCloser(&trackedBody{tracker: p, head: head})
+}
+
+// trackedBody returns its head straight away, so that it can be sniffed before parsing starts,
+// and then slowly returns the end of the page.
+type trackedBody struct {
+	tracker  *parseTracker
+	head     string
+	headRead bool
+	done     bool
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	if !b.headRead {
+		b.headRead = true
+		return copy(p, b.head), nil
+	}
+	if b.done {
+		return 0, io.EOF
+	}
+	b.done = true
+	active := atomic.AddInt64(&b.tracker.active, 1)
+	defer atomic.AddInt64(&b.tracker.active, -1)
+	for {
+		max := atomic.LoadInt64(&b.tracker.max)
+		if active <= max || atomic.CompareAndSwapInt64(&b.tracker.max, max, active) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond * 20)
+	return copy(p, "</html>"), nil
+}
+
+func TestRunConcurrencyWithRedirects(t *testing.T) {
+	var active, max int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			old := atomic.LoadInt32(&max)
+			if n <= old || atomic.CompareAndSwapInt32(&max, old, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond * 5)
+
+		// Every page redirects through a chain of hops before it responds.
+		hops := r.URL.Query().Get("hops")
+		switch {
+		case r.URL.Path == "/":
+			for i := 0; i < 10; i++ {
+				fmt.Fprintf(w, `<a href="/%d"></a>`, i)
+			}
+		case hops == "":
+			http.Redirect(w, r, r.URL.Path+"?hops=1", http.StatusFound)
+		case hops == "1" || hops == "2":
+			http.Redirect(w, r, r.URL.Path+"?hops="+string(hops[0]+1), http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var onComplete RunStats
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithConcurrency(3),
+		WithOnComplete(func(stats RunStats) {
+			onComplete = stats
+		}),
+	)
+	require.NoError(t, s.Run())
+	assert.Equal(t, 11, onComplete.Pages)
+	assert.True(t, atomic.LoadInt32(&max) <= 3, "%d requests in flight", max)
+}
+
+func TestRunMaxConcurrentParses(t *testing.T) {
+	// The head is longer than is needed to sniff the content type.
+	padding := strings.Repeat(" ", sniffLen)
+	var links string
+	for i := 0; i < 12; i++ {
+		links += fmt.Sprintf(`<a href="/%d"></a>`, i)
+	}
+
+	cases := []struct {
+		name     string
+		max      int
+		expected func(t *testing.T, max int64)
+	}{
+		{"limited", 2, func(t *testing.T, max int64) {
+			assert.True(t, max > 0 && max <= 2, "%d parses at once", max)
+		}},
+		{"unlimited", 0, func(t *testing.T, max int64) {
+			assert.True(t, max > 2, "at most %d parses at once", max)
+		}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			tracker := &parseTracker{}
+			requester := &mocks.Requester{}
+			requester.On("Do", mock.Anything, http.MethodGet, mock.Anything, mock.Anything, mock.Anything).Return(
+				func(_ context.Context, _ string, uri *url.URL, _ io.Reader, _ http.Header) *http.Response {
+					head := "<html>" + padding
+					if uri.Path == "" {
+						head += links
+					}
+					return &http.Response{StatusCode: http.StatusOK, Body: tracker.body(head)}
+				}, nil)
+
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithIgnoreRobots(true),
+				WithConcurrency(6),
+				WithMaxConcurrentParses(test.max),
+			)
+			err := s.Run()
+			require.NoError(t, err)
+			assert.Equal(t, 13, s.Stats().Pages)
+			test.expected(t, atomic.LoadInt64(&tracker.max))
+		})
+	}
+}
+
+func TestRunFocusKeywords(t *testing.T) {
+	var lock sync.Mutex
+	var fetched []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		fetched = append(fetched, r.URL.Path)
+		lock.Unlock()
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/tutorials/go">Go</a>`)
+			fmt.Fprint(w, `<a href="/a">About</a><a href="/b">Read the <b>Tutorial</b></a><a href="/c">Careers</a>`)
+			fmt.Fprint(w, `<a href="/pricing-tutorial">Pricing</a><a href="/d">Blog</a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithFocusKeywords([]string{"tutorial", "PRICING"}),
+	)
+	require.NoError(t, s.Run())
+
+	require.Len(t, fetched, 7)
+	assert.Equal(t, "/", fetched[0])
+	// Matching both keywords beats matching one, which beats matching none.
+	assert.Equal(t, "/pricing-tutorial", fetched[1])
+	assert.ElementsMatch(t, []string{"/tutorials/go", "/b"}, fetched[2:4])
+	assert.ElementsMatch(t, []string{"/a", "/c", "/d"}, fetched[4:])
+}
+
+func TestRunReportCallback(t *testing.T) {
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><img src="/logo.png">`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+
+	var lock sync.Mutex
+	pages := make(map[string]reporter.PageInfo)
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithConcurrency(2),
+		WithReportCallback(func(page reporter.PageInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			pages[page.URL.String()] = page
+		}),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	require.Len(t, pages, 2)
+	root := pages[willydURL.String()]
+	assert.Equal(t, []*url.URL{willydFoo}, root.Links)
+	assert.Equal(t, []string{"/logo.png"}, root.Assets)
+	assert.Equal(t, http.StatusOK, root.StatusCode)
+	foo := pages[willydFoo.String()]
+	assert.Equal(t, 1, foo.Depth)
+	assert.Empty(t, foo.Assets)
+}
+
+func TestRunAssetClassification(t *testing.T) {
+	for _, classify := range []bool{true, false} {
+		t.Run(fmt.Sprint(classify), func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond([]byte(
+				`<link rel="stylesheet" href="/main.css"><img src="/logo"><img src="/logo"><script src="/app.js"></script>`,
+			)), nil)
+
+			var pages []reporter.PageInfo
+			s := New(
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithIgnoreRobots(true),
+				WithAssetClassification(classify),
+				WithReportCallback(func(page reporter.PageInfo) {
+					pages = append(pages, page)
+				}),
+			)
+			err := s.Run()
+			require.NoError(t, err)
+
+			require.Len(t, pages, 1)
+			assert.Equal(t, []string{"/main.css", "/logo", "/app.js"}, pages[0].Assets)
+			if !classify {
+				assert.Nil(t, pages[0].AssetKinds)
+				return
+			}
+			assert.Equal(t, map[string]string{
+				"/main.css": "stylesheet",
+				"/logo":     "image",
+				"/app.js":   "script",
+			}, pages[0].AssetKinds)
+		})
+	}
+}
+
+// slowBodyReader waits before every read, like a body trickling in over the network.
+type slowBodyReader struct {
+	reader io.Reader
+	delay  time.Duration
+}
+
+func (r *slowBodyReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.reader.Read(p)
+}
+
+func TestRunParseDuration(t *testing.T) {
+	// A large page, so that parsing takes a measurable amount of time.
+	body := []byte("<html>" + strings.Repeat(`<div><p><span>text</span><img src="/logo.png"></p></div>`, 1<<13) + "</html>")
+
+	cases := []struct {
+		name    string
+		options []Option
+		// streaming parses the body while it is being read.
+		streaming bool
+	}{
+		{"streaming", nil, true},
+		{"buffered", []Option{WithLenientParsing(true)}, false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(func(context.Context, string, *url.URL, io.Reader, http.Header) *http.Response {
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       ioutil.NopCloser(&slowBodyReader{reader: bytes.NewReader(body), delay: time.Millisecond * 5}),
+				}
+			}, nil)
+
+			var pages []reporter.PageInfo
+			s := New(append([]Option{
+				WithRoot(willydURL),
+				WithRequester(requester),
+				WithIgnoreRobots(true),
+				WithReportCallback(func(page reporter.PageInfo) {
+					pages = append(pages, page)
+				}),
+			}, test.options...)...)
+			err := s.Run()
+			require.NoError(t, err)
+
+			require.Len(t, pages, 1)
+			assert.True(t, pages[0].ParseDuration > 0)
+			if !test.streaming {
+				return
+			}
+			// Waiting for the body counts towards the latency but not the parse duration.
+			assert.True(t, pages[0].ParseDuration < pages[0].Latency/2,
+				"parse %s, latency %s", pages[0].ParseDuration, pages[0].Latency)
+		})
+	}
+}
+
+func TestRunDNSError(t *testing.T) {
+	missing, err := url.Parse("http://nope.willdemaine.co.uk/foo")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="http://nope.willdemaine.co.uk/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+	onGet(requester, missing).Return(nil, dnsErr)
+
+	var stats RunStats
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+		WithMaxRetryDuration(time.Second),
+		WithOnComplete(func(s RunStats) {
+			stats = s
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Pages)
+	assert.Equal(t, 1, stats.Errors)
+	requester.AssertNumberOfCalls(t, "Do", 3)
+
+	buf := bytes.NewBuffer(nil)
+	err = s.Report(buf)
+	require.NoError(t, err)
+	assert.Contains(t, buf.String(), "Unreachable hosts")
+	assert.Contains(t, buf.String(), "<li>nope.willdemaine.co.uk")
+}
+
+func TestRunDNSErrorWithRetryOn(t *testing.T) {
+	missing, err := url.Parse("http://nope.willdemaine.co.uk/foo")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="http://nope.willdemaine.co.uk/foo"></a>`)), nil)
+	onGet(requester, missing).Return(nil, &net.DNSError{Err: "no such host", Name: "nope.willdemaine.co.uk"})
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+		WithMaxRetryDuration(time.Second),
+		// Retry everything, including failures without a response.
+		WithRetryOn(func(int, error) bool { return true }),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+	requester.AssertNumberOfCalls(t, "Do", 2)
+}
+
+func TestWorkerMaxUniqueQueryKeys(t *testing.T) {
+	body := []byte(`
+		<a href="/search?q=a"></a>
+		<a href="/search?q=a&amp;colour=red&amp;size=m"></a>
+		<a href="/search?q=a&amp;colour=red&amp;size=m&amp;brand=x&amp;sort=price"></a>
+	`)
+
+	cases := []struct {
+		name     string
+		max      int
+		expected []string
+	}{
+		{"no limit", 0, []string{"q=a", "colour=red&q=a&size=m", "brand=x&colour=red&q=a&size=m&sort=price"}},
+		{"limit", 3, []string{"q=a", "colour=red&q=a&size=m"}},
+		{"strict", 1, []string{"q=a"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			requester := &mocks.Requester{}
+			onGet(requester, willydURL).Return(respond(body), nil)
+
+			s := New(WithRoot(willydURL), WithRequester(requester), WithMaxUniqueQueryKeys(test.max))
+			s.queue.Append(willydURL)
+
+			s.pending.Add(1)
+			err := s.work()
+			require.NoError(t, err)
+
+			var queued []string
+			for _, item := range s.queue.items {
+				queued = append(queued, item.url.Query().Encode())
+			}
+			assert.ElementsMatch(t, test.expected, queued)
+		})
+	}
+}
+
+func TestRunReportSitemap(t *testing.T) {
+	sitemap, err := url.Parse("http://willdemaine.co.uk/sitemap.xml")
+	require.NoError(t, err)
+	orphan, err := url.Parse("http://willdemaine.co.uk/orphan")
+	require.NoError(t, err)
+
+	requester := &mocks.Requester{}
+	onGet(requester, willydURL).Return(respond([]byte(`<a href="/foo"></a><a href="/bar"></a>`)), nil)
+	onGet(requester, sitemap).Return(respond([]byte(`
+		<urlset>
+			<url><loc>http://willdemaine.co.uk/foo</loc></url>
+			<url><loc>http://willdemaine.co.uk/orphan</loc></url>
+			<url><loc>http://foo.bar.co.uk/external</loc></url>
+		</urlset>
+	`)), nil)
+	onGet(requester, willydFoo).Return(respond([]byte("foo")), nil)
+	onGet(requester, willydBar).Return(respond([]byte("bar")), nil)
+	onGet(requester, orphan).Return(respond([]byte("orphan")), nil)
+
+	r := &recordingReporter{}
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithSitemapSeeding(true),
+		WithReportSitemap(true),
+		WithReporter(r),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	pages := make(map[string]reporter.PageInfo)
+	for _, page := range r.pages {
+		pages[page.URL.String()] = page
+	}
+	require.Len(t, pages, 5)
+
+	node := pages[sitemap.String()]
+	assert.True(t, node.IsSitemap)
+	assert.Equal(t, []*url.URL{willydFoo, orphan}, node.Links)
+
+	assert.Nil(t, pages[willydURL.String()].Referrer)
+	assert.Equal(t, sitemap, pages[orphan.String()].Referrer)
+	assert.Equal(t, sitemap, pages[willydFoo.String()].Referrer)
+	assert.Equal(t, willydURL, pages[willydBar.String()].Referrer)
+}
+
+func TestWorkerHostTimeout(t *testing.T) {
+	slow, err := url.Parse("http://slow.willdemaine.co.uk/foo")
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	timeouts := make(map[string]time.Duration)
+	requester := &mocks.Requester{}
+	requester.On("Do", mock.Anything, http.MethodGet, mock.Anything, mock.Anything, mock.Anything).
+		Return(respond([]byte("ok")), nil).
+		Run(func(args mock.Arguments) {
+			deadline, ok := args.Get(0).(context.Context).Deadline()
+			require.True(t, ok)
+			lock.Lock()
+			defer lock.Unlock()
+			timeouts[args.Get(2).(*url.URL).Hostname()] = time.Until(deadline)
+		})
+
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithTimeout(time.Second*5),
+		WithHostTimeout(map[string]time.Duration{"Slow.willdemaine.co.uk": time.Second * 30}),
+	)
+	s.queue.Append(willydFoo)
+	s.queue.Append(slow)
+
+	s.pending.Add(2)
+	require.NoError(t, s.work())
+	require.NoError(t, s.work())
+
+	assert.InDelta(t, time.Second*5, timeouts["willdemaine.co.uk"], float64(time.Second))
+	assert.InDelta(t, time.Second*30, timeouts["slow.willdemaine.co.uk"], float64(time.Second))
+}
+
+func TestRunTitlesAndDescriptions(t *testing.T) {
+	pages := map[string]string{
+		"/": `<title>Will Demaine</title><meta name="description" content="Home">
+			<a href="/posts"></a><a href="/about"></a><a href="/logo.txt"></a>`,
+		"/posts":    `<title>Will Demaine</title><meta name="description" content="Posts">`,
+		"/about":    `<title>About</title>`,
+		"/logo.txt": `not a page`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logo.txt" {
+			w.Header().Set("Content-Type", "text/plain")
+		}
+		fmt.Fprint(w, pages[r.URL.Path])
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	html := reporter.NewHTML()
+	s := New(WithRoot(root), WithIgnoreRobots(true), WithReporter(html))
+	require.NoError(t, s.Run())
+
+	buf := bytes.NewBuffer(nil)
+	require.NoError(t, s.Report(buf))
+	report := buf.String()
+
+	start := strings.Index(report, "Duplicate titles")
+	require.True(t, start >= 0)
+	section := report[start : start+strings.Index(report[start:], "</div>")]
+	assert.Contains(t, section, "Will Demaine")
+	assert.Contains(t, section, server.URL+"/posts")
+	assert.NotContains(t, section, "About")
+
+	start = strings.Index(report, "Missing descriptions")
+	require.True(t, start >= 0)
+	section = report[start : start+strings.Index(report[start:], "</div>")]
+	assert.Contains(t, section, server.URL+"/about")
+	assert.NotContains(t, section, server.URL+"/posts")
+	assert.NotContains(t, section, "logo.txt")
+}
+
+func TestWorkerResponseHeaderTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(
+		WithRoot(root),
+		WithTimeout(time.Second*10),
+		WithConnectTimeout(time.Second),
+		WithResponseHeaderTimeout(time.Millisecond*100),
+	)
+	s.queue.Append(root)
+
+	s.pending.Add(1)
+	start := time.Now()
+	err = s.work()
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) < time.Second*5, "took %s", time.Since(start))
+}
+
+func TestRunWithDialer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			io.WriteString(w, `<a href="/about"></a>`)
+			return
+		}
+		io.WriteString(w, "<p>about</p>")
+	}))
+	defer server.Close()
+
+	root, err := url.Parse("http://example.test/")
+	require.NoError(t, err)
+
+	var dialed []string
+	var dialedLock sync.Mutex
+	dialer := &net.Dialer{}
+	var stats RunStats
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialedLock.Lock()
+			dialed = append(dialed, addr)
+			dialedLock.Unlock()
+			return dialer.DialContext(ctx, network, server.Listener.Addr().String())
+		}),
+		WithOnComplete(func(s RunStats) {
+			stats = s
+		}),
+	)
+
+	err = s.Run()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, stats.Pages)
+	assert.Equal(t, 0, stats.Errors)
+	require.NotEmpty(t, dialed)
+	assert.Equal(t, "example.test:80", dialed[0])
+}
+
+func TestWorkerMaxParseBytes(t *testing.T) {
+	// The links near the start fit in the limit, but the one after the padding doesn't.
+	body := `<a href="/foo"></a><a href="/bar"></a>` + strings.Repeat("<p>padding</p>", 1<<16) + `<a href="/baz"></a>`
+
+	cases := []struct {
+		name    string
+		options []Option
+		// streaming stops reading the body once the limit is reached.
+		partialBody bool
+	}{
+		{"streaming", nil, true},
+		{"buffered", []Option{WithLenientParsing(true)}, false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, body)
+			}))
+			defer server.Close()
+
+			root, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			rec := &recordingReporter{}
+			options := append([]Option{WithRoot(root), WithReporter(rec), WithMaxParseBytes(4096)}, test.options...)
+			s := New(options...)
+			s.queue.Append(root)
+
+			s.pending.Add(1)
+			err = s.work()
+			require.NoError(t, err)
+
+			require.Len(t, rec.pages, 1)
+			var links []string
+			for _, link := range rec.pages[0].Links {
+				links = append(links, link.Path)
+			}
+			assert.Equal(t, []string{"/foo", "/bar"}, links)
+			assert.Equal(t, test.partialBody, rec.pages[0].Size < int64(len(body)))
+		})
+	}
+}
+
+func TestRunDelayFunc(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 20)
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a"></a><a href="/b"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	var latencies []time.Duration
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithDelayFunc(func(host string, lastLatency time.Duration) time.Duration {
+			assert.Equal(t, root.Hostname(), host)
+			lock.Lock()
+			defer lock.Unlock()
+			latencies = append(latencies, lastLatency)
+			return time.Millisecond * 100
+		}),
+	)
+	start := time.Now()
+	require.NoError(t, s.Run())
+
+	require.Len(t, latencies, 3)
+	assert.Equal(t, time.Duration(0), latencies[0])
+	assert.True(t, latencies[1] >= time.Millisecond*20, "last latency %s", latencies[1])
+	assert.True(t, latencies[2] >= time.Millisecond*20, "last latency %s", latencies[2])
+	assert.True(t, time.Since(start) >= time.Millisecond*300, "took %s", time.Since(start))
+}
+
+func TestRunMaxCrawlDelay(t *testing.T) {
+	var lock sync.Mutex
+	var requested []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fmt.Fprint(w, "User-agent: *\nCrawl-delay: 3600\n")
+			return
+		}
+		lock.Lock()
+		requested = append(requested, time.Now())
+		lock.Unlock()
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a"></a><a href="/b"></a>`)
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(WithRoot(root), WithConcurrency(3), WithMaxCrawlDelay(time.Millisecond*50))
+	start := time.Now()
+	require.NoError(t, s.Run())
+
+	assert.Equal(t, time.Millisecond*50, s.crawlDelay)
+	assert.True(t, time.Since(start) < time.Second*5, "took %s", time.Since(start))
+	// Requests are spaced out even though there are enough workers to make them at once.
+	require.Len(t, requested, 3)
+	for i := 1; i < len(requested); i++ {
+		gap := requested[i].Sub(requested[i-1])
+		assert.True(t, gap >= time.Millisecond*40, "gap %s", gap)
+	}
+}
+
+func TestHostScheduleReserve(t *testing.T) {
+	schedule := newHostSchedule()
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), schedule.reserve("a", time.Second, now))
+	assert.Equal(t, time.Second, schedule.reserve("a", time.Second, now))
+	assert.Equal(t, time.Second*2, schedule.reserve("a", time.Second, now))
+	assert.Equal(t, time.Duration(0), schedule.reserve("b", time.Second, now))
+	// Slots in the past aren't made up for.
+	assert.Equal(t, time.Duration(0), schedule.reserve("b", time.Second, now.Add(time.Minute)))
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	bucket := newTokenBucket(2)
+	now := time.Now()
+	assert.Equal(t, time.Duration(0), bucket.reserve(now))
+	assert.Equal(t, time.Millisecond*500, bucket.reserve(now))
+	assert.Equal(t, time.Second, bucket.reserve(now))
+	// Waiting pays off what's owed, but doesn't save up more than one token.
+	assert.Equal(t, time.Duration(0), bucket.reserve(now.Add(time.Minute)))
+	assert.Equal(t, time.Millisecond*500, bucket.reserve(now.Add(time.Minute)))
+
+	var none *tokenBucket
+	assert.Equal(t, time.Duration(0), none.reserve(now))
+}
+
+func TestRunGlobalRateLimit(t *testing.T) {
+	var body bytes.Buffer
+	for i := 0; i < 5; i++ {
+		fmt.Fprintf(&body, `<a href="/%d"></a><a href="http://blog.willdemaine.co.uk/%d"></a>`, i, i)
+	}
+
+	var lock sync.Mutex
+	var times []time.Time
+	requester := &mocks.Requester{}
+	requester.On("Do", mock.Anything, http.MethodGet, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(mock.Arguments) {
+			lock.Lock()
+			times = append(times, time.Now())
+			lock.Unlock()
+		}).
+		Return(respond(body.Bytes()), nil)
+
+	rate := 20.0
+	s := New(
+		WithRoot(willydURL),
+		WithRequester(requester),
+		WithIgnoreRobots(true),
+		WithFollowSubdomains(true),
+		WithConcurrency(5),
+		WithGlobalRateLimit(rate),
+	)
+	err := s.Run()
+	require.NoError(t, err)
+
+	// Requests to both hosts share the limit, so the first can go straight away and each of
+	// the rest waits its turn.
+	require.Len(t, times, 11)
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+	elapsed := times[len(times)-1].Sub(times[0])
+	minimum := time.Duration(float64(len(times)-1) / rate * float64(time.Second))
+	assert.True(t, elapsed >= minimum-time.Millisecond*20, "%d requests in %s", len(times), elapsed)
+}
+
+func TestRunStatsPolling(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 10)
+		if r.URL.Path == "/" {
+			for i := 0; i < 20; i++ {
+				fmt.Fprintf(w, `<a href="/%d"></a>`, i)
+			}
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	s := New(WithRoot(root), WithIgnoreRobots(true), WithConcurrency(3))
+	errs := make(chan error)
+	go func() {
+		errs <- s.Run()
+	}()
+
+	var polls []RunStats
+	var runErr error
+	for running := true; running; {
+		select {
+		case runErr = <-errs:
+			running = false
+		case <-time.After(time.Millisecond * 5):
+			polls = append(polls, s.Stats())
+		}
+	}
+	require.NoError(t, runErr)
+	require.NotEmpty(t, polls)
+
+	for i := 1; i < len(polls); i++ {
+		assert.True(t, polls[i].Pages >= polls[i-1].Pages, "pages went backwards")
+		assert.True(t, polls[i].Bytes >= polls[i-1].Bytes, "bytes went backwards")
+		assert.True(t, polls[i].InFlight <= 3, "more pages in flight than workers")
+	}
+
+	final := s.Stats()
+	assert.Equal(t, 21, final.Pages)
+	assert.Equal(t, 0, final.InFlight)
+	assert.Equal(t, 0, final.Queued)
+}
+
+func TestRunProgress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond * 10)
+		if r.URL.Path == "/" {
+			for i := 0; i < 10; i++ {
+				fmt.Fprintf(w, `<a href="/%d"></a>`, i)
+			}
+		}
+	}))
+	defer server.Close()
+
+	root, err := url.Parse(server.URL)
+	require.NoError(t, err)
+
+	var lock sync.Mutex
+	var calls []RunStats
+	s := New(
+		WithRoot(root),
+		WithIgnoreRobots(true),
+		WithProgress(time.Millisecond*5, func(stats RunStats) {
+			lock.Lock()
+			defer lock.Unlock()
+			calls = append(calls, stats)
+		}),
+	)
+	err = s.Run()
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.True(t, len(calls) > 1, "only %d calls", len(calls))
+	for i := 1; i < len(calls); i++ {
+		assert.True(t, calls[i].Pages >= calls[i-1].Pages, "pages went backwards")
+		assert.True(t, calls[i].Duration >= calls[i-1].Duration, "duration went backwards")
+	}
+	final := calls[len(calls)-1]
+	assert.Equal(t, 11, final.Pages)
+	assert.Equal(t, 0, final.Queued)
+}
+
+func TestWithLogger(t *testing.T) {
+	logger := zap.NewNop()
+	s := New(WithRoot(willydURL), WithLogger(logger))
+	assert.Equal(t, logger, s.logger)
+	assert.Equal(t, logger, s.requester.(client).logger)
+}
+
+func TestRunRespectMetaRobots(t *testing.T) {
+	pages := map[string]string{
+		"/":         `<a href="/noindex"></a><a href="/nofollow"></a>`,
+		"/noindex":  `<html><meta name="robots" content="noindex"><a href="/a"></a>`,
+		"/nofollow": `<html><meta name="robots" content="nofollow"><a href="/b"></a>`,
+	}
+
+	cases := []struct {
+		name     string
+		respect  bool
+		fetched  []string
+		reported []string
+	}{
+		{"ignored", false, []string{"/", "/noindex", "/nofollow", "/a", "/b"}, []string{"", "/noindex", "/nofollow", "/a", "/b"}},
+		{"respected", true, []string{"/", "/noindex", "/nofollow", "/a"}, []string{"", "/nofollow", "/a"}},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var fetched []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				fetched = append(fetched, r.URL.Path)
+				lock.Unlock()
+				fmt.Fprint(w, pages[r.URL.Path])
+			}))
+			defer server.Close()
+
+			root, err := url.Parse(server.URL)
+			require.NoError(t, err)
+
+			rec := &recordingReporter{}
+			s := New(WithRoot(root), WithIgnoreRobots(true), WithReporter(rec), WithRespectMetaRobots(test.respect))
+			require.NoError(t, s.Run())
+
+			var reported []string
+			for _, page := range rec.pages {
+				reported = append(reported, page.URL.Path)
+			}
+			assert.ElementsMatch(t, test.fetched, fetched)
+			assert.ElementsMatch(t, test.reported, reported)
+		})
+	}
+}
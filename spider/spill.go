@@ -0,0 +1,187 @@
+package spider
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// queueItemOverhead estimates the memory used by a queued item besides its URLs and body, such
+// as the item itself and its entry in the seen set.
+const queueItemOverhead = 200
+
+// estimateSize estimates how much memory the item takes up while it's queued.
+func estimateSize(item *queueItem) int64 {
+	size := int64(queueItemOverhead + len(item.url.String()) + len(item.method))
+	if item.referrer != nil {
+		size += int64(len(item.referrer.String()))
+	}
+	if item.body != nil {
+		size += int64(len(item.body.Encode()))
+	}
+	return size
+}
+
+// queueSpill holds the items which didn't fit within WithMaxQueueBytes in a temporary file, one
+// JSON line each, until there's room for them again.
+type queueSpill struct {
+	w *os.File
+	f *os.File
+	r *bufio.Reader
+	// offset is how far into the file has been read back.
+	offset int64
+	// count is the number of items written which haven't been read back yet.
+	count int
+}
+
+func newQueueSpill() (*queueSpill, error) {
+	w, err := ioutil.TempFile("", "gospider-queue")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create queue spill file")
+	}
+	f, err := os.Open(w.Name())
+	if err != nil {
+		w.Close()
+		os.Remove(w.Name())
+		return nil, errors.Wrap(err, "failed to open queue spill file")
+	}
+	return &queueSpill{w: w, f: f, r: bufio.NewReader(f)}, nil
+}
+
+// write adds the item to the end of the file.
+func (s *queueSpill) write(item *queueItem) error {
+	line, err := json.Marshal(newSavedItem(item))
+	if err != nil {
+		return err
+	}
+	// Write the whole line at once, so that the reader never sees half of one.
+	if _, err := s.w.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "failed to write queue spill file")
+	}
+	s.count++
+	return nil
+}
+
+// read takes the oldest item from the file. It must only be called if count is above zero.
+func (s *queueSpill) read() (*queueItem, error) {
+	line, err := s.r.ReadBytes('\n')
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read queue spill file")
+	}
+	s.offset += int64(len(line))
+	s.count--
+	var saved savedItem
+	if err := json.Unmarshal(line, &saved); err != nil {
+		return nil, errors.Wrap(err, "invalid queue spill file")
+	}
+	return saved.queueItem()
+}
+
+// pending returns the items which haven't been read back, without taking them.
+func (s *queueSpill) pending() ([]savedItem, error) {
+	f, err := os.Open(s.w.Name())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open queue spill file")
+	}
+	defer f.Close()
+	if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "failed to read queue spill file")
+	}
+	decoder := json.NewDecoder(f)
+	items := make([]savedItem, 0, s.count)
+	for i := 0; i < s.count; i++ {
+		var saved savedItem
+		if err := decoder.Decode(&saved); err != nil {
+			return nil, errors.Wrap(err, "invalid queue spill file")
+		}
+		items = append(items, saved)
+	}
+	return items, nil
+}
+
+// close removes the file.
+func (s *queueSpill) close() error {
+	s.w.Close()
+	s.f.Close()
+	return os.Remove(s.w.Name())
+}
+
+// spillOrPush adds the item to the queue, or to the spill file if the queue is using more than
+// maxBytes. Prioritized items are always kept in memory. The lock must be held.
+func (q *urlQueue) spillOrPush(item *queueItem) {
+	if q.maxBytes <= 0 {
+		q.push(item)
+		return
+	}
+	size := estimateSize(item)
+	if item.priority > 0 || (q.bytes+size <= q.maxBytes && q.spilled() == 0) {
+		q.bytes += size
+		q.push(item)
+		return
+	}
+	if q.spill == nil {
+		spill, err := newQueueSpill()
+		if err != nil {
+			q.logger.Warn("Queue is over its memory limit but can't spill to disk", zap.Error(err))
+			q.bytes += size
+			q.push(item)
+			return
+		}
+		q.spill = spill
+	}
+	if err := q.spill.write(item); err != nil {
+		q.logger.Warn("Queue is over its memory limit but can't spill to disk", zap.Error(err))
+		q.bytes += size
+		q.push(item)
+	}
+}
+
+// unspill reads spilled items back into the queue until it's half full, leaving room for the
+// links they lead to. If the file can't be read, the rest of it is dropped and the error is
+// kept for spillError. The lock must be held.
+func (q *urlQueue) unspill() {
+	for q.spilled() > 0 && (q.bytes < q.maxBytes/2 || len(q.items) == 0) {
+		item, err := q.spill.read()
+		if err != nil {
+			q.spillErr = err
+			q.spill.count = 0
+			return
+		}
+		q.bytes += estimateSize(item)
+		q.items = append(q.items, item)
+	}
+}
+
+// spillError returns the error which lost the spilled items, if any. The crawl can't finish
+// without them.
+func (q *urlQueue) spillError() error {
+	q.RLock()
+	defer q.RUnlock()
+	return q.spillErr
+}
+
+// spilled returns the number of items waiting in the spill file. The lock must be held.
+func (q *urlQueue) spilled() int {
+	if q.spill == nil {
+		return 0
+	}
+	return q.spill.count
+}
+
+// closeSpill removes the spill file, if there is one.
+func (q *urlQueue) closeSpill() {
+	q.Lock()
+	defer q.Unlock()
+	if q.spill == nil {
+		return
+	}
+	if err := q.spill.close(); err != nil {
+		q.logger.Warn("Failed to remove queue spill file", zap.Error(err))
+	}
+	q.spill = nil
+}
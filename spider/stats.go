@@ -0,0 +1,110 @@
+package spider
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RunStats summarises a crawl.
+type RunStats struct {
+	// Pages is the number of pages which were fetched and parsed.
+	Pages int
+	// Errors is the number of pages which failed to be fetched or parsed.
+	Errors int
+	// Bytes is the total size of the pages which were fetched.
+	Bytes int64
+	// InFlight is the number of pages being crawled, and Queued is the number waiting to be.
+	// They are only set by Spider.Stats.
+	InFlight int
+	Queued   int
+	// Duration is how long the crawl ran for.
+	Duration time.Duration
+	// Err is the error which ended the crawl, if any.
+	Err error
+}
+
+// counters tracks the progress of a crawl. It is safe for concurrent use.
+type counters struct {
+	pages  int64
+	errors int64
+	bytes  int64
+	// inFlight isn't part of a snapshot, since it is always zero once a crawl has finished.
+	inFlight int64
+}
+
+func (c *counters) addPage() {
+	atomic.AddInt64(&c.pages, 1)
+}
+
+func (c *counters) addError() {
+	atomic.AddInt64(&c.errors, 1)
+}
+
+// startPage records that a page is being crawled, and returns a function to call when it's done.
+func (c *counters) startPage() func() {
+	atomic.AddInt64(&c.inFlight, 1)
+	return func() {
+		atomic.AddInt64(&c.inFlight, -1)
+	}
+}
+
+// addBytes adds to the number of bytes downloaded and returns the new total.
+func (c *counters) addBytes(n int64) int64 {
+	return atomic.AddInt64(&c.bytes, n)
+}
+
+// totalBytes returns the number of bytes downloaded so far.
+func (c *counters) totalBytes() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+// snapshot creates RunStats from the current counter values.
+func (c *counters) snapshot() RunStats {
+	return RunStats{
+		Pages:  int(atomic.LoadInt64(&c.pages)),
+		Errors: int(atomic.LoadInt64(&c.errors)),
+		Bytes:  atomic.LoadInt64(&c.bytes),
+	}
+}
+
+// Stats returns the progress of the crawl so far. It is safe to call at any time, including
+// while the spider is running. Duration and Err are only set on the stats passed to
+// WithOnComplete.
+func (s *Spider) Stats() RunStats {
+	stats := s.counters.snapshot()
+	stats.InFlight = int(atomic.LoadInt64(&s.counters.inFlight))
+	stats.Queued = s.queue.Len()
+	return stats
+}
+
+// startProgress calls the progress function every interval until the returned stop function is
+// called, which calls it one last time.
+func (s *Spider) startProgress(start time.Time) func() {
+	progress := func() {
+		stats := s.Stats()
+		stats.Duration = time.Since(start)
+		s.onProgress(stats)
+	}
+
+	ticker := time.NewTicker(s.progressInterval)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				progress()
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(stop)
+		<-stopped
+		progress()
+	}
+}
@@ -0,0 +1,72 @@
+package spider
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// statusShutdownTimeout is how long the status server has to finish requests once the crawl ends.
+const statusShutdownTimeout = time.Second * 5
+
+// Status is a snapshot of a running crawl, served as JSON by the status server.
+type Status struct {
+	QueueDepth int     `json:"queue_depth"`
+	InFlight   int     `json:"in_flight"`
+	Pages      int     `json:"pages"`
+	Errors     int     `json:"errors"`
+	Bytes      int64   `json:"bytes"`
+	Uptime     float64 `json:"uptime_seconds"`
+}
+
+// startStatusServer serves /healthz and /status on the given address until the returned
+// stop function is called.
+func (s *Spider) startStatusServer(addr string, start time.Time) (func(), error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start status server")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		stats := s.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(Status{
+			QueueDepth: stats.Queued,
+			InFlight:   stats.InFlight,
+			Pages:      stats.Pages,
+			Errors:     stats.Errors,
+			Bytes:      stats.Bytes,
+			Uptime:     time.Since(start).Seconds(),
+		})
+		if err != nil {
+			s.logger.Warn("Failed to write status", zap.Error(err))
+		}
+	})
+
+	server := &http.Server{Handler: mux}
+	go func() {
+		err := server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.logger.Error("Status server failed", zap.Error(err))
+		}
+	}()
+	s.logger.Info("Started status server", zap.String("addr", listener.Addr().String()))
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), statusShutdownTimeout)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			s.logger.Warn("Failed to shut down status server", zap.Error(err))
+		}
+	}, nil
+}
@@ -0,0 +1,54 @@
+package spider
+
+import (
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Willyham/gospider/spider/reporter"
+)
+
+// LastModStore remembers pages from previous crawls along with their sitemap lastmod, so that
+// pages which haven't changed since can be skipped. It must be safe for concurrent use.
+type LastModStore interface {
+	// Get returns the page as it was last crawled and the lastmod it had at the time.
+	Get(uri *url.URL) (page reporter.PageInfo, lastMod time.Time, ok bool)
+	// Put records a page crawled in this run.
+	Put(page reporter.PageInfo, lastMod time.Time)
+}
+
+type storedPage struct {
+	page    reporter.PageInfo
+	lastMod time.Time
+}
+
+// MemoryLastModStore is a LastModStore which keeps pages in memory. Reuse it between runs of
+// different spiders to skip unchanged pages.
+type MemoryLastModStore struct {
+	pages map[string]storedPage
+	sync.RWMutex
+}
+
+var _ LastModStore = new(MemoryLastModStore)
+
+// NewMemoryLastModStore creates an empty MemoryLastModStore.
+func NewMemoryLastModStore() *MemoryLastModStore {
+	return &MemoryLastModStore{
+		pages: make(map[string]storedPage),
+	}
+}
+
+// Get returns the page as it was last crawled and the lastmod it had at the time.
+func (m *MemoryLastModStore) Get(uri *url.URL) (reporter.PageInfo, time.Time, bool) {
+	m.RLock()
+	defer m.RUnlock()
+	stored, ok := m.pages[uri.String()]
+	return stored.page, stored.lastMod, ok
+}
+
+// Put records a page crawled in this run.
+func (m *MemoryLastModStore) Put(page reporter.PageInfo, lastMod time.Time) {
+	m.Lock()
+	defer m.Unlock()
+	m.pages[page.URL.String()] = storedPage{page: page, lastMod: lastMod}
+}
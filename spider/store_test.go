@@ -0,0 +1,23 @@
+package spider
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Willyham/gospider/spider/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryLastModStore(t *testing.T) {
+	store := NewMemoryLastModStore()
+	_, _, ok := store.Get(willydFoo)
+	assert.False(t, ok)
+
+	lastMod := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	store.Put(reporter.PageInfo{URL: willydFoo, StatusCode: 200}, lastMod)
+
+	page, storedLastMod, ok := store.Get(willydFoo)
+	assert.True(t, ok)
+	assert.Equal(t, 200, page.StatusCode)
+	assert.Equal(t, lastMod, storedLastMod)
+}
@@ -0,0 +1,96 @@
+package spider
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// TraceTimings are how long each stage of a request took, for WithHTTPTrace. Stages which didn't
+// happen, such as DNS and connecting when an idle connection is reused, are zero. If the request
+// was redirected, they're the timings of the last hop.
+type TraceTimings struct {
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	// TTFB is the time from starting the request until the first byte of the response.
+	TTFB time.Duration
+	// ReusedConn is true if the request was sent on an idle connection from an earlier one.
+	ReusedConn bool
+}
+
+// requestTrace collects the timings of a request. The hooks may be called from the transport's
+// own goroutines, so it is safe for concurrent use.
+type requestTrace struct {
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	timings      TraceTimings
+	sync.Mutex
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{start: time.Now()}
+}
+
+// clientTrace returns the hooks which record the timings.
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GetConn: func(string) {
+			t.Lock()
+			defer t.Unlock()
+			// Each redirect gets a new connection, so only time the last one.
+			t.start = time.Now()
+			t.timings = TraceTimings{}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.timings.ReusedConn = info.Reused
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.Lock()
+			defer t.Unlock()
+			t.timings.DNS = time.Since(t.dnsStart)
+		},
+		ConnectStart: func(string, string) {
+			t.Lock()
+			defer t.Unlock()
+			t.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			t.Lock()
+			defer t.Unlock()
+			t.timings.Connect = time.Since(t.connectStart)
+		},
+		TLSHandshakeStart: func() {
+			t.Lock()
+			defer t.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.Lock()
+			defer t.Unlock()
+			t.timings.TLS = time.Since(t.tlsStart)
+		},
+		GotFirstResponseByte: func() {
+			t.Lock()
+			defer t.Unlock()
+			t.timings.TTFB = time.Since(t.start)
+		},
+	}
+}
+
+// result returns the timings recorded so far.
+func (t *requestTrace) result() TraceTimings {
+	t.Lock()
+	defer t.Unlock()
+	return t.timings
+}
@@ -1,7 +1,10 @@
 package spider
 
 import (
+	"net"
 	"net/url"
+	"path"
+	"sort"
 	"strings"
 
 	"github.com/temoto/robotstxt"
@@ -30,6 +33,35 @@ func filter(predicate urlPredicate, urls []*url.URL) []*url.URL {
 	return output
 }
 
+// unique removes duplicate urls, keeping the first occurrence of each.
+func unique(urls []*url.URL) []*url.URL {
+	seen := make(map[string]bool, len(urls))
+	output := make([]*url.URL, 0, len(urls))
+	for _, url := range urls {
+		key := url.String()
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		output = append(output, url)
+	}
+	return output
+}
+
+// uniqueAssets removes duplicate assets, keeping the first occurrence of each.
+func uniqueAssets(assets []string) []string {
+	seen := make(map[string]bool, len(assets))
+	output := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		if seen[asset] {
+			continue
+		}
+		seen[asset] = true
+		output = append(output, asset)
+	}
+	return output
+}
+
 // createIsInternalPredicate creates a predicate which tests if the url is internal.
 // If we're following subdomains, we check based on the suffix of the host, otherwise
 // we exact match on the Hostname.
@@ -42,6 +74,49 @@ func createIsInternalPredicate(root *url.URL, followSubdomains bool) urlPredicat
 	}
 }
 
+// createHostListPredicate augments the internal predicate with explicit lists of hosts. Allowed
+// hosts are internal as well as those the predicate accepts, and denied hosts never are.
+func createHostListPredicate(internal urlPredicate, allowed []string, denied []string) urlPredicate {
+	allow := hostSet(allowed)
+	deny := hostSet(denied)
+	return func(input *url.URL) bool {
+		host := strings.ToLower(input.Hostname())
+		if deny[host] {
+			return false
+		}
+		return internal(input) || allow[host]
+	}
+}
+
+// hostSet creates a set of the lower cased hosts.
+func hostSet(hosts []string) map[string]bool {
+	set := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		set[strings.ToLower(host)] = true
+	}
+	return set
+}
+
+// createExcludeExtensionsPredicate creates a predicate which is false for URLs whose path ends
+// with one of the extensions. Extensions are matched case insensitively, with or without a dot.
+func createExcludeExtensionsPredicate(extensions []string) urlPredicate {
+	excluded := make(map[string]bool, len(extensions))
+	for _, ext := range extensions {
+		excluded["."+strings.TrimPrefix(strings.ToLower(ext), ".")] = true
+	}
+	return func(input *url.URL) bool {
+		return !excluded[strings.ToLower(path.Ext(input.Path))]
+	}
+}
+
+// createMaxQueryKeysPredicate creates a predicate which is false for URLs with more than max
+// distinct query parameters. Repeated parameters only count once.
+func createMaxQueryKeysPredicate(max int) urlPredicate {
+	return func(input *url.URL) bool {
+		return len(input.Query()) <= max
+	}
+}
+
 // createNotSeenPredicate creates a predicate which is true when a URL has not been
 // seen before, according to the given seener.
 func createNotSeenPredicate(seener Seener) urlPredicate {
@@ -50,8 +125,26 @@ func createNotSeenPredicate(seener Seener) urlPredicate {
 	}
 }
 
+// createOtherPagePredicate creates a predicate which is false for links to the page itself,
+// such as "#top", "#" or the page's own URL with a fragment, which would only loop back to it.
+func createOtherPagePredicate(page *url.URL) urlPredicate {
+	self := withoutFragment(page).String()
+	return func(input *url.URL) bool {
+		return withoutFragment(page.ResolveReference(input)).String() != self
+	}
+}
+
+// withoutFragment returns a copy of the URL without its fragment.
+func withoutFragment(input *url.URL) *url.URL {
+	output := *input
+	output.Fragment = ""
+	output.RawFragment = ""
+	return &output
+}
+
 // createShouldRequestByRobotsPredicate creates a predicate which tests if we should follow
-// a URL based on the info from the robots.txt.
+// a URL based on the info from the robots.txt. Only the group for the longest User-agent which
+// prefixes ua applies, e.g. "gospider" for "gospider/v1.0", falling back to the "*" group.
 func createShouldRequestByRobotsPredicate(ua string, r *robotstxt.RobotsData) urlPredicate {
 	return func(input *url.URL) bool {
 		if r == nil {
@@ -61,6 +154,15 @@ func createShouldRequestByRobotsPredicate(ua string, r *robotstxt.RobotsData) ur
 	}
 }
 
+// productToken returns the product portion of a user agent, e.g. "gospider" for
+// "gospider/v1.0".
+func productToken(ua string) string {
+	if i := strings.IndexAny(ua, "/ "); i >= 0 {
+		return ua[:i]
+	}
+	return ua
+}
+
 type urlTransform func(*url.URL) *url.URL
 
 // mapURLs transforms a collection of urls with the transform.
@@ -79,3 +181,125 @@ func createAbsoluteTransformer(root *url.URL) urlTransform {
 		return root.ResolveReference(input)
 	}
 }
+
+// createWWWTransformer creates a transform which rewrites links to the root's host with or
+// without a "www." prefix to use the root's host, so both are treated as the same site. Hosts
+// are compared case insensitively.
+func createWWWTransformer(root *url.URL) urlTransform {
+	bare := strings.TrimPrefix(strings.ToLower(root.Hostname()), "www.")
+	return func(input *url.URL) *url.URL {
+		host := strings.ToLower(input.Hostname())
+		if input.Hostname() == root.Hostname() || strings.TrimPrefix(host, "www.") != bare {
+			return input
+		}
+		output := *input
+		output.Host = root.Hostname()
+		if port := input.Port(); port != "" {
+			output.Host = net.JoinHostPort(output.Host, port)
+		}
+		return &output
+	}
+}
+
+// createUpgradeTransformer creates a transform which rewrites http links to https if they pass
+// the predicate. Links with an explicit port are left alone, since they are likely to be served
+// only over http.
+func createUpgradeTransformer(upgrade urlPredicate) urlTransform {
+	return func(input *url.URL) *url.URL {
+		if input.Scheme != "http" || input.Port() != "" || !upgrade(input) {
+			return input
+		}
+		output := *input
+		output.Scheme = "https"
+		return &output
+	}
+}
+
+// removeQuery is a transform which strips the query string, so that every variant of a path
+// is treated as the same page.
+func removeQuery(input *url.URL) *url.URL {
+	if input.RawQuery == "" && !input.ForceQuery {
+		return input
+	}
+	output := *input
+	output.RawQuery = ""
+	output.ForceQuery = false
+	return &output
+}
+
+// mixedContent returns the assets of an https page which would be loaded over http.
+func mixedContent(page *url.URL, assets []string) []string {
+	if page.Scheme != "https" {
+		return nil
+	}
+	var mixed []string
+	for _, asset := range assets {
+		uri, err := url.Parse(asset)
+		if err != nil {
+			continue
+		}
+		if page.ResolveReference(uri).Scheme == "http" {
+			mixed = append(mixed, asset)
+		}
+	}
+	return mixed
+}
+
+// thirdPartyHosts returns the unique hosts, other than the page's own, from its preconnect and
+// dns-prefetch hints, sorted.
+func thirdPartyHosts(page *url.URL, hosts []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, host := range hosts {
+		if seen[host] || strings.EqualFold(host, page.Host) {
+			continue
+		}
+		seen[host] = true
+		unique = append(unique, host)
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// resolveCanonical resolves the page's canonical URL against the page. It returns nil if the
+// page doesn't declare one.
+func resolveCanonical(page *url.URL, canonical *url.URL) *url.URL {
+	if canonical == nil {
+		return nil
+	}
+	return page.ResolveReference(canonical)
+}
+
+// createIndexTransformer creates a transform which rewrites the forms of a directory URL to
+// end in a slash, so that /dir, /dir/ and /dir/index.html are treated as the same page. Paths
+// whose last segment has an extension, other than an index page, are left alone.
+func createIndexTransformer(indexNames []string) urlTransform {
+	return func(input *url.URL) *url.URL {
+		dir, file := path.Split(input.Path)
+		collapsed := input.Path
+		switch {
+		case input.Path == "":
+			collapsed = "/"
+		case isIndexName(file, indexNames):
+			collapsed = dir
+		case file != "" && path.Ext(file) == "":
+			collapsed = input.Path + "/"
+		}
+		if collapsed == input.Path {
+			return input
+		}
+		output := *input
+		output.Path = collapsed
+		output.RawPath = ""
+		return &output
+	}
+}
+
+func isIndexName(file string, indexNames []string) bool {
+	for _, name := range indexNames {
+		if file == name {
+			return true
+		}
+	}
+	return false
+}
@@ -48,6 +48,26 @@ func TestIsInternalURLPredicate(t *testing.T) {
 	}
 }
 
+func TestUnique(t *testing.T) {
+	var urls []*url.URL
+	for _, uri := range []string{"/foo", "/bar", "/foo", "/baz", "/bar", "/foo"} {
+		parsed, err := url.Parse(uri)
+		require.NoError(t, err)
+		urls = append(urls, parsed)
+	}
+
+	res := unique(urls)
+	require.Len(t, res, 3)
+	assert.Equal(t, "/foo", res[0].String())
+	assert.Equal(t, "/bar", res[1].String())
+	assert.Equal(t, "/baz", res[2].String())
+}
+
+func TestUniqueAssets(t *testing.T) {
+	res := uniqueAssets([]string{"a.png", "b.js", "a.png", "c.css", "b.js"})
+	assert.Equal(t, []string{"a.png", "b.js", "c.css"}, res)
+}
+
 func TestNotSeenPredicate(t *testing.T) {
 	fooSeener := urlPredicate(func(input *url.URL) bool {
 		return strings.HasSuffix(input.String(), "foo")
@@ -109,6 +129,51 @@ func TestShoudRequestByRobotsPredicate(t *testing.T) {
 	}
 }
 
+func TestShouldRequestByRobotsAgentGroups(t *testing.T) {
+	robots, err := robotstxt.FromStatusAndString(200, `
+		User-agent: *
+		Disallow: /private/
+		Disallow: /drafts/
+
+		User-agent: Googlebot
+		Disallow: /
+
+		User-agent: gospider
+		Allow: /drafts/
+		Disallow: /search
+	`)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		agent    string
+		path     string
+		expected bool
+	}{
+		// Our group replaces the wildcard group entirely, rather than adding to it.
+		{"specific allow", userAgent, "/drafts/post", true},
+		{"specific disallow", userAgent, "/search", false},
+		{"wildcard rule ignored", userAgent, "/private/foo", true},
+		{"other agent's rule ignored", userAgent, "/foo", true},
+		{"case insensitive", "GoSpider/2.0", "/search", false},
+		{"wildcard fallback", "otherbot", "/private/foo", false},
+		{"wildcard fallback allow", "otherbot", "/search", true},
+	}
+
+	fooCom, err := url.Parse("http://foo.com")
+	require.NoError(t, err)
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			predicate := createShouldRequestByRobotsPredicate(test.agent, robots)
+
+			parsed, err := url.Parse(test.path)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, predicate(fooCom.ResolveReference(parsed)))
+		})
+	}
+}
+
 func TestShouldRequestByRobotsNil(t *testing.T) {
 	predicate := createShouldRequestByRobotsPredicate("foo", nil)
 	fooURL, err := url.Parse("/foo")
@@ -116,3 +181,260 @@ func TestShouldRequestByRobotsNil(t *testing.T) {
 
 	assert.True(t, predicate(fooURL))
 }
+
+func TestProductToken(t *testing.T) {
+	cases := []struct {
+		ua       string
+		expected string
+	}{
+		{"gospider/v1.0", "gospider"},
+		{"gospider", "gospider"},
+		{"Mozilla/5.0 (compatible; mybot/1.0)", "Mozilla"},
+		{"mybot (+https://example.com/bot)", "mybot"},
+	}
+	for _, test := range cases {
+		assert.Equal(t, test.expected, productToken(test.ua), test.ua)
+	}
+}
+
+func TestWWWTransformer(t *testing.T) {
+	cases := []struct {
+		name     string
+		root     string
+		uri      string
+		expected string
+	}{
+		{"www to bare", "http://willdemaine.co.uk", "http://www.willdemaine.co.uk/foo", "http://willdemaine.co.uk/foo"},
+		{"bare to www", "http://www.willdemaine.co.uk", "http://willdemaine.co.uk/foo", "http://www.willdemaine.co.uk/foo"},
+		{"same host", "http://willdemaine.co.uk", "http://willdemaine.co.uk/foo", "http://willdemaine.co.uk/foo"},
+		{"keeps port", "http://willdemaine.co.uk", "http://www.willdemaine.co.uk:8080/foo", "http://willdemaine.co.uk:8080/foo"},
+		{"upper case www", "http://willdemaine.co.uk", "http://WWW.WillDemaine.co.uk/foo", "http://willdemaine.co.uk/foo"},
+		{"upper case root", "http://WWW.willdemaine.co.uk", "http://willdemaine.co.uk/foo", "http://WWW.willdemaine.co.uk/foo"},
+		{"subdomain", "http://willdemaine.co.uk", "http://blog.willdemaine.co.uk/foo", "http://blog.willdemaine.co.uk/foo"},
+		{"external", "http://willdemaine.co.uk", "http://www.foo.co.uk/foo", "http://www.foo.co.uk/foo"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			root, err := url.Parse(test.root)
+			require.NoError(t, err)
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+
+			res := createWWWTransformer(root)(parsed)
+			assert.Equal(t, test.expected, res.String())
+		})
+	}
+}
+
+func TestUpgradeTransformer(t *testing.T) {
+	root, err := url.Parse("https://willdemaine.co.uk")
+	require.NoError(t, err)
+	upgrade := createUpgradeTransformer(createIsInternalPredicate(root, false))
+
+	cases := []struct {
+		name     string
+		uri      string
+		expected string
+	}{
+		{"http", "http://willdemaine.co.uk/foo?a=b", "https://willdemaine.co.uk/foo?a=b"},
+		{"https", "https://willdemaine.co.uk/foo", "https://willdemaine.co.uk/foo"},
+		{"explicit port", "http://willdemaine.co.uk:8080/foo", "http://willdemaine.co.uk:8080/foo"},
+		{"external", "http://foo.co.uk/foo", "http://foo.co.uk/foo"},
+		{"other scheme", "ftp://willdemaine.co.uk/foo", "ftp://willdemaine.co.uk/foo"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+
+			res := upgrade(parsed)
+			assert.Equal(t, test.expected, res.String())
+		})
+	}
+}
+
+func TestHostListPredicate(t *testing.T) {
+	testURL, err := url.Parse("http://willdemaine.co.uk")
+	require.NoError(t, err)
+
+	internal := createIsInternalPredicate(testURL, true)
+	denyOnly := createHostListPredicate(internal, nil, []string{"shop.willdemaine.co.uk"})
+	allowAndDeny := createHostListPredicate(internal,
+		[]string{"blog.willdemaine.co.uk", "cdn.example.com", "SHOP.willdemaine.co.uk"},
+		[]string{"Shop.willdemaine.co.uk"},
+	)
+	allowOnly := createHostListPredicate(createIsInternalPredicate(testURL, false),
+		[]string{"blog.willdemaine.co.uk"}, nil,
+	)
+
+	cases := []struct {
+		name     string
+		pred     urlPredicate
+		uri      string
+		expected bool
+	}{
+		{"root", denyOnly, "/foo", true},
+		{"subdomain", denyOnly, "http://blog.willdemaine.co.uk", true},
+		{"denied", denyOnly, "http://shop.willdemaine.co.uk", false},
+		{"external", denyOnly, "http://cdn.example.com", false},
+
+		{"root (allow)", allowAndDeny, "/foo", true},
+		{"allowed (allow)", allowAndDeny, "http://blog.willdemaine.co.uk", true},
+		{"subdomain (allow)", allowAndDeny, "http://www.willdemaine.co.uk", true},
+		{"allowed external (allow)", allowAndDeny, "http://cdn.example.com", true},
+		{"denied wins (allow)", allowAndDeny, "http://shop.willdemaine.co.uk", false},
+		{"external (allow)", allowAndDeny, "http://example.com", false},
+
+		{"root (no subdomains)", allowOnly, "/foo", true},
+		{"allowed (no subdomains)", allowOnly, "http://blog.willdemaine.co.uk", true},
+		{"subdomain (no subdomains)", allowOnly, "http://www.willdemaine.co.uk", false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+			resolved := testURL.ResolveReference(parsed)
+			assert.Equal(t, test.expected, test.pred(resolved))
+		})
+	}
+}
+
+func TestExcludeExtensionsPredicate(t *testing.T) {
+	pred := createExcludeExtensionsPredicate([]string{".pdf", "ZIP"})
+
+	cases := []struct {
+		uri      string
+		expected bool
+	}{
+		{"http://willdemaine.co.uk/", true},
+		{"http://willdemaine.co.uk/page.html", true},
+		{"http://willdemaine.co.uk/file.pdf", false},
+		{"http://willdemaine.co.uk/FILE.PDF", false},
+		{"http://willdemaine.co.uk/archive.zip?download=1", false},
+		{"http://willdemaine.co.uk/pdf", true},
+	}
+
+	for _, test := range cases {
+		t.Run(test.uri, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, pred(parsed))
+		})
+	}
+}
+
+func TestMixedContent(t *testing.T) {
+	assets := []string{"/main.css", "//cdn.example.com/app.js", "http://cdn.example.com/a.png", "https://cdn.example.com/b.png", "HTTP://cdn.example.com/c.png"}
+
+	secure, err := url.Parse("https://willdemaine.co.uk/foo")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"http://cdn.example.com/a.png", "HTTP://cdn.example.com/c.png"}, mixedContent(secure, assets))
+
+	insecure, err := url.Parse("http://willdemaine.co.uk/foo")
+	require.NoError(t, err)
+	assert.Empty(t, mixedContent(insecure, assets))
+}
+
+func TestIndexTransformer(t *testing.T) {
+	collapse := createIndexTransformer([]string{"index.html", "default.aspx"})
+
+	cases := []struct {
+		name     string
+		uri      string
+		expected string
+	}{
+		{"root", "http://willdemaine.co.uk", "http://willdemaine.co.uk/"},
+		{"directory", "http://willdemaine.co.uk/dir/", "http://willdemaine.co.uk/dir/"},
+		{"no slash", "http://willdemaine.co.uk/dir", "http://willdemaine.co.uk/dir/"},
+		{"index", "http://willdemaine.co.uk/dir/index.html", "http://willdemaine.co.uk/dir/"},
+		{"other index", "http://willdemaine.co.uk/dir/default.aspx?a=b", "http://willdemaine.co.uk/dir/?a=b"},
+		{"root index", "http://willdemaine.co.uk/index.html", "http://willdemaine.co.uk/"},
+		{"file", "http://willdemaine.co.uk/dir/about.html", "http://willdemaine.co.uk/dir/about.html"},
+		{"not an index", "http://willdemaine.co.uk/dir/index.php", "http://willdemaine.co.uk/dir/index.php"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, collapse(parsed).String())
+		})
+	}
+}
+
+func TestRemoveQuery(t *testing.T) {
+	cases := []struct {
+		name     string
+		uri      string
+		expected string
+	}{
+		{"no query", "http://willdemaine.co.uk/page", "http://willdemaine.co.uk/page"},
+		{"query", "http://willdemaine.co.uk/page?a=1&b=2", "http://willdemaine.co.uk/page"},
+		{"empty query", "http://willdemaine.co.uk/page?", "http://willdemaine.co.uk/page"},
+		{"fragment", "http://willdemaine.co.uk/page?a=1#top", "http://willdemaine.co.uk/page#top"},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, removeQuery(parsed).String())
+			assert.Equal(t, test.uri, parsed.String(), "input was modified")
+		})
+	}
+}
+
+func TestMaxQueryKeysPredicate(t *testing.T) {
+	predicate := createMaxQueryKeysPredicate(2)
+
+	cases := []struct {
+		name     string
+		uri      string
+		expected bool
+	}{
+		{"no query", "/foo", true},
+		{"at limit", "/foo?a=1&b=2", true},
+		{"repeated keys", "/foo?a=1&a=2&a=3&b=4", true},
+		{"over limit", "/foo?a=1&b=2&c=3", false},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, predicate(parsed))
+		})
+	}
+}
+
+func TestOtherPagePredicate(t *testing.T) {
+	page, err := url.Parse("http://willdemaine.co.uk/about?tab=bio#intro")
+	require.NoError(t, err)
+	pred := createOtherPagePredicate(page)
+
+	cases := []struct {
+		name     string
+		uri      string
+		expected bool
+	}{
+		{"fragment", "#top", false},
+		{"empty fragment", "#", false},
+		{"empty", "", false},
+		{"self with fragment", "/about?tab=bio#team", false},
+		{"absolute self", "http://willdemaine.co.uk/about?tab=bio", false},
+		{"other query", "?tab=jobs#top", true},
+		{"other path", "/contact#form", true},
+		{"other host", "http://example.com/about?tab=bio", true},
+	}
+
+	for _, test := range cases {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := url.Parse(test.uri)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, pred(parsed))
+		})
+	}
+}